@@ -17,7 +17,11 @@ func NewDNSProbe(host string, fail bool) DNSProbe {
 }
 
 func (p DNSProbe) Run(ctx context.Context) error {
-	if _, err := p.r.LookupHost(ctx, p.host); err != nil {
+	end := startPhase(ctx, "dns", p.host)
+	_, err := p.r.LookupHost(ctx, p.host)
+	end()
+
+	if err != nil {
 		if p.fail {
 			return nil
 		}
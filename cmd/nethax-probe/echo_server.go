@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// EchoServer is an HTTP server answering every request with the
+// request line, used as a temporary destination for tests. As it
+// accepts any connection it also serves TCP tests.
+type EchoServer struct {
+	addrs []string
+}
+
+func NewEchoServer(addrs []string) EchoServer {
+	return EchoServer{
+		addrs: addrs,
+	}
+}
+
+// Run listens on the addresses of the server and serves them until ctx
+// is done.
+func (s EchoServer) Run(ctx context.Context) error {
+	var ls []net.Listener
+	for _, addr := range s.addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range ls {
+				l.Close() //nolint:errcheck
+			}
+			return fmt.Errorf("listening on %s: %w", addr, err)
+		}
+		ls = append(ls, l)
+	}
+
+	return s.Serve(ctx, ls...)
+}
+
+// Serve serves the listeners until ctx is done, or one of them fails.
+func (s EchoServer) Serve(ctx context.Context, ls ...net.Listener) error {
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Println("Request:", r.RemoteAddr, r.Method, r.Host, r.URL)
+			fmt.Fprintf(w, "%s %s %s\n", r.Method, r.URL, r.Proto) //nolint:errcheck
+		}),
+	}
+
+	errs := make(chan error, len(ls))
+	var wg sync.WaitGroup
+	for _, l := range ls {
+		fmt.Println("Listening on", l.Addr())
+		wg.Go(func() {
+			errs <- srv.Serve(l)
+		})
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	srv.Close() //nolint:errcheck
+	wg.Wait()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	return err
+}
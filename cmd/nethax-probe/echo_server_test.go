@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestEchoServer(t *testing.T) {
+	var ls []net.Listener
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error creating listener: %v", err)
+		}
+		ls = append(ls, l)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+
+	done := make(chan error)
+	go func() {
+		done <- NewEchoServer(nil).Serve(ctx, ls...)
+	}()
+
+	for _, l := range ls {
+		if err := NewHTTPProbe("http://"+l.Addr().String()+"/foo", http.StatusOK).Run(t.Context()); err != nil {
+			t.Fatalf("HTTP probe of %s failed: %v", l.Addr(), err)
+		}
+		if err := NewTCPProbe(l.Addr().String(), false).Run(t.Context()); err != nil {
+			t.Fatalf("TCP probe of %s failed: %v", l.Addr(), err)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the listeners are closed once the server stops
+	if err := NewTCPProbe(ls[0].Addr().String(), true).Run(t.Context()); err != nil {
+		t.Fatalf("expecting connection to fail, got %v", err)
+	}
+
+	t.Run("listen error", func(t *testing.T) {
+		if err := NewEchoServer([]string{"nethax:-1"}).Run(t.Context()); err == nil {
+			t.Fatal("expecting error, got nil")
+		}
+	})
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
+	"sync"
 )
 
 var _ Probe = &HTTPProbe{}
@@ -27,7 +30,7 @@ func NewHTTPProbeWithClient(url string, status int, client *http.Client) *HTTPPr
 }
 
 func (p *HTTPProbe) Run(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	req, err := http.NewRequestWithContext(withClientTrace(ctx), http.MethodGet, p.url, nil)
 	if err != nil {
 		return err
 	}
@@ -51,3 +54,36 @@ func (p *HTTPProbe) Run(ctx context.Context) error {
 
 	return nil
 }
+
+// withClientTrace returns a context recording the phases of an HTTP
+// request: DNS lookup, connections, TLS handshake, and waiting for the
+// response once the request is written.
+func withClientTrace(ctx context.Context) context.Context {
+	var mu sync.Mutex
+	ends := make(map[string]func()) // by phase name, and address for connections
+
+	start := func(key, name, addr string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ends[key] = startPhase(ctx, name, addr)
+	}
+	end := func(key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if end, ok := ends[key]; ok {
+			end()
+			delete(ends, key)
+		}
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:             func(info httptrace.DNSStartInfo) { start("dns", "dns", info.Host) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { end("dns") },
+		ConnectStart:         func(_, addr string) { start("connect "+addr, "connect", addr) },
+		ConnectDone:          func(_, addr string, _ error) { end("connect " + addr) },
+		TLSHandshakeStart:    func() { start("tls", "tls", "") },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { end("tls") },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { start("wait", "wait", "") },
+		GotFirstResponseByte: func() { end("wait") },
+	})
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	pf "github.com/grafana/nethax/pkg/probeflags"
@@ -22,39 +26,55 @@ var (
 	expectedStatus int
 	testType       string
 	expectFail     bool
+	listen         string
+	retries        int
+	retryInterval  time.Duration
+	repeat         int
+	minSuccessRate float64
+	specJSON       string
 )
 
 func main() {
+	flag.StringVar(&specJSON, pf.ArgSpec, "", "Spec of the probe in JSON, as sent by the runner, replacing the other flags. Defaults to the "+pf.EnvSpec+" environment variable.")
 	flag.StringVar(&url, pf.ArgURL, "", "URL or host:port to connect to")
-	flag.DurationVar(&timeout, pf.ArgTimeout, 5*time.Second, "Timeout value (e.g. 5s, 1m)")
+	flag.DurationVar(&timeout, pf.ArgTimeout, pf.DefaultTimeout, "Timeout value (e.g. 5s, 1m)")
 	flag.IntVar(&expectedStatus, pf.ArgExpectedStatus, 200, "Expected HTTP status code (0 for connection failure)")
-	flag.StringVar(&testType, pf.ArgType, pf.TestTypeHTTP, "Type of test (http or tcp)")
+	flag.StringVar(&testType, pf.ArgType, pf.TestTypeHTTP, "Type of test (http, tcp or dns)")
 	flag.BoolVar(&expectFail, pf.ArgExpectFail, false, "Whether the test is expected to fail (TCP and DNS tests only)")
+	flag.StringVar(&listen, pf.ArgListen, "", "Comma separated addresses to run an echo server on until terminated, instead of probing (e.g. :8080,:9090)")
+	flag.IntVar(&retries, pf.ArgRetries, 0, "Number of times to retry a failed attempt")
+	flag.DurationVar(&retryInterval, pf.ArgRetryInterval, pf.DefaultRetryInterval, "Time to wait before retrying a failed attempt")
+	flag.IntVar(&repeat, pf.ArgRepeat, 1, "Number of times to repeat the probe, each with its own retries and timeout")
+	flag.Float64Var(&minSuccessRate, pf.ArgMinSuccessRate, 1, "Ratio of repetitions that must pass for the probe to pass (0 to 1)")
 	flag.Parse()
 
-	if url == "" {
-		fmt.Println("Error: URL must be specified")
-		os.Exit(exitCodeFailure)
-	}
+	if listen != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-	var probe Probe
+		if err := NewEchoServer(strings.Split(listen, ",")).Run(ctx); err != nil {
+			fmt.Println("Echo server failed:", err)
+			os.Exit(exitCodeFailure)
+		}
+		os.Exit(exitCodeSuccess)
+	}
 
-	switch testType {
-	case pf.TestTypeTCP:
-		probe = NewTCPProbe(url, expectFail)
-	case pf.TestTypeHTTP:
-		probe = NewHTTPProbe(url, expectedStatus)
-	case pf.TestTypeDNS:
-		probe = NewDNSProbe(url, expectFail)
-	default:
-		fmt.Println("Error: Invalid test type: ", testType)
+	spec, err := probeSpec()
+	if err != nil {
+		fmt.Println("Error:", err)
 		os.Exit(exitCodeConfigError)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	probe, a := newProbe(spec)
+
+	t := new(timings)
+	start := time.Now()
+	res, err := a.run(withTimings(context.Background(), t), probe)
+	// read by the runner to trace the probe, and tell flaky tests apart
+	fmt.Println(t.result(start, time.Now()))
+	fmt.Println(res)
 
-	if err := probe.Run(ctx); err != nil {
+	if err != nil {
 		fmt.Println("Probe failed unexpectedly:", err)
 		os.Exit(exitCodeFailure)
 	}
@@ -62,3 +82,50 @@ func main() {
 	fmt.Println("Probe succeeded")
 	os.Exit(exitCodeSuccess)
 }
+
+// probeSpec returns the spec of the probe: the one sent by the runner
+// with --spec or the environment, or else the one of the other flags.
+func probeSpec() (pf.Spec, error) {
+	if data := cmp.Or(specJSON, os.Getenv(pf.EnvSpec)); data != "" {
+		return pf.DecodeSpec(data)
+	}
+
+	spec := pf.Spec{
+		Version:        pf.SpecVersion,
+		Type:           testType,
+		Endpoint:       url,
+		Timeout:        timeout,
+		ExpectedStatus: expectedStatus,
+		ExpectFail:     expectFail,
+		Retries:        retries,
+		RetryInterval:  retryInterval,
+		Repeat:         repeat,
+		MinSuccessRate: minSuccessRate,
+	}
+	return spec, spec.Validate()
+}
+
+// newProbe returns the probe of a valid spec, and the attempts to run
+// it with.
+func newProbe(spec pf.Spec) (Probe, attempts) {
+	var probe Probe
+
+	switch spec.Type {
+	case pf.TestTypeTCP:
+		probe = NewTCPProbe(spec.Endpoint, spec.ExpectFail)
+	case pf.TestTypeHTTP:
+		probe = NewHTTPProbe(spec.Endpoint, spec.ExpectedStatus)
+	case pf.TestTypeDNS:
+		probe = NewDNSProbe(spec.Endpoint, spec.ExpectFail)
+	}
+
+	a := attempts{
+		timeout:        cmp.Or(spec.Timeout, pf.DefaultTimeout),
+		repeat:         max(spec.Repeat, 1),
+		retries:        spec.Retries,
+		retryInterval:  cmp.Or(spec.RetryInterval, pf.DefaultRetryInterval),
+		minSuccessRate: cmp.Or(spec.MinSuccessRate, 1),
+	}
+
+	return probe, a
+}
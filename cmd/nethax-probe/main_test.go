@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
+)
+
+func TestProbeSpec(t *testing.T) {
+	spec := pf.Spec{Type: pf.TestTypeDNS, Endpoint: "cart.shop", Timeout: time.Second, ExpectFail: true}
+
+	t.Run("argument", func(t *testing.T) {
+		specJSON = spec.Encode()
+		t.Cleanup(func() { specJSON = "" })
+
+		got, err := probeSpec()
+		if err != nil || got.Type != pf.TestTypeDNS || !got.ExpectFail {
+			t.Errorf("expecting the DNS spec, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(pf.EnvSpec, spec.Encode())
+
+		got, err := probeSpec()
+		if err != nil || got.Endpoint != "cart.shop" {
+			t.Errorf("expecting the spec of the environment, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("version mismatch", func(t *testing.T) {
+		t.Setenv(pf.EnvSpec, `{"version":99,"type":"tcp","endpoint":"cart:80"}`)
+
+		if _, err := probeSpec(); !errors.Is(err, pf.ErrSpecVersion) {
+			t.Errorf("expecting error %v, got %v", pf.ErrSpecVersion, err)
+		}
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		url, testType, timeout, repeat, minSuccessRate = "cart:80", pf.TestTypeTCP, time.Second, 1, 1
+		t.Cleanup(func() { url, testType = "", "" })
+
+		got, err := probeSpec()
+		if err != nil || got.Type != pf.TestTypeTCP || got.Endpoint != "cart:80" {
+			t.Errorf("expecting the spec of the flags, got %+v (%v)", got, err)
+		}
+	})
+}
+
+func TestNewProbe(t *testing.T) {
+	for typ, exp := range map[string]Probe{
+		pf.TestTypeHTTP: NewHTTPProbe("cart", http.StatusOK),
+		pf.TestTypeTCP:  NewTCPProbe("cart", true),
+		pf.TestTypeDNS:  NewDNSProbe("cart", true),
+	} {
+		spec := pf.Spec{Type: typ, Endpoint: "cart", ExpectedStatus: http.StatusOK, ExpectFail: true}
+		probe, _ := newProbe(spec)
+		if !reflect.DeepEqual(exp, probe) {
+			t.Errorf("%s: expecting probe %#v, got %#v", typ, exp, probe)
+		}
+	}
+
+	_, a := newProbe(pf.Spec{Type: pf.TestTypeTCP, Endpoint: "cart:80"})
+	exp := attempts{timeout: pf.DefaultTimeout, repeat: 1, retryInterval: pf.DefaultRetryInterval, minSuccessRate: 1}
+	if a != exp {
+		t.Errorf("expecting default attempts %+v, got %+v", exp, a)
+	}
+
+	spec := pf.Spec{Type: pf.TestTypeTCP, Endpoint: "cart:80", Timeout: time.Second, Retries: 2, RetryInterval: time.Minute, Repeat: 3, MinSuccessRate: 0.5}
+	_, a = newProbe(spec)
+	exp = attempts{timeout: time.Second, repeat: 3, retries: 2, retryInterval: time.Minute, minSuccessRate: 0.5}
+	if a != exp {
+		t.Errorf("expecting attempts %+v, got %+v", exp, a)
+	}
+}
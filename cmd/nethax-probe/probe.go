@@ -3,6 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
 )
 
 type Probe interface {
@@ -13,4 +18,99 @@ var (
 	errConnectionSucceeded = errors.New("connection succeeded when expecting a failure")
 	errConnectionFailed    = errors.New("connection failed")
 	errAssertionFailed     = errors.New("assertion failed")
+	errSuccessRateTooLow   = errors.New("success rate too low")
 )
+
+// attempts configures how many times a probe runs.
+type attempts struct {
+	// timeout is the timeout of each attempt.
+	timeout time.Duration
+	// repeat is the number of times to repeat the probe, and retries
+	// how many times to retry a repetition that fails, waiting
+	// retryInterval between attempts.
+	repeat        int
+	retries       int
+	retryInterval time.Duration
+	// minSuccessRate is the ratio of repetitions that must pass for
+	// the probe to pass.
+	minSuccessRate float64
+}
+
+// run runs probe with the given attempts, returning their outcome and
+// an error if too few repetitions passed. A probe run only once
+// returns its error as-is.
+func (a attempts) run(ctx context.Context, probe Probe) (pf.Attempts, error) {
+	res := pf.Attempts{Repetitions: max(a.repeat, 1)}
+
+	var lastErr error
+	for range res.Repetitions {
+		for try := range a.retries + 1 {
+			if try > 0 {
+				select {
+				case <-ctx.Done():
+					return res, ctx.Err()
+				case <-time.After(a.retryInterval):
+				}
+			}
+
+			res.Attempts++
+
+			actx, cancel := context.WithTimeout(ctx, a.timeout)
+			err := probe.Run(actx)
+			cancel()
+
+			if err == nil {
+				res.Passed++
+				break
+			}
+			lastErr = err
+		}
+	}
+
+	if res.Repetitions == 1 && res.Passed == 0 {
+		return res, lastErr
+	}
+	if rate := float64(res.Passed) / float64(res.Repetitions); rate < a.minSuccessRate {
+		return res, fmt.Errorf("%w: %d of %d repetitions passed: %w", errSuccessRateTooLow, res.Passed, res.Repetitions, lastErr)
+	}
+
+	return res, nil
+}
+
+// timings records the phases of a probe run. Phases can be recorded
+// concurrently, e.g. when dialing several addresses.
+type timings struct {
+	mu     sync.Mutex
+	phases []pf.Phase
+}
+
+type timingsKey struct{}
+
+// withTimings returns a context in which probes record their phases
+// to t.
+func withTimings(ctx context.Context, t *timings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+// startPhase records the start of a phase for addr in the timings of
+// ctx, if any, and returns a function recording its end.
+func startPhase(ctx context.Context, name, addr string) func() {
+	t, ok := ctx.Value(timingsKey{}).(*timings)
+	if !ok {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.phases = append(t.phases, pf.Phase{Name: name, Addr: addr, Start: start, End: time.Now()})
+	}
+}
+
+// result returns the timings of a probe run between start and end.
+func (t *timings) result(start, end time.Time) pf.Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return pf.Timings{Start: start, End: end, Phases: t.phases}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
+)
+
+func TestTimings(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tests := map[string]struct {
+		probe  Probe
+		phases []string
+	}{
+		"tcp":  {NewTCPProbe(ts.Listener.Addr().String(), false), []string{"connect " + ts.Listener.Addr().String()}},
+		"http": {NewHTTPProbe(ts.URL, http.StatusOK), []string{"connect " + ts.Listener.Addr().String(), "wait "}},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			tm := new(timings)
+			start := time.Now()
+
+			if err := tt.probe.Run(withTimings(t.Context(), tm)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			res := tm.result(start, time.Now())
+
+			var phases []string
+			for _, p := range res.Phases {
+				phases = append(phases, p.Name+" "+p.Addr)
+				if p.Start.Before(res.Start) || p.End.After(res.End) || p.End.Before(p.Start) {
+					t.Errorf("phase %s from %v to %v out of the probe run from %v to %v", p.Name, p.Start, p.End, res.Start, res.End)
+				}
+			}
+			if !slices.Equal(tt.phases, phases) {
+				t.Errorf("expecting phases %q, got %q", tt.phases, phases)
+			}
+
+			if !strings.HasPrefix(res.String(), "nethax-timings: {") {
+				t.Errorf("unexpected timings line %q", res.String())
+			}
+		})
+	}
+}
+
+// flakyProbe fails the attempts whose index is in failures.
+type flakyProbe struct {
+	failures map[int]bool
+	n        *int
+}
+
+func (p flakyProbe) Run(context.Context) error {
+	defer func() { *p.n++ }()
+	if p.failures[*p.n] {
+		return errConnectionFailed
+	}
+	return nil
+}
+
+func TestAttempts(t *testing.T) {
+	tests := map[string]struct {
+		attempts attempts
+		failures []int
+		exp      pf.Attempts
+		err      error
+	}{
+		"once": {
+			attempts: attempts{repeat: 1, minSuccessRate: 1},
+			exp:      pf.Attempts{Repetitions: 1, Passed: 1, Attempts: 1},
+		},
+		"once failing": {
+			attempts: attempts{repeat: 1, minSuccessRate: 1},
+			failures: []int{0},
+			exp:      pf.Attempts{Repetitions: 1, Attempts: 1},
+			err:      errConnectionFailed,
+		},
+		"retried": {
+			attempts: attempts{repeat: 1, retries: 2, minSuccessRate: 1},
+			failures: []int{0, 1},
+			exp:      pf.Attempts{Repetitions: 1, Passed: 1, Attempts: 3},
+		},
+		"retries exhausted": {
+			attempts: attempts{repeat: 1, retries: 1, minSuccessRate: 1},
+			failures: []int{0, 1},
+			exp:      pf.Attempts{Repetitions: 1, Attempts: 2},
+			err:      errConnectionFailed,
+		},
+		"repeated": {
+			attempts: attempts{repeat: 4, minSuccessRate: 0.75},
+			failures: []int{2},
+			exp:      pf.Attempts{Repetitions: 4, Passed: 3, Attempts: 4},
+		},
+		"success rate too low": {
+			attempts: attempts{repeat: 4, minSuccessRate: 1},
+			failures: []int{2},
+			exp:      pf.Attempts{Repetitions: 4, Passed: 3, Attempts: 4},
+			err:      errSuccessRateTooLow,
+		},
+		"repeated with retries": {
+			attempts: attempts{repeat: 2, retries: 1, minSuccessRate: 1},
+			failures: []int{0, 2},
+			exp:      pf.Attempts{Repetitions: 2, Passed: 2, Attempts: 4},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			p := flakyProbe{failures: make(map[int]bool), n: new(int)}
+			for _, i := range tt.failures {
+				p.failures[i] = true
+			}
+
+			tt.attempts.timeout = time.Second
+			res, err := tt.attempts.run(t.Context(), p)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expecting error %v, got %v", tt.err, err)
+			}
+			if tt.exp != res {
+				t.Errorf("expecting %+v, got %+v", tt.exp, res)
+			}
+		})
+	}
+}
@@ -23,7 +23,9 @@ func NewTCPProbe(addr string, fail bool) TCPProbe {
 func (p TCPProbe) Run(ctx context.Context) error {
 	var d net.Dialer
 
+	end := startPhase(ctx, "connect", p.addr)
 	cn, err := d.DialContext(ctx, "tcp", p.addr)
+	end()
 	if err != nil {
 		if p.fail {
 			return nil
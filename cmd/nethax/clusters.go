@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+)
+
+// executeClusters runs plan with run in each of the clusters of the
+// given clients, in parallel, returning their reports in the same
+// order. The output of each run is buffered, and written once it
+// finishes so runs don't interleave.
+func executeClusters(ctx context.Context, clients []*kubernetes.Kubernetes, run func(context.Context, *kubernetes.Kubernetes) *Report) []*Report {
+	reports := make([]*Report, len(clients))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for i, k := range clients {
+		wg.Go(func() {
+			var buf bytes.Buffer
+			reports[i] = run(withOutput(ctx, &buf), k)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			indent(ctx, 0, "Cluster: %s", k.Context())
+			newline(ctx)
+			output(ctx).Write(buf.Bytes()) //nolint:errcheck
+		})
+	}
+
+	wg.Wait()
+
+	return reports
+}
+
+// Outcomes of a test in a cluster.
+const (
+	outcomePassed = "passed"
+	outcomeFailed = "failed"
+	outcomeNotRun = "not run"
+)
+
+// testOutcome returns the outcome of the test of the given target in
+// report: passed if it passed from all its sources, not run if it has
+// no results, e.g. because its target selected no pods.
+func testOutcome(report *Report, target, test string) string {
+	outcome := outcomeNotRun
+	for _, res := range report.Results {
+		if res.Target != target || res.Test.Name != test {
+			continue
+		}
+		if !res.Passed() {
+			return outcomeFailed
+		}
+		outcome = outcomePassed
+	}
+	return outcome
+}
+
+// divergence is a test whose outcome differs across clusters.
+type divergence struct {
+	Target, Test string
+	// Clusters holds the clusters of each outcome.
+	Clusters map[string][]string
+}
+
+func (d divergence) String() string {
+	var outcomes []string
+	for _, o := range []string{outcomePassed, outcomeFailed, outcomeNotRun} {
+		if cs := d.Clusters[o]; len(cs) > 0 {
+			outcomes = append(outcomes, o+" on "+strings.Join(cs, ", "))
+		}
+	}
+	return fmt.Sprintf("%s / %s: %s", d.Target, d.Test, strings.Join(outcomes, "; "))
+}
+
+// compareClusters returns the tests of plan whose outcome differs
+// across the reports of its runs in different clusters.
+func compareClusters(plan *TestPlan, reports []*Report) []divergence {
+	var divs []divergence
+
+	for _, target := range plan.TestTargets {
+		for _, test := range target.Tests {
+			clusters := make(map[string][]string)
+			for _, r := range reports {
+				o := testOutcome(r, target.Name, test.Name)
+				clusters[o] = append(clusters[o], r.Cluster)
+			}
+
+			if len(clusters) > 1 {
+				divs = append(divs, divergence{Target: target.Name, Test: test.Name, Clusters: clusters})
+			}
+		}
+	}
+
+	return divs
+}
+
+// printComparison prints the outcome of the run of plan in each
+// cluster, and the tests whose outcome differs across clusters.
+func printComparison(ctx context.Context, plan *TestPlan, reports []*Report) {
+	indent(ctx, 0, "Cluster Comparison:")
+
+	var tests int
+	for _, target := range plan.TestTargets {
+		tests += len(target.Tests)
+	}
+
+	for _, r := range reports {
+		var failed int
+		for _, target := range plan.TestTargets {
+			for _, test := range target.Tests {
+				if testOutcome(r, target.Name, test.Name) != outcomePassed {
+					failed++
+				}
+			}
+		}
+
+		status := "PASSED"
+		if !r.Passed() {
+			status = "FAILED"
+		}
+		indent(ctx, 1, "Cluster %s: %s (%d passed, %d failed or not run, %d error(s))",
+			r.Cluster, status, tests-failed, failed, len(r.Errors)+len(r.StepErrors))
+	}
+
+	divs := compareClusters(plan, reports)
+	if len(divs) > 0 {
+		indent(ctx, 1, "Diverging tests:")
+	}
+	for _, d := range divs {
+		indent(ctx, 2, "%s", d)
+	}
+
+	newline(ctx)
+	indent(ctx, 0, "%d of %d test(s) diverge across %d cluster(s)", len(divs), tests, len(reports))
+}
+
+// planClusters returns the kubeconfig contexts to run plan in, without
+// duplicates: the given contexts if any, or else the clusters of the
+// plan. It returns a single blank context for the in-cluster or current
+// context if neither is set.
+func planClusters(contexts []string, plan *TestPlan) []string {
+	if len(contexts) == 0 {
+		contexts = plan.Clusters
+	}
+	if len(contexts) == 0 {
+		return []string{""}
+	}
+
+	var res []string
+	for _, c := range contexts {
+		if !slices.Contains(res, c) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPlanClusters(t *testing.T) {
+	plan := &TestPlan{Clusters: []string{"prod-eu", "prod-us"}}
+
+	tests := map[string]struct {
+		contexts []string
+		plan     *TestPlan
+		exp      []string
+	}{
+		"none":       {nil, &TestPlan{}, []string{""}},
+		"plan":       {nil, plan, []string{"prod-eu", "prod-us"}},
+		"flags":      {[]string{"dev"}, plan, []string{"dev"}},
+		"duplicates": {[]string{"dev", "prod-eu", "dev"}, plan, []string{"dev", "prod-eu"}},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			if g := planClusters(tt.contexts, tt.plan); !slices.Equal(tt.exp, g) {
+				t.Errorf("expecting %q, got %q", tt.exp, g)
+			}
+		})
+	}
+}
+
+func TestExecuteClusters(t *testing.T) {
+	k := kubernetes.NewWithClient(testClient.NewClientset())
+	for _, name := range []string{"prod-eu", "prod-us", "dev"} {
+		k.AddCluster(name, kubernetes.NewWithClient(testClient.NewClientset()))
+	}
+
+	var clients []*kubernetes.Kubernetes
+	for _, name := range []string{"prod-eu", "prod-us", "dev"} {
+		c, err := k.Cluster(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clients = append(clients, c)
+	}
+
+	plan := &TestPlan{
+		TestTargets: []TestTarget{
+			{Name: "frontend", Tests: []Test{{Name: "cart"}, {Name: "internet"}}},
+			{Name: "admin", Tests: []Test{{Name: "cart"}}},
+		},
+	}
+
+	// internet access is only blocked in dev, and admin pods only run
+	// in prod-us
+	run := func(ctx context.Context, k *kubernetes.Kubernetes) *Report {
+		for i := range 3 {
+			indent(ctx, 1, "%s line %d", k.Context(), i)
+		}
+
+		report := &Report{Cluster: k.Context()}
+		add := func(target, test string, exitCode int32) {
+			report.Results = append(report.Results, TestResult{Target: target, Test: Test{Name: test}, ExitCode: exitCode})
+		}
+
+		add("frontend", "cart", 0)
+		if k.Context() == "dev" {
+			add("frontend", "internet", 1)
+		} else {
+			add("frontend", "internet", 0)
+		}
+		if k.Context() == "prod-us" {
+			add("admin", "cart", 0)
+		} else {
+			report.Errors = append(report.Errors, TargetError{Target: "admin", Err: errNoReadyPods})
+		}
+
+		return report
+	}
+
+	var out bytes.Buffer
+	ctx := withOutput(t.Context(), &out)
+
+	reports := executeClusters(ctx, clients, run)
+
+	t.Run("reports", func(t *testing.T) {
+		for i, name := range []string{"prod-eu", "prod-us", "dev"} {
+			if reports[i].Cluster != name {
+				t.Errorf("expecting report %d of cluster %s, got %s", i, name, reports[i].Cluster)
+			}
+		}
+	})
+
+	t.Run("output", func(t *testing.T) {
+		// the output of each cluster is not interleaved with others
+		for _, name := range []string{"prod-eu", "prod-us", "dev"} {
+			exp := "Cluster: " + name + "\n\n " + name + " line 0\n " + name + " line 1\n " + name + " line 2\n"
+			if !strings.Contains(out.String(), exp) {
+				t.Errorf("expecting output of %s to be grouped, got\n%s", name, out.String())
+			}
+		}
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		var got []string
+		for _, d := range compareClusters(plan, reports) {
+			got = append(got, d.String())
+		}
+
+		exp := []string{
+			"frontend / internet: passed on prod-eu, prod-us; failed on dev",
+			"admin / cart: passed on prod-us; not run on prod-eu, dev",
+		}
+		if !slices.Equal(exp, got) {
+			t.Errorf("expecting divergences\n%q\ngot\n%q", exp, got)
+		}
+	})
+
+	t.Run("print", func(t *testing.T) {
+		var out bytes.Buffer
+		printComparison(withOutput(t.Context(), &out), plan, reports)
+
+		for _, exp := range []string{
+			" Cluster prod-us: PASSED (3 passed, 0 failed or not run, 0 error(s))",
+			" Cluster dev: FAILED (1 passed, 2 failed or not run, 1 error(s))",
+			"2 of 3 test(s) diverge across 3 cluster(s)",
+		} {
+			if !strings.Contains(out.String(), exp) {
+				t.Errorf("expecting output to contain %q, got\n%s", exp, out.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/ptr"
+)
+
+// networkTestPlanGVR is the resource of NetworkTestPlan objects,
+// defined by the CRD in deploy/networktestplan-crd.yaml.
+var networkTestPlanGVR = schema.GroupVersionResource{Group: "nethax.grafana.com", Version: "v1alpha1", Resource: "networktestplans"}
+
+// Condition and reasons of the status of NetworkTestPlan objects.
+const (
+	conditionPassed = "Passed"
+
+	reasonTestsPassed = "TestsPassed"
+	reasonTestsFailed = "TestsFailed"
+	reasonRunErrors   = "RunErrors"
+	reasonInvalidSpec = "InvalidSpec"
+)
+
+var (
+	errStepFiles            = errors.New("steps of NetworkTestPlan objects cannot use files")
+	errIncludes             = errors.New("NetworkTestPlan objects cannot include files")
+	errFieldNotAllowed      = errors.New("field not allowed in NetworkTestPlan objects")
+	errOtherNamespace       = errors.New("NetworkTestPlan objects can only test their own namespace")
+	errProbeImageNotAllowed = errors.New("probe image not allowed in NetworkTestPlan objects")
+)
+
+// restrictedFields are the fields of plans that reach beyond the
+// namespace of a NetworkTestPlan, or run other code than the probe,
+// which objects can only set if the controller allows them with
+// --allow-fields.
+var restrictedFields = []string{"nodeSelector", "allNamespaces", "namespaceSelector", "cluster", "steps"}
+
+// planPolicy is what the plans of NetworkTestPlan objects can do besides
+// testing the pods of their own namespace with the default probe image,
+// as the controller can act on every namespace and node.
+type planPolicy struct {
+	// Fields are the restrictedFields plans can set.
+	Fields []string
+	// ProbeImages are the probe images plans can use besides
+	// kubernetes.DefaultProbeImage.
+	ProbeImages []string
+}
+
+// check returns an error if plan, of a NetworkTestPlan object in
+// namespace, does something the policy doesn't allow.
+func (p planPolicy) check(plan *TestPlan, namespace string) error {
+	notAllowed := func(field string, set bool) error {
+		if set && !slices.Contains(p.Fields, field) {
+			return fmt.Errorf("%w: %s", errFieldNotAllowed, field)
+		}
+		return nil
+	}
+	image := func(image string) error {
+		if image != "" && image != kubernetes.DefaultProbeImage && !slices.Contains(p.ProbeImages, image) {
+			return fmt.Errorf("%w: %s", errProbeImageNotAllowed, image)
+		}
+		return nil
+	}
+
+	if err := notAllowed("steps", len(plan.Setup) > 0 || len(plan.Teardown) > 0); err != nil {
+		return err
+	}
+
+	for _, t := range plan.TestTargets {
+		err := errors.Join(
+			notAllowed("nodeSelector", t.NodeSelector != nil),
+			notAllowed("allNamespaces", t.AllNamespaces),
+			notAllowed("namespaceSelector", t.NamespaceSelector != ""),
+			notAllowed("cluster", t.Cluster != "" || t.Destination != nil && t.Destination.Cluster != ""),
+		)
+		if t.Namespace != "" && t.Namespace != namespace {
+			err = errors.Join(err, fmt.Errorf("%w: %s", errOtherNamespace, t.Namespace))
+		}
+		if t.Destination != nil {
+			if t.Destination.Namespace != namespace {
+				err = errors.Join(err, fmt.Errorf("%w: destination in %s", errOtherNamespace, t.Destination.Namespace))
+			}
+			err = errors.Join(err, image(t.Destination.ProbeImage))
+		}
+		for _, test := range t.Tests {
+			err = errors.Join(err, image(test.ProbeImage))
+		}
+		if err != nil {
+			return fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// NetworkTestPlanStatus is the status of a NetworkTestPlan object.
+type NetworkTestPlanStatus struct {
+	// ObservedGeneration is the generation of the spec last run, or
+	// found invalid.
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"`
+	LastRunTime        *metav1.Time        `json:"lastRunTime,omitempty"`
+	LastRunDuration    string              `json:"lastRunDuration,omitempty"`
+	Results            []NetworkTestResult `json:"results,omitempty"`
+	// Errors are the errors that prevented targets or steps from
+	// running.
+	Errors     []string           `json:"errors,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// NetworkTestResult is the result of a test of a NetworkTestPlan, from
+// all the pods or nodes it ran from.
+type NetworkTestResult struct {
+	Target        string `json:"target"`
+	Test          string `json:"test"`
+	Passed        bool   `json:"passed"`
+	Sources       int    `json:"sources"`
+	FailedSources int    `json:"failedSources,omitempty"`
+	// FlakySources is the number of sources some attempts of the
+	// probe passed from and others failed.
+	FlakySources int `json:"flakySources,omitempty"`
+	// Message describes the first failure, or why the test did not
+	// run.
+	Message string `json:"message,omitempty"`
+}
+
+// Controller returns the controller command
+func Controller() *cobra.Command {
+	var (
+		defaultProbeImage, kontext string
+		namespace, otlpEndpoint    string
+		resync                     time.Duration
+		policy                     planPolicy
+		outcomes                   outcomeOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "controller",
+		Short: "Run the test plans of NetworkTestPlan objects",
+		Long: `Run the test plans of NetworkTestPlan objects when they are created or
+changed, and then on their interval, writing the results of each run to
+their status. Plans are run one after the other, until terminated.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are printed as they happen, with their own exit
+			// codes
+			cmd.SilenceErrors, cmd.SilenceUsage = true, true
+
+			if resync <= 0 {
+				cmd.Println("Error: resync must be positive")
+				return exitCode(exitCodeConfigError)
+			}
+			for _, f := range policy.Fields {
+				if !slices.Contains(restrictedFields, f) {
+					cmd.Printf("Error: unknown field %q in --allow-fields, expecting any of %v\n", f, restrictedFields)
+					return exitCode(exitCodeConfigError)
+				}
+			}
+
+			k, err := kubernetes.New(kontext)
+			if err != nil {
+				cmd.Printf("Error creating Kubernetes client: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			kubernetes.DefaultProbeImage = defaultProbeImage
+
+			shutdownTracing, err := setupTracing(cmd, otlpEndpoint)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+			defer shutdownTracing()
+
+			runController(cmd.Context(), k, namespace, resync, policy, outcomes)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&kontext, "context", "c", "", "Kubernetes context to connect. Leave empty for in-cluster context.")
+
+	cmd.Flags().StringVar(&defaultProbeImage,
+		"default-probe-image",
+		kubernetes.DefaultProbeImage,
+		"Default probe image to use if test plan doesn't specify one.",
+	)
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace to watch NetworkTestPlan objects in. Leave empty for all namespaces.")
+	cmd.Flags().DurationVar(&resync, "resync", 30*time.Second, "Time between two checks for NetworkTestPlan objects to run")
+	cmd.Flags().StringSliceVar(&policy.Fields, "allow-fields", nil,
+		fmt.Sprintf("Fields NetworkTestPlan objects can set that reach beyond their namespace, any of %v", restrictedFields))
+	cmd.Flags().StringSliceVar(&policy.ProbeImages, "allowed-probe-images", nil, "Probe images NetworkTestPlan objects can use besides the default one")
+
+	addOutcomeFlags(cmd, &outcomes)
+	addTracingFlags(cmd, &otlpEndpoint)
+
+	return cmd
+}
+
+// runController reconciles the NetworkTestPlan objects of namespace
+// every resync until ctx is done.
+func runController(ctx context.Context, k *kubernetes.Kubernetes, namespace string, resync time.Duration, policy planPolicy, outcomes outcomeOptions) {
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	for {
+		reconcilePlans(ctx, k, namespace, policy, outcomes)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcilePlans runs the NetworkTestPlan objects of namespace that
+// are due.
+func reconcilePlans(ctx context.Context, k *kubernetes.Kubernetes, namespace string, policy planPolicy, outcomes outcomeOptions) {
+	objs, err := k.ListResources(ctx, networkTestPlanGVR, namespace)
+	if err != nil {
+		indent(ctx, 0, "Error: %v", err)
+		newline(ctx)
+		return
+	}
+
+	for i := range objs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := reconcilePlan(ctx, k, &objs[i], time.Now(), policy, outcomes); err != nil {
+			indent(ctx, 0, "Error: %v", err)
+			newline(ctx)
+		}
+	}
+}
+
+// reconcilePlan runs the test plan of obj if it is due at now, and
+// updates its status with the results. Invalid specs are reported in
+// the status once per generation.
+func reconcilePlan(ctx context.Context, k *kubernetes.Kubernetes, obj *unstructured.Unstructured, now time.Time, policy planPolicy, outcomes outcomeOptions) error {
+	status, err := planStatus(obj)
+	if err != nil {
+		return err
+	}
+
+	generation := obj.GetGeneration()
+
+	plan, interval, err := planFromObject(obj, policy)
+	if err != nil {
+		if status.ObservedGeneration == generation {
+			return nil
+		}
+
+		indent(ctx, 0, "Invalid NetworkTestPlan %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+		newline(ctx)
+
+		status.ObservedGeneration = generation
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionPassed,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: generation,
+			Reason:             reasonInvalidSpec,
+			Message:            err.Error(),
+		})
+		return updatePlanStatus(ctx, k, obj, status)
+	}
+
+	if !planDue(status, generation, interval, now) {
+		return nil
+	}
+
+	start := time.Now()
+	report := executeTest(ctx, k, plan)
+	duration := time.Since(start)
+
+	// don't record interrupted runs, they are run again on restart
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	recordOutcomes(ctx, k, report, start, outcomes)
+
+	status.setReport(plan, report, generation, start, duration)
+
+	// the object may have changed during the run, in which case the
+	// update conflicts and the new generation is run on the next
+	// resync
+	return updatePlanStatus(ctx, k, obj, status)
+}
+
+// planFromObject returns the test plan of a NetworkTestPlan object,
+// and the interval it runs on, zero if it only runs when changed. The
+// spec of the object holds the fields of a test plan, and its interval.
+//
+// The plan is named after the object unless it has a name. Targets
+// that don't select a namespace run in the namespace of the object, as
+// do their destinations, and plans doing more than the policy allows
+// are rejected.
+func planFromObject(obj *unstructured.Unstructured, policy planPolicy) (*TestPlan, time.Duration, error) {
+	spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading spec: %w", err)
+	}
+
+	var interval time.Duration
+	if s, ok := spec["interval"].(string); ok {
+		if interval, err = time.ParseDuration(s); err != nil {
+			return nil, 0, fmt.Errorf("invalid interval: %w", err)
+		}
+		delete(spec, "interval")
+	}
+
+	b, err := yaml.Marshal(map[string]any{"testPlan": spec})
+	if err != nil {
+		return nil, 0, fmt.Errorf("encoding spec: %w", err)
+	}
+
+	plan, err := ParseTestPlan(bytes.NewReader(b))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// there is no file for paths to be relative to
+	if len(plan.Include) > 0 {
+		return nil, 0, errIncludes
+	}
+	for _, s := range slices.Concat(plan.Setup, plan.Teardown) {
+		if len(s.Files) > 0 {
+			return nil, 0, fmt.Errorf("step %q: %w", s.Name, errStepFiles)
+		}
+	}
+
+	namespace := obj.GetNamespace()
+
+	if plan.Name == "" {
+		plan.Name = namespace + "/" + obj.GetName()
+	}
+
+	for i := range plan.TestTargets {
+		t := &plan.TestTargets[i]
+		if t.Namespace == "" && !t.PerNamespace() {
+			t.Namespace = namespace
+		}
+		if t.Destination != nil && t.Destination.Namespace == "" {
+			t.Destination.Namespace = namespace
+		}
+	}
+
+	if err := policy.check(plan, namespace); err != nil {
+		return nil, 0, err
+	}
+
+	return plan, interval, nil
+}
+
+// planDue returns whether a NetworkTestPlan with the given status
+// should run at now: when it never ran, its spec changed since, or its
+// interval elapsed.
+func planDue(status NetworkTestPlanStatus, generation int64, interval time.Duration, now time.Time) bool {
+	if status.LastRunTime == nil || status.ObservedGeneration != generation {
+		return true
+	}
+	return interval > 0 && !now.Before(status.LastRunTime.Add(interval))
+}
+
+// planStatus returns the status of a NetworkTestPlan object.
+func planStatus(obj *unstructured.Unstructured) (NetworkTestPlanStatus, error) {
+	var status NetworkTestPlanStatus
+
+	m, _, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil {
+		return status, fmt.Errorf("reading status of %s/%s: %w", obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &status); err != nil {
+		return status, fmt.Errorf("reading status of %s/%s: %w", obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	return status, nil
+}
+
+// updatePlanStatus sets the status of a NetworkTestPlan object.
+func updatePlanStatus(ctx context.Context, k *kubernetes.Kubernetes, obj *unstructured.Unstructured, status NetworkTestPlanStatus) error {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("encoding status: %w", err)
+	}
+
+	obj = obj.DeepCopy()
+	obj.Object["status"] = m
+
+	_, err = k.UpdateResourceStatus(ctx, networkTestPlanGVR, obj)
+	return err
+}
+
+// setReport sets the results of a run of plan, of the given
+// generation, on s.
+func (s *NetworkTestPlanStatus) setReport(plan *TestPlan, report *Report, generation int64, start time.Time, duration time.Duration) {
+	s.ObservedGeneration = generation
+	s.LastRunTime = ptr.To(metav1.NewTime(start))
+	s.LastRunDuration = duration.Round(time.Millisecond).String()
+
+	type testKey struct{ target, test string }
+
+	s.Results = nil
+	index := make(map[testKey]int)
+	for _, target := range plan.TestTargets {
+		for _, test := range target.Tests {
+			index[testKey{target.Name, test.Name}] = len(s.Results)
+			s.Results = append(s.Results, NetworkTestResult{Target: target.Name, Test: test.Name})
+		}
+	}
+
+	for _, res := range report.Results {
+		i, ok := index[testKey{res.Target, res.Test.Name}]
+		if !ok {
+			continue
+		}
+
+		r := &s.Results[i]
+		r.Sources++
+		if res.Flaky() {
+			r.FlakySources++
+		}
+		if res.Passed() {
+			continue
+		}
+
+		r.FailedSources++
+		if r.Message == "" {
+			if res.Err != nil {
+				r.Message = fmt.Sprintf("%s: %s error: %v", res.Source(), res.ErrKind, res.Err)
+			} else {
+				r.Message = fmt.Sprintf("%s: exit code %d", res.Source(), res.ExitCode)
+			}
+		}
+	}
+
+	var failed int
+	for i := range s.Results {
+		r := &s.Results[i]
+		r.Passed = r.Sources > 0 && r.FailedSources == 0
+		if r.Sources == 0 {
+			r.Message = "not run"
+		}
+		if !r.Passed {
+			failed++
+		}
+	}
+
+	s.Errors = nil
+	for _, e := range report.Errors {
+		if e.Namespace != "" {
+			s.Errors = append(s.Errors, fmt.Sprintf("target %s in namespace %s: %s error: %v", e.Target, e.Namespace, e.Kind, e.Err))
+		} else {
+			s.Errors = append(s.Errors, fmt.Sprintf("target %s: %s error: %v", e.Target, e.Kind, e.Err))
+		}
+	}
+	for _, e := range report.StepErrors {
+		s.Errors = append(s.Errors, fmt.Sprintf("%s step %s: %s error: %v", e.Stage, e.Step, e.Kind, e.Err))
+	}
+
+	cond := metav1.Condition{
+		Type:               conditionPassed,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reasonTestsPassed,
+		Message:            fmt.Sprintf("%d test(s) passed", len(s.Results)),
+	}
+	if !report.Passed() {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonTestsFailed
+		cond.Message = fmt.Sprintf("%d of %d test(s) failed, %d error(s)", failed, len(s.Results), len(s.Errors))
+		if report.Errored() {
+			// e.g. missing permissions, rather than the network
+			cond.Reason = reasonRunErrors
+		}
+	}
+	meta.SetStatusCondition(&s.Conditions, cond)
+}
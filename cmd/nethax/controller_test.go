@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	pf "github.com/grafana/nethax/pkg/probeflags"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	dynamicClient "k8s.io/client-go/dynamic/fake"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+// networkTestPlan returns a NetworkTestPlan object with the given
+// spec, in YAML.
+func networkTestPlan(t *testing.T, name string, generation int64, spec string) *unstructured.Unstructured {
+	t.Helper()
+
+	var s map[string]any
+	if err := yaml.Unmarshal([]byte(spec), &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]any{"spec": s}}
+	obj.SetAPIVersion("nethax.grafana.com/v1alpha1")
+	obj.SetKind("NetworkTestPlan")
+	obj.SetNamespace("shop")
+	obj.SetName(name)
+	obj.SetGeneration(generation)
+
+	return obj
+}
+
+func TestPlanFromObject(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		obj := networkTestPlan(t, "checkout", 1, `
+interval: 10m
+testTargets:
+- name: checkout
+  podSelector:
+    mode: all
+    labels: app=checkout
+  tests:
+  - name: cart
+    endpoint: cart:8080
+    type: tcp
+    timeout: 3s
+- name: all
+  allNamespaces: true
+  destination:
+    ports: [8080]
+  tests:
+  - name: echo
+    type: tcp
+    timeout: 1s
+`)
+
+		plan, interval, err := planFromObject(obj, planPolicy{Fields: []string{"allNamespaces"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if interval != 10*time.Minute {
+			t.Errorf("expecting interval 10m, got %s", interval)
+		}
+		if plan.Name != "shop/checkout" {
+			t.Errorf("expecting plan shop/checkout, got %s", plan.Name)
+		}
+
+		checkout, all := plan.TestTargets[0], plan.TestTargets[1]
+		if checkout.Namespace != "shop" {
+			t.Errorf("expecting namespace shop, got %q", checkout.Namespace)
+		}
+		if checkout.Tests[0].Timeout != 3*time.Second || checkout.Tests[0].Type != TestTypeTCP {
+			t.Errorf("unexpected test %+v", checkout.Tests[0])
+		}
+		if all.Namespace != "" {
+			t.Errorf("expecting no namespace, got %q", all.Namespace)
+		}
+		if all.Destination.Namespace != "shop" {
+			t.Errorf("expecting destination namespace shop, got %q", all.Destination.Namespace)
+		}
+	})
+
+	t.Run("no interval", func(t *testing.T) {
+		obj := networkTestPlan(t, "checkout", 1, `
+name: checkout egress
+testTargets: []
+`)
+
+		plan, interval, err := planFromObject(obj, planPolicy{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if interval != 0 {
+			t.Errorf("expecting no interval, got %s", interval)
+		}
+		if plan.Name != "checkout egress" {
+			t.Errorf("expecting plan checkout egress, got %s", plan.Name)
+		}
+	})
+
+	for name, spec := range map[string]string{
+		"invalid interval": "interval: often\ntestTargets: []",
+		"unknown field":    "testTargets: []\nschedule: '* * * * *'",
+		"step files":       "testTargets: []\nsetup:\n- name: policies\n  files: [policies.yaml]",
+		"includes":         "testTargets: []\ninclude: [common.yaml]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := planFromObject(networkTestPlan(t, "checkout", 1, spec), planPolicy{Fields: restrictedFields}); err == nil {
+				t.Fatal("expecting error, got nil")
+			}
+		})
+	}
+}
+
+func TestPlanFromObject_Policy(t *testing.T) {
+	const target = `
+testTargets:
+- name: checkout
+  podSelector:
+    labels: app=checkout
+  tests:
+  - name: cart
+    endpoint: cart:8080
+    type: tcp
+`
+	allowAll := planPolicy{Fields: restrictedFields, ProbeImages: []string{"registry.example/probe:v2"}}
+
+	for name, tt := range map[string]struct {
+		spec string
+		err  error
+		// allowed is whether allowAll allows the spec
+		allowed bool
+	}{
+		"own namespace":         {target + "  namespace: shop\n", nil, true},
+		"other namespace":       {target + "  namespace: kube-system\n", errOtherNamespace, false},
+		"default namespace":     {"defaults:\n  namespace: billing\n" + target, errOtherNamespace, false},
+		"destination namespace": {target + "  destination:\n    namespace: billing\n    ports: [8080]\n", errOtherNamespace, false},
+		"node selector":         {"testTargets:\n- name: nodes\n  nodeSelector:\n    labels: role=worker\n  tests: []\n", errFieldNotAllowed, true},
+		"all namespaces":        {target + "  allNamespaces: true\n", errFieldNotAllowed, true},
+		"namespace selector":    {target + "  namespaceSelector: team=shop\n", errFieldNotAllowed, true},
+		"cluster":               {target + "  cluster: prod-us\n", errFieldNotAllowed, true},
+		"destination cluster":   {target + "  destination:\n    cluster: prod-us\n    ports: [8080]\n", errFieldNotAllowed, true},
+		"steps":                 {target + "setup:\n- name: policies\n  manifests: |\n    kind: ConfigMap\n", errFieldNotAllowed, true},
+		"default probe image":   {target + "    probeImage: " + kubernetes.DefaultProbeImage + "\n", nil, true},
+		"probe image":           {target + "    probeImage: registry.example/probe:v2\n", errProbeImageNotAllowed, true},
+		"destination image":     {target + "  destination:\n    ports: [8080]\n    probeImage: attacker/miner\n", errProbeImageNotAllowed, false},
+		"defaults probe image":  {"defaults:\n  probeImage: attacker/miner\n" + target, errProbeImageNotAllowed, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := planFromObject(networkTestPlan(t, "checkout", 1, tt.spec), planPolicy{})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expecting error %v, got %v", tt.err, err)
+			}
+
+			_, _, err = planFromObject(networkTestPlan(t, "checkout", 1, tt.spec), allowAll)
+			if tt.allowed != (err == nil) {
+				t.Errorf("expecting allowed %v with all fields allowed, got %v", tt.allowed, err)
+			}
+		})
+	}
+}
+
+func TestPlanDue(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	lastRun := &metav1.Time{Time: now.Add(-10 * time.Minute)}
+
+	tests := map[string]struct {
+		status   NetworkTestPlanStatus
+		interval time.Duration
+		exp      bool
+	}{
+		"never ran":        {NetworkTestPlanStatus{}, 0, true},
+		"invalid spec":     {NetworkTestPlanStatus{ObservedGeneration: 2}, 0, true},
+		"changed":          {NetworkTestPlanStatus{ObservedGeneration: 1, LastRunTime: lastRun}, 0, true},
+		"no interval":      {NetworkTestPlanStatus{ObservedGeneration: 2, LastRunTime: lastRun}, 0, false},
+		"interval elapsed": {NetworkTestPlanStatus{ObservedGeneration: 2, LastRunTime: lastRun}, 10 * time.Minute, true},
+		"interval pending": {NetworkTestPlanStatus{ObservedGeneration: 2, LastRunTime: lastRun}, 15 * time.Minute, false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if g := planDue(tt.status, 2, tt.interval, now); tt.exp != g {
+				t.Errorf("expecting %v, got %v", tt.exp, g)
+			}
+		})
+	}
+}
+
+func TestReconcilePlan(t *testing.T) {
+	ctx := context.Background()
+
+	valid := networkTestPlan(t, "checkout", 1, `
+interval: 10m
+testTargets:
+- name: checkout
+  podSelector:
+    mode: all
+    labels: app=checkout
+  tests:
+  - name: cart
+    endpoint: cart:8080
+    type: tcp
+    timeout: 3s
+`)
+	invalid := networkTestPlan(t, "frontend", 3, "interval: often\ntestTargets: []")
+
+	scheme := runtime.NewScheme()
+	dyn := dynamicClient.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{networkTestPlanGVR: "NetworkTestPlanList"},
+		valid, invalid,
+	)
+	k := kubernetes.NewWithClients(testClient.NewClientset(), dyn, nil)
+
+	get := func(name string) NetworkTestPlanStatus {
+		t.Helper()
+		obj, err := dyn.Resource(networkTestPlanGVR).Namespace("shop").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		status, err := planStatus(obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return status
+	}
+
+	now := time.Now()
+
+	t.Run("run", func(t *testing.T) {
+		if err := reconcilePlan(ctx, k, valid, now, planPolicy{}, outcomeOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		status := get("checkout")
+		if status.ObservedGeneration != 1 || status.LastRunTime == nil {
+			t.Errorf("expecting generation 1 to have run, got %+v", status)
+		}
+
+		// no pods are running, so the test cannot run
+		exp := NetworkTestResult{Target: "checkout", Test: "cart", Message: "not run"}
+		if len(status.Results) != 1 || status.Results[0] != exp {
+			t.Errorf("expecting results %+v, got %+v", exp, status.Results)
+		}
+		if len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "target checkout in namespace shop: selection error: ") {
+			t.Errorf("expecting selection error of the target, got %v", status.Errors)
+		}
+
+		// the network is not to blame
+		cond := meta.FindStatusCondition(status.Conditions, conditionPassed)
+		if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != reasonRunErrors {
+			t.Errorf("expecting failed condition, got %+v", cond)
+		}
+	})
+
+	t.Run("not due", func(t *testing.T) {
+		obj, _ := dyn.Resource(networkTestPlanGVR).Namespace("shop").Get(ctx, "checkout", metav1.GetOptions{})
+		lastRun := get("checkout").LastRunTime
+
+		if err := reconcilePlan(ctx, k, obj, now.Add(5*time.Minute), planPolicy{}, outcomeOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if g := get("checkout").LastRunTime; !g.Equal(lastRun) {
+			t.Errorf("expecting plan not to run again, last run %s", g)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if err := reconcilePlan(ctx, k, invalid, now, planPolicy{}, outcomeOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		status := get("frontend")
+		if status.ObservedGeneration != 3 || status.LastRunTime != nil {
+			t.Errorf("expecting generation 3 to be observed without running, got %+v", status)
+		}
+
+		cond := meta.FindStatusCondition(status.Conditions, conditionPassed)
+		if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != reasonInvalidSpec {
+			t.Errorf("expecting invalid spec condition, got %+v", cond)
+		}
+	})
+}
+
+func TestNetworkTestPlanStatus_SetReport(t *testing.T) {
+	plan := &TestPlan{
+		TestTargets: []TestTarget{
+			{Name: "frontend", Tests: []Test{{Name: "cart"}, {Name: "internet"}}},
+		},
+	}
+
+	result := func(pod, test string, exitCode int32, err error) TestResult {
+		return TestResult{Target: "frontend", Node: pod, Test: Test{Name: test}, ExitCode: exitCode, Err: err}
+	}
+
+	flaky := result("node-1", "cart", 0, nil)
+	flaky.Attempts = &pf.Attempts{Repetitions: 1, Passed: 1, Attempts: 2}
+
+	report := &Report{
+		Results: []TestResult{
+			flaky,
+			result("node-2", "cart", 0, nil),
+			result("node-1", "internet", 0, nil),
+			result("node-2", "internet", 1, nil),
+			result("node-3", "internet", -1, errors.New("probe failed")),
+		},
+	}
+
+	var status NetworkTestPlanStatus
+	status.setReport(plan, report, 4, time.Now(), 1500*time.Millisecond)
+
+	exp := []NetworkTestResult{
+		{Target: "frontend", Test: "cart", Passed: true, Sources: 2, FlakySources: 1},
+		{Target: "frontend", Test: "internet", Sources: 3, FailedSources: 2, Message: "node/node-2: exit code 1"},
+	}
+	if len(status.Results) != len(exp) {
+		t.Fatalf("expecting %d results, got %d", len(exp), len(status.Results))
+	}
+	for i, e := range exp {
+		if g := status.Results[i]; e != g {
+			t.Errorf("expecting %+v, got %+v", e, g)
+		}
+	}
+
+	if status.LastRunDuration != "1.5s" {
+		t.Errorf("expecting duration 1.5s, got %s", status.LastRunDuration)
+	}
+
+	cond := meta.FindStatusCondition(status.Conditions, conditionPassed)
+	if cond == nil || cond.Message != "1 of 2 test(s) failed, 0 error(s)" || cond.ObservedGeneration != 4 {
+		t.Errorf("unexpected condition %+v", cond)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// untilPassInterval is how long to wait before rerunning the tests
+// that failed, with --until-pass.
+const untilPassInterval = 2 * time.Second
+
+type untilPassKey struct{}
+
+// withUntilPass returns a copy of ctx in which the tests that fail are
+// rerun until they pass or deadline.
+func withUntilPass(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, untilPassKey{}, deadline)
+}
+
+// untilPassDeadline returns the deadline until which the tests that
+// fail in the run of ctx are rerun, if they are.
+func untilPassDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(untilPassKey{}).(time.Time)
+	return deadline, ok
+}
+
+// rerunUntilPass reruns the tests of results that failed from source,
+// replacing their results, until they pass or deadline. starts holds
+// when each test first ran, to measure how long it took to pass. Tests
+// that could not run are not rerun, as their errors don't come from
+// the network.
+func rerunUntilPass(ctx context.Context, deadline time.Time, source TestResult, results []TestResult, starts []time.Time, probe prober) {
+	for i := range results {
+		results[i].Runs = 1
+		if results[i].Passed() {
+			results[i].ConvergedAfter = results[i].Duration
+		}
+	}
+
+	for {
+		var failed []int
+		for i, r := range results {
+			if r.Err == nil && !r.Passed() {
+				failed = append(failed, i)
+			}
+		}
+		if len(failed) == 0 || time.Now().Add(untilPassInterval).After(deadline) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(untilPassInterval):
+		}
+
+		indent(ctx, 2, "Rerunning %d failed test(s), %s until the deadline", len(failed), time.Until(deadline).Round(time.Second))
+		newline(ctx)
+
+		for _, i := range failed {
+			runs := results[i].Runs
+			results[i] = runTest(ctx, source, results[i].Test, probe)
+			results[i].Runs = runs + 1
+			if results[i].Passed() {
+				results[i].ConvergedAfter = time.Since(starts[i])
+			}
+		}
+	}
+}
+
+// printConvergence prints how long each test of report took to pass
+// from all its sources with --until-pass, or how many of its sources
+// it still failed from at the deadline.
+func printConvergence(ctx context.Context, report *Report) {
+	type convergence struct {
+		target, test    string
+		sources, failed int
+		runs            int
+		after           time.Duration
+	}
+
+	var tests []*convergence
+	index := make(map[[2]string]*convergence)
+
+	for _, res := range report.Results {
+		key := [2]string{res.Target, res.Test.Name}
+		c, ok := index[key]
+		if !ok {
+			c = &convergence{target: res.Target, test: res.Test.Name}
+			index[key] = c
+			tests = append(tests, c)
+		}
+
+		c.sources++
+		c.runs = max(c.runs, res.Runs)
+		if res.Passed() {
+			c.after = max(c.after, res.ConvergedAfter)
+		} else {
+			c.failed++
+		}
+	}
+
+	indent(ctx, 0, "Convergence:")
+	for _, c := range tests {
+		if c.failed > 0 {
+			indent(ctx, 1, "%s / %s: NOT CONVERGED, failing from %d of %d source(s) after %d run(s)", c.target, c.test, c.failed, c.sources, c.runs)
+			continue
+		}
+		indent(ctx, 1, "%s / %s: converged after %s (%d run(s))", c.target, c.test, c.after.Round(time.Millisecond), c.runs)
+	}
+	newline(ctx)
+}
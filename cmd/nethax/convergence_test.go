@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
+)
+
+func TestRunTests_UntilPass(t *testing.T) {
+	tests := []Test{
+		{Name: "converging", Endpoint: "cart:80", Type: TestTypeTCP},
+		{Name: "passing", Endpoint: "ads:80", Type: TestTypeTCP},
+		{Name: "failing", Endpoint: "billing:80", Type: TestTypeTCP, ExpectFail: true},
+		{Name: "error", Endpoint: "payments:80", Type: TestTypeTCP},
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		start := time.Now()
+
+		// the policy allowing cart is enforced after 5s, and the one
+		// blocking billing never is
+		runs := make(map[string]int)
+		probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+			time.Sleep(100 * time.Millisecond)
+
+			spec, err := pf.DecodeSpec(args[1])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			endpoint := spec.Endpoint
+			runs[endpoint]++
+
+			switch endpoint {
+			case "cart:80":
+				if time.Since(start) < 5*time.Second {
+					return 1, "", nil
+				}
+			case "billing:80":
+				return 1, "", nil
+			case "payments:80":
+				return -1, "", errors.New("forbidden")
+			}
+			return 0, "", nil
+		}
+
+		var out bytes.Buffer
+		ctx := withUntilPass(withOutput(t.Context(), &out), start.Add(30*time.Second))
+
+		results := runTests(ctx, TestResult{Target: "frontend", Node: "node-1"}, tests, probe)
+
+		exp := []struct {
+			passed bool
+			runs   int
+			after  time.Duration
+		}{
+			{true, 4, 6900 * time.Millisecond},
+			{true, 1, 100 * time.Millisecond},
+			{false, 15, 0},
+			{false, 1, 0},
+		}
+
+		for i, e := range exp {
+			r := results[i]
+			if r.Passed() != e.passed || r.Runs != e.runs || r.ConvergedAfter != e.after {
+				t.Errorf("%s: expecting passed %v after %s in %d run(s), got %v after %s in %d run(s)",
+					r.Test.Name, e.passed, e.after, e.runs, r.Passed(), r.ConvergedAfter, r.Runs)
+			}
+		}
+
+		if runs["payments:80"] != 1 {
+			t.Errorf("expecting tests that could not run not to be rerun, ran %d times", runs["payments:80"])
+		}
+		// reruns don't start after the deadline, but may end after it
+		if elapsed := time.Since(start); elapsed > 31*time.Second {
+			t.Errorf("expecting reruns to stop at the deadline, took %s", elapsed)
+		}
+
+		out.Reset()
+		printConvergence(withOutput(t.Context(), &out), &Report{Results: results})
+
+		for _, line := range []string{
+			" frontend / converging: converged after 6.9s (4 run(s))",
+			" frontend / passing: converged after 100ms (1 run(s))",
+			" frontend / failing: NOT CONVERGED, failing from 1 of 1 source(s) after 15 run(s)",
+		} {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("expecting output to contain %q, got\n%s", line, out.String())
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+			return 1, "", nil
+		}
+
+		var out bytes.Buffer
+		results := runTests(withOutput(t.Context(), &out), TestResult{Target: "frontend", Node: "node-1"}, tests[:1], probe)
+		if results[0].Runs != 0 {
+			t.Errorf("expecting tests not to be rerun, ran %d times", results[0].Runs)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/netpol"
+)
+
+// policyCoverage loads the network policies of the cluster and returns
+// the items of the policies exercised by the tests of the report.
+func policyCoverage(ctx context.Context, k *kubernetes.Kubernetes, report *Report) (*netpol.Coverage, error) {
+	inv := new(netpol.Inventory)
+	if err := loadClusterInventory(ctx, k, inv); err != nil {
+		return nil, fmt.Errorf("loading network policies: %w", err)
+	}
+
+	cov := netpol.NewCoverage(inv.Policies)
+	recordCoverage(netpol.NewEvaluator(inv), cov, report)
+
+	return cov, nil
+}
+
+// recordCoverage records the connections made by the tests of the
+// report. Tests run from nodes or from other clusters, tests that
+// could not run and tests that cannot be evaluated, e.g. DNS tests, are
+// ignored.
+func recordCoverage(ev *netpol.Evaluator, cov *netpol.Coverage, report *Report) {
+	for _, res := range report.Results {
+		if res.Pod == nil || res.Cluster != "" || res.Err != nil {
+			continue
+		}
+
+		v, err := predictTest(ev, res.Pod, res.Test)
+		if err != nil {
+			continue
+		}
+
+		cov.Record(v)
+	}
+}
+
+// printCoverage prints the coverage of each policy item to the output
+// of ctx.
+func printCoverage(ctx context.Context, cov *netpol.Coverage) {
+	indent(ctx, 0, "NetworkPolicy Coverage:")
+
+	var last *netpol.Policy
+	for _, item := range cov.Items {
+		if item.Policy != last {
+			indent(ctx, 1, "Policy: %s", item.Policy)
+			last = item.Policy
+		}
+
+		if item.Hits > 0 {
+			indent(ctx, 2, "%s: covered by %d test(s)", item, item.Hits)
+		} else {
+			indent(ctx, 2, "%s: NOT COVERED", item)
+		}
+	}
+
+	covered, total := cov.Covered()
+	if total == 0 {
+		indent(ctx, 1, "No NetworkPolicy found")
+	}
+	newline(ctx)
+	indent(ctx, 0, "Covered %d of %d policy item(s)", covered, total)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPolicyCoverage(t *testing.T) {
+	pod := func(name, ip string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Labels: labels},
+			Status:     corev1.PodStatus{PodIP: ip},
+		}
+	}
+
+	frontend := pod("frontend", "10.0.0.1", map[string]string{"app": "frontend"})
+	cart := pod("cart", "10.0.0.2", map[string]string{"app": "cart"})
+
+	objs := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		frontend,
+		cart,
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "cart"},
+				Ports:    []corev1.ServicePort{{Port: 7070}},
+			},
+		},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "cart"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}}}},
+					{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "admin"}}}}},
+				},
+			},
+		},
+	}
+
+	k := kubernetes.NewWithClient(testClient.NewClientset(objs...))
+
+	test := func(endpoint string, typ TestType) Test {
+		return Test{Name: endpoint, Endpoint: endpoint, Type: typ, Timeout: time.Second}
+	}
+
+	report := &Report{Results: []TestResult{
+		{Target: "frontend", Pod: frontend, Test: test("cart.shop:7070", TestTypeTCP)},
+		{Target: "frontend", Pod: frontend, Test: test("http://cart.shop:7070/", TestTypeHTTP), ExitCode: 1},
+		// ignored
+		{Target: "frontend", Pod: frontend, Test: test("cart.shop:7070", TestTypeTCP), Err: errors.New("probe failed")},
+		{Target: "frontend", Pod: frontend, Test: test("cart.shop", TestTypeDNS)},
+		{Target: "nodes", Node: "node-1", Test: test("cart.shop:7070", TestTypeTCP)},
+	}}
+
+	cov, err := policyCoverage(t.Context(), k, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := make(map[string]int)
+	for _, item := range cov.Items {
+		hits[item.String()] = item.Hits
+	}
+
+	expected := map[string]int{
+		"ingress isolation (denied traffic)":              0,
+		"ingress rule 0 from pods app=frontend, any port": 2,
+		"ingress rule 1 from pods app=admin, any port":    0,
+	}
+
+	if len(expected) != len(hits) {
+		t.Fatalf("expecting %d items, got %v", len(expected), hits)
+	}
+	for item, n := range expected {
+		if got, ok := hits[item]; !ok || n != got {
+			t.Errorf("%s: expecting %d hits, got %d (found: %v)", item, n, got, ok)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// destinationReadyTimeout is how long to wait for an echo server to be
+// ready, including pulling its image.
+const destinationReadyTimeout = 2 * time.Minute
+
+// echoServer is a deployed destination.
+type echoServer struct {
+	dst    *Destination
+	k      *kubernetes.Kubernetes // of the cluster it is deployed in
+	pod    *corev1.Pod
+	svc    *corev1.Service    // nil unless dst.exposed()
+	export *kubernetes.Change // nil unless dst.Export
+	// existing is whether the pod or Service is dst.Existing, which
+	// is not removed.
+	existing bool
+}
+
+// exposed returns whether the echo server of d is exposed with a
+// Service.
+func (d *Destination) exposed() bool {
+	return d.Service || d.LoadBalancer || d.Export
+}
+
+// host returns the host tests connect to.
+func (e *echoServer) host() string {
+	switch {
+	case e.svc == nil:
+		return e.pod.Status.PodIP
+	case e.dst.LoadBalancer:
+		ingress := e.svc.Status.LoadBalancer.Ingress[0]
+		return cmp.Or(ingress.IP, ingress.Hostname)
+	case e.dst.Export:
+		return e.svc.Name + "." + e.svc.Namespace + ".svc.clusterset.local"
+	}
+	return e.svc.Name + "." + e.svc.Namespace + ".svc.cluster.local"
+}
+
+// tests returns the given tests, with the endpoints of the ones
+// connecting to the destination pointing to the echo server.
+func (e *echoServer) tests(tests []Test) []Test {
+	res := make([]Test, len(tests))
+
+	for i, test := range tests {
+		if usesDestination(test) {
+			// the endpoint was validated when parsing the plan
+			port, path, _ := e.dst.portPath(test)
+			hostPort := net.JoinHostPort(e.host(), strconv.Itoa(int(port)))
+
+			switch test.Type {
+			case TestTypeTCP:
+				test.Endpoint = hostPort
+			case TestTypeDNS:
+				test.Endpoint = e.host()
+			default:
+				test.Endpoint = "http://" + hostPort + "/" + path
+			}
+		}
+		res[i] = test
+	}
+
+	return res
+}
+
+// deployDestination deploys an echo server for dst and waits for it to
+// be ready, or resolves dst.Existing. It returns the echo server even
+// on failure, so whatever was created can be removed with
+// removeDestination.
+func deployDestination(ctx context.Context, k *kubernetes.Kubernetes, dst *Destination) (_ *echoServer, err error) {
+	ctx, span := tracer.Start(ctx, "deploy destination")
+	defer func() {
+		tracing.RecordError(span, err) //nolint:errcheck
+		span.End()
+	}()
+
+	if dst.Existing != "" {
+		return resolveDestination(ctx, k, dst)
+	}
+
+	namespace := cmp.Or(dst.Namespace, corev1.NamespaceDefault)
+	indent(ctx, 1, "Destination: echo server in namespace %s, ports %v", namespace, dst.Ports)
+
+	if dst.Cluster != "" {
+		indent(ctx, 1, "Destination Cluster: %s", dst.Cluster)
+		if k, err = k.Cluster(dst.Cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	pod, err := k.LaunchEchoServer(ctx, namespace, dst.Labels, dst.Ports, dst.ProbeImage)
+	if err != nil {
+		return nil, err
+	}
+	e := &echoServer{dst: dst, k: k, pod: pod}
+
+	if dst.exposed() {
+		serviceType := corev1.ServiceTypeClusterIP
+		if dst.LoadBalancer {
+			serviceType = corev1.ServiceTypeLoadBalancer
+		}
+		if e.svc, err = k.CreateEchoService(ctx, pod, serviceType); err != nil {
+			return e, err
+		}
+	}
+
+	if dst.Export {
+		if e.export, err = k.ExportService(ctx, e.svc); err != nil {
+			return e, err
+		}
+	}
+
+	if e.pod, err = k.WaitPodReady(ctx, pod, destinationReadyTimeout); err != nil {
+		e.pod = pod
+		return e, err
+	}
+
+	if dst.LoadBalancer {
+		svc, err := k.WaitServiceLoadBalancer(ctx, e.svc, destinationReadyTimeout)
+		if err != nil {
+			return e, err
+		}
+		e.svc = svc
+	}
+
+	indent(ctx, 1, "Destination Host: %s", e.host())
+
+	return e, nil
+}
+
+var errNoDestinationHost = errors.New("existing destination has no address")
+
+// resolveDestination returns the existing pod or Service of dst, read
+// from the cluster of dst, for the tests of its target to connect to.
+func resolveDestination(ctx context.Context, k *kubernetes.Kubernetes, dst *Destination) (_ *echoServer, err error) {
+	namespace := cmp.Or(dst.Namespace, corev1.NamespaceDefault)
+	indent(ctx, 1, "Destination: existing %s in namespace %s, ports %v", dst.Existing, namespace, dst.Ports)
+
+	if dst.Cluster != "" {
+		indent(ctx, 1, "Destination Cluster: %s", dst.Cluster)
+		if k, err = k.Cluster(dst.Cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &echoServer{dst: dst, k: k, existing: true}
+
+	// validated when parsing the plan
+	kind, name, _ := strings.Cut(dst.Existing, "/")
+	if kind == existingPod {
+		if e.pod, err = k.GetPod(ctx, namespace, name); err != nil {
+			return nil, err
+		}
+		if e.pod.Status.PodIP == "" {
+			return nil, fmt.Errorf("%w: pod %s/%s has no IP", errNoDestinationHost, namespace, name)
+		}
+	} else {
+		if e.svc, err = k.GetService(ctx, namespace, name); err != nil {
+			return nil, err
+		}
+		if dst.LoadBalancer && len(e.svc.Status.LoadBalancer.Ingress) == 0 {
+			return nil, fmt.Errorf("%w: Service %s/%s has no load balancer ingress", errNoDestinationHost, namespace, name)
+		}
+	}
+
+	indent(ctx, 1, "Destination Host: %s", e.host())
+
+	return e, nil
+}
+
+// removeDestination deletes the objects created for an echo server.
+// Existing destinations are left alone.
+func removeDestination(ctx context.Context, e *echoServer) {
+	if e == nil || e.existing {
+		return
+	}
+
+	if e.export != nil {
+		if err := e.k.Revert(ctx, e.export); err != nil {
+			indent(ctx, 1, "Warning: %v", err)
+		}
+	}
+	if e.svc != nil {
+		if err := e.k.DeleteService(ctx, e.svc); err != nil {
+			indent(ctx, 1, "Warning: %v", err)
+		}
+	}
+	if err := e.k.DeletePod(ctx, e.pod); err != nil {
+		indent(ctx, 1, "Warning: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testClient "k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestDestination(t *testing.T) {
+	dst := &Destination{Namespace: "billing", Labels: map[string]string{"app": "billing"}, Ports: []int32{8080, 9090}}
+
+	tests := []Test{
+		{Name: "tcp", Type: TestTypeTCP},
+		{Name: "tcp port", Type: TestTypeTCP, Endpoint: ":9090"},
+		{Name: "http", Type: TestTypeHTTP, Endpoint: ":9090/metrics"},
+		{Name: "dns", Type: TestTypeDNS},
+		{Name: "other", Type: TestTypeTCP, Endpoint: "grafana.com:443"},
+	}
+
+	t.Run("endpoints", func(t *testing.T) {
+		pod := &corev1.Pod{Status: corev1.PodStatus{PodIP: "10.0.0.1"}}
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "nethax-echo-1"}}
+
+		exp := map[string][]string{
+			"pod":     {"10.0.0.1:8080", "10.0.0.1:9090", "http://10.0.0.1:9090/metrics", "10.0.0.1", "grafana.com:443"},
+			"service": {"nethax-echo-1.billing.svc.cluster.local:8080", "nethax-echo-1.billing.svc.cluster.local:9090", "http://nethax-echo-1.billing.svc.cluster.local:9090/metrics", "nethax-echo-1.billing.svc.cluster.local", "grafana.com:443"},
+			"export":  {"nethax-echo-1.billing.svc.clusterset.local:8080", "nethax-echo-1.billing.svc.clusterset.local:9090", "http://nethax-echo-1.billing.svc.clusterset.local:9090/metrics", "nethax-echo-1.billing.svc.clusterset.local", "grafana.com:443"},
+		}
+
+		exported := *dst
+		exported.Export = true
+
+		for n, e := range map[string]*echoServer{"pod": {dst: dst, pod: pod}, "service": {dst: dst, pod: pod, svc: svc}, "export": {dst: &exported, pod: pod, svc: svc}} {
+			for i, test := range e.tests(tests) {
+				if g := test.Endpoint; exp[n][i] != g {
+					t.Errorf("%s: expecting %s endpoint %q, got %q", n, test.Name, exp[n][i], g)
+				}
+			}
+		}
+	})
+
+	t.Run("deploy", func(t *testing.T) {
+		c := readyClientset()
+		k := kubernetes.NewWithClient(c)
+
+		synctest.Test(t, func(t *testing.T) {
+			dst := *dst
+			dst.Service = true
+
+			e, err := deployDestination(t.Context(), k, &dst)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if e.pod.Labels["app"] != "billing" {
+				t.Errorf("expecting echo server pod to have the destination labels, got %v", e.pod.Labels)
+			}
+			if e.svc == nil {
+				t.Fatal("expecting echo server service")
+			}
+			if e.pod.Status.PodIP == "" {
+				t.Error("expecting ready echo server pod")
+			}
+
+			removeDestination(t.Context(), e)
+
+			pods, _ := c.CoreV1().Pods("billing").List(t.Context(), metav1.ListOptions{})
+			svcs, _ := c.CoreV1().Services("billing").List(t.Context(), metav1.ListOptions{})
+			if len(pods.Items) != 0 || len(svcs.Items) != 0 {
+				t.Errorf("expecting echo server to be removed, got %d pods and %d services", len(pods.Items), len(svcs.Items))
+			}
+		})
+	})
+
+	t.Run("other cluster", func(t *testing.T) {
+		local, remote := readyClientset(), readyClientset()
+
+		// the fake clientset doesn't provision load balancers either
+		remote.PrependReactor("get", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+			obj, err := remote.Tracker().Get(corev1.SchemeGroupVersion.WithResource("services"), action.GetNamespace(), action.(ktesting.GetAction).GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			svc := obj.(*corev1.Service).DeepCopy()
+			svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}}
+			return true, svc, nil
+		})
+
+		k := kubernetes.NewWithClient(local)
+		k.AddCluster("remote", kubernetes.NewWithClient(remote))
+
+		synctest.Test(t, func(t *testing.T) {
+			dst := *dst
+			dst.Cluster = "remote"
+			dst.LoadBalancer = true
+
+			e, err := deployDestination(t.Context(), k, &dst)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if e.svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+				t.Errorf("expecting LoadBalancer service, got %s", e.svc.Spec.Type)
+			}
+			if h := e.host(); h != "203.0.113.10" {
+				t.Errorf("expecting load balancer host, got %s", h)
+			}
+
+			pods, _ := local.CoreV1().Pods("billing").List(t.Context(), metav1.ListOptions{})
+			if len(pods.Items) != 0 {
+				t.Errorf("expecting no echo server in the local cluster, got %d pods", len(pods.Items))
+			}
+
+			removeDestination(t.Context(), e)
+
+			pods, _ = remote.CoreV1().Pods("billing").List(t.Context(), metav1.ListOptions{})
+			svcs, _ := remote.CoreV1().Services("billing").List(t.Context(), metav1.ListOptions{})
+			if len(pods.Items) != 0 || len(svcs.Items) != 0 {
+				t.Errorf("expecting echo server to be removed, got %d pods and %d services", len(pods.Items), len(svcs.Items))
+			}
+		})
+	})
+
+	t.Run("existing in another cluster", func(t *testing.T) {
+		remote := testClient.NewClientset(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "billing-0"},
+				Status:     corev1.PodStatus{PodIP: "10.1.0.7"},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "billing"},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{{Hostname: "billing.us.example.com"}},
+				}},
+			},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "internal"}},
+		)
+
+		k := kubernetes.NewWithClient(testClient.NewClientset())
+		k.AddCluster("remote", kubernetes.NewWithClient(remote))
+
+		for existing, tt := range map[string]struct {
+			loadBalancer, export bool
+			host                 string
+		}{
+			"pod/billing-0":    {host: "10.1.0.7"},
+			"service/billing":  {loadBalancer: true, host: "billing.us.example.com"},
+			"service/internal": {export: true, host: "internal.billing.svc.clusterset.local"},
+		} {
+			dst := &Destination{Cluster: "remote", Namespace: "billing", Ports: []int32{8080}, Existing: existing, LoadBalancer: tt.loadBalancer, Export: tt.export}
+
+			e, err := deployDestination(t.Context(), k, dst)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", existing, err)
+			}
+			if h := e.host(); h != tt.host {
+				t.Errorf("%s: expecting host %s, got %s", existing, tt.host, h)
+			}
+
+			removeDestination(t.Context(), e)
+		}
+
+		pods, _ := remote.CoreV1().Pods("billing").List(t.Context(), metav1.ListOptions{})
+		svcs, _ := remote.CoreV1().Services("billing").List(t.Context(), metav1.ListOptions{})
+		if len(pods.Items) != 1 || len(svcs.Items) != 2 {
+			t.Errorf("expecting existing destinations to be kept, got %d pods and %d services", len(pods.Items), len(svcs.Items))
+		}
+
+		for _, dst := range []*Destination{
+			{Cluster: "remote", Namespace: "billing", Ports: []int32{8080}, Existing: "pod/nope"},
+			{Cluster: "remote", Namespace: "billing", Ports: []int32{8080}, Existing: "service/internal", LoadBalancer: true},
+		} {
+			if _, err := deployDestination(t.Context(), k, dst); err == nil {
+				t.Errorf("%s: expecting error, got nil", dst.Existing)
+			}
+		}
+	})
+}
+
+// readyClientset returns a fake clientset reporting its pods ready, as
+// it doesn't run them.
+func readyClientset() *testClient.Clientset {
+	c := testClient.NewClientset()
+
+	c.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		obj, err := c.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), action.(ktesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*corev1.Pod).DeepCopy()
+		pod.Status.PodIP = "10.0.0.1"
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		return true, pod, nil
+	})
+
+	return c
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"strings"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type dryRunKey struct{}
+
+// withDryRun returns a copy of ctx in which the plan is only resolved
+// and printed: steps don't change objects, destinations are not
+// deployed and probes are not launched.
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// isDryRun returns whether the run of ctx is a dry run.
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// printStepObjects prints the objects a step would apply or delete, in
+// a dry run.
+func printStepObjects(ctx context.Context, step Step, objs []*unstructured.Unstructured) {
+	action := "Would apply"
+	if step.Delete {
+		action = "Would delete"
+	}
+
+	for _, obj := range objs {
+		name := obj.GetName()
+		if obj.GetNamespace() != "" {
+			name = obj.GetNamespace() + "/" + name
+		}
+		indent(ctx, 2, "%s: %s %s", action, obj.GetKind(), name)
+	}
+}
+
+// printDestination prints the echo server that would be deployed for
+// dst, or the existing destination, in a dry run. The tests connecting
+// to it keep the endpoints of the plan, as its host is only known once
+// deployed.
+func printDestination(ctx context.Context, dst *Destination) {
+	namespace := cmp.Or(dst.Namespace, corev1.NamespaceDefault)
+	if dst.Existing != "" {
+		indent(ctx, 1, "Destination: would connect to existing %s in namespace %s, ports %v", dst.Existing, namespace, dst.Ports)
+	} else {
+		indent(ctx, 1, "Destination: would deploy an echo server in namespace %s, ports %v, with image '%s'",
+			namespace, dst.Ports, kubernetes.GetProbeImage(dst.ProbeImage))
+	}
+	if dst.Cluster != "" {
+		indent(ctx, 1, "Destination Cluster: %s", dst.Cluster)
+	}
+}
+
+// printProbes prints the probe each of tests would run, in a dry run.
+func printProbes(ctx context.Context, tests []Test) {
+	for _, test := range tests {
+		command, arguments := probeCommand(test)
+
+		indent(ctx, 2, "Test: %s", test.Name)
+		indent(ctx, 3, "Probe Image: '%s'", kubernetes.GetProbeImage(test.ProbeImage))
+		indent(ctx, 3, "Command: %s", strings.Join(append(command, arguments...), " "))
+	}
+	newline(ctx)
+}
+
+// dryRunSelection returns the mode to select the pods or nodes of a
+// target with. In a dry run, all the ready ones a random one would be
+// picked from are listed.
+func dryRunSelection(ctx context.Context, mode SelectionMode) SelectionMode {
+	if !isDryRun(ctx) || mode != SelectionModeRandom {
+		return mode
+	}
+	indent(ctx, 1, "Selection Mode: random, one of these is picked at run time")
+	return SelectionModeAll
+}
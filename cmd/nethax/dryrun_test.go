@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExecuteTest_DryRun(t *testing.T) {
+	ready := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+
+	var objects []runtime.Object
+	for _, name := range []string{"frontend-0", "frontend-1", "frontend-2"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Labels: map[string]string{"app": "frontend"}},
+			Status:     corev1.PodStatus{Conditions: ready},
+		})
+	}
+	objects = append(objects, &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	})
+
+	client := testClient.NewClientset(objects...)
+	k := kubernetes.NewWithClient(client)
+
+	plan := &TestPlan{
+		Name: "shop",
+		Setup: []Step{{Name: "deny all", Manifests: `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: deny-all
+  namespace: shop
+`}},
+		Teardown: []Step{{Name: "remove deny all", Delete: true, Manifests: `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: deny-all
+  namespace: shop
+`}},
+		TestTargets: []TestTarget{
+			{
+				Name:        "frontend",
+				Namespace:   "shop",
+				PodSelector: PodSelector{Mode: SelectionModeRandom, Labels: "app=frontend"},
+				Destination: &Destination{Namespace: "shop", Ports: []int32{8080}},
+				Tests: []Test{
+					{Name: "cart", Endpoint: "cart:8080", Type: TestTypeTCP, Timeout: 5 * time.Second, ExpectFail: true},
+				},
+			},
+			{
+				Name:         "nodes",
+				NodeSelector: &NodeSelector{Mode: SelectionModeAll},
+				Tests: []Test{
+					{Name: "grafana", Endpoint: "https://grafana.com", Type: TestTypeHTTP, Timeout: 5 * time.Second, StatusCode: 200, ProbeImage: "probe:dev"},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	report := executeTest(withDryRun(withOutput(t.Context(), &out)), k, plan)
+
+	if !report.Passed() || len(report.Results) > 0 {
+		t.Errorf("expecting a passed report without results, got %+v", report)
+	}
+
+	for _, line := range []string{
+		"  Would apply: NetworkPolicy shop/deny-all",
+		"  Would delete: NetworkPolicy shop/deny-all",
+		" Destination: would deploy an echo server in namespace shop, ports [8080], with image '" + kubernetes.DefaultProbeImage + "'",
+		" Selection Mode: random, one of these is picked at run time",
+		" Selected 3 ready pod(s) for testing",
+		" Pod: shop/frontend-2",
+		`   Command: /nethax-probe --spec {"version":1,"type":"tcp","endpoint":"cart:8080","timeout":5000000000,"expectFail":true}`,
+		" Node: node-1",
+		"   Probe Image: 'probe:dev'",
+		`   Command: /nethax-probe --spec {"version":1,"type":"http","endpoint":"https://grafana.com","timeout":5000000000,"expectedStatus":200}`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expecting output to contain %q, got\n%s", line, out.String())
+		}
+	}
+
+	for _, a := range client.Actions() {
+		if a.GetVerb() != "list" && a.GetVerb() != "get" {
+			t.Errorf("expecting a dry run to only read objects, got %s %s", a.GetVerb(), a.GetResource().Resource)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrorKind classifies the errors preventing tests from running, as
+// opposed to tests failing because of the network.
+type ErrorKind string
+
+const (
+	// ErrorKindConfig is an invalid plan only detected when running
+	// it, e.g. an invalid endpoint URL.
+	ErrorKindConfig ErrorKind = "config"
+	// ErrorKindSelection is a target selecting no pods, nodes or
+	// namespaces, or none that are ready.
+	ErrorKindSelection ErrorKind = "selection"
+	// ErrorKindPermission is a request denied by the Kubernetes API,
+	// e.g. because of missing RBAC permissions.
+	ErrorKindPermission ErrorKind = "permission"
+	// ErrorKindKubernetes is any other failed request to the
+	// Kubernetes API, or failure to reach it.
+	ErrorKindKubernetes ErrorKind = "kubernetes"
+	// ErrorKindTimeout is a probe that didn't terminate in time, e.g.
+	// because its image took too long to pull.
+	ErrorKindTimeout ErrorKind = "timeout"
+	// ErrorKindStart is a probe container that could not start, e.g.
+	// because its image could not be pulled.
+	ErrorKindStart ErrorKind = "start"
+	// ErrorKindProbe is a probe that could not run its test, e.g.
+	// because it doesn't support its arguments.
+	ErrorKindProbe ErrorKind = "probe"
+	// ErrorKindCancelled is a run cancelled while the test or step
+	// was running.
+	ErrorKindCancelled ErrorKind = "cancelled"
+	// ErrorKindInternal is any other error.
+	ErrorKindInternal ErrorKind = "internal"
+)
+
+// probeExitCodeConfigError is the exit code of nethax-probe when it is
+// given invalid arguments.
+const probeExitCodeConfigError = 2
+
+var (
+	errInvalidEndpoint = errors.New("invalid endpoint URL")
+	errProbeConfig     = errors.New("probe rejected its arguments")
+)
+
+// errorKind returns the kind of err, which happened in the run of ctx.
+func errorKind(ctx context.Context, err error) ErrorKind {
+	var status apierrors.APIStatus
+	var netErr net.Error
+
+	switch {
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		return ErrorKindCancelled
+	case errors.Is(err, errInvalidEndpoint) || errors.Is(err, errInvalidSelectionMode):
+		return ErrorKindConfig
+	case kubernetes.IsNoneFound(err) || errors.Is(err, errNoReadyPods) || errors.Is(err, errNoReadyNodes):
+		return ErrorKindSelection
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return ErrorKindPermission
+	case errors.Is(err, kubernetes.ErrContainerNotStarted):
+		return ErrorKindStart
+	case errors.Is(err, kubernetes.ErrPollTimeout) || errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case errors.Is(err, errProbeConfig):
+		return ErrorKindProbe
+	case errors.As(err, &status) || errors.As(err, &netErr):
+		return ErrorKindKubernetes
+	}
+	return ErrorKindInternal
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestErrorKind(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	_, noneFound := kubernetes.NewWithClient(testClient.NewClientset()).GetPods(t.Context(), "shop", "", "")
+
+	for n, tt := range map[string]struct {
+		err error
+		exp ErrorKind
+	}{
+		"invalid endpoint": {fmt.Errorf("%w: missing scheme", errInvalidEndpoint), ErrorKindConfig},
+		"selection mode":   {fmt.Errorf("%w: some", errInvalidSelectionMode), ErrorKindConfig},
+		"no pods":          {fmt.Errorf("failed to find pods: %w", noneFound), ErrorKindSelection},
+		"no ready pods":    {errNoReadyPods, ErrorKindSelection},
+		"forbidden":        {fmt.Errorf("listing pods: %w", apierrors.NewForbidden(pods, "", errors.New("RBAC"))), ErrorKindPermission},
+		"unauthorized":     {apierrors.NewUnauthorized("expired token"), ErrorKindPermission},
+		"poll timeout":     {fmt.Errorf("polling: %w", kubernetes.ErrPollTimeout), ErrorKindTimeout},
+		"image pull":       {fmt.Errorf("polling: %w: ErrImagePull", kubernetes.ErrContainerNotStarted), ErrorKindStart},
+		"not found":        {apierrors.NewNotFound(pods, "frontend-0"), ErrorKindKubernetes},
+		"unreachable":      {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorKindKubernetes},
+		"probe":            {fmt.Errorf("%w, exit code 2", errProbeConfig), ErrorKindProbe},
+		"canceled":         {context.Canceled, ErrorKindCancelled},
+		"other":            {errors.New("boom"), ErrorKindInternal},
+	} {
+		t.Run(n, func(t *testing.T) {
+			if g := errorKind(t.Context(), tt.err); g != tt.exp {
+				t.Errorf("expecting kind %s, got %s", tt.exp, g)
+			}
+		})
+	}
+
+	t.Run("cancelled run", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(t.Context())
+		cancel(errInterrupted)
+
+		// e.g. a poll interrupted by the cancellation
+		if g := errorKind(ctx, context.DeadlineExceeded); g != ErrorKindCancelled {
+			t.Errorf("expecting kind %s, got %s", ErrorKindCancelled, g)
+		}
+	})
+
+	t.Run("probe config error", func(t *testing.T) {
+		probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+			return probeExitCodeConfigError, "", nil
+		}
+
+		var out bytes.Buffer
+		res := runTest(withOutput(t.Context(), &out), TestResult{Target: "frontend", Node: "node-1"}, Test{Name: "cart", Endpoint: "cart:80", Type: TestTypeTCP}, probe)
+		if !errors.Is(res.Err, errProbeConfig) || res.ErrKind != ErrorKindProbe {
+			t.Errorf("expecting a probe error, got %v (%s)", res.Err, res.ErrKind)
+		}
+	})
+}
+
+func TestReportsExitCode(t *testing.T) {
+	passed := &Report{Results: []TestResult{{}}}
+	failed := &Report{Results: []TestResult{{ExitCode: 1}}}
+	config := &Report{Results: []TestResult{{Err: errInvalidEndpoint, ErrKind: ErrorKindConfig}}}
+	permission := &Report{Errors: []TargetError{{Err: errors.New("forbidden"), Kind: ErrorKindPermission}}}
+	step := &Report{StepErrors: []StepError{{Err: errors.New("boom"), Kind: ErrorKindInternal}}}
+	cancelled := &Report{Cancelled: errInterrupted}
+
+	for n, tt := range map[string]struct {
+		reports []*Report
+		exp     int
+	}{
+		"passed":     {[]*Report{passed, passed}, 0},
+		"failed":     {[]*Report{passed, failed}, exitCodeFailure},
+		"config":     {[]*Report{failed, config}, exitCodeConfigError},
+		"permission": {[]*Report{failed, config, permission}, exitCodeNethaxError},
+		"step":       {[]*Report{step}, exitCodeNethaxError},
+		"cancelled":  {[]*Report{permission, cancelled}, exitCodeCancelled},
+	} {
+		t.Run(n, func(t *testing.T) {
+			if g := reportsExitCode(tt.reports); g != tt.exp {
+				t.Errorf("expecting exit code %d, got %d", tt.exp, g)
+			}
+		})
+	}
+}
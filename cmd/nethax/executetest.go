@@ -4,62 +4,171 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"math/rand"
 	"net/url"
-	"os"
-	"strconv"
-	"strings"
+	"slices"
+	"time"
 
 	"github.com/grafana/nethax/pkg/kubernetes"
 	pf "github.com/grafana/nethax/pkg/probeflags"
+	"github.com/grafana/nethax/pkg/tracing"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // ExecuteTest returns the execute-test command
 func ExecuteTest() *cobra.Command {
-	var testFile, defaultProbeImage, kontext string
+	var defaultProbeImage, otlpEndpoint string
+	var testFiles, configMaps, contexts, set []string
+	var coverage, untilPass, dryRun bool
+	var deadline, maxDuration time.Duration
+	var outcomes outcomeOptions
+	var filter testFilter
 
 	cmd := &cobra.Command{
 		Use:   "execute-test -f example/OtelDemoTestPlan.yaml",
 		Short: "Execute network connectivity test plan",
-		Run: func(cmd *cobra.Command, args []string) {
-			if testFile == "" {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are printed as they happen, and runs exit with
+			// their own exit codes
+			cmd.SilenceErrors, cmd.SilenceUsage = true, true
+
+			if len(testFiles) == 0 && len(configMaps) == 0 {
 				cmd.Println("Error: test file must be specified")
 				cmd.Help() //nolint:errcheck
-				os.Exit(exitCodeConfigError)
+				return exitCode(exitCodeConfigError)
+			}
+
+			vars, err := parseVarFlags(set)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
 			}
 
-			file, err := os.Open(testFile)
+			locations, err := planLocations(testFiles, configMaps)
 			if err != nil {
-				cmd.Printf("Error opening test file: %v\n", err)
-				os.Exit(exitCodeConfigError)
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			reader := &planReader{
+				vars: vars,
+				kubernetes: func() (*kubernetes.Kubernetes, error) {
+					var kontext string
+					if len(contexts) > 0 {
+						kontext = contexts[0]
+					}
+					return kubernetes.New(kontext)
+				},
 			}
-			defer file.Close() //nolint:errcheck
 
-			plan, err := ParseTestPlan(file)
+			plan, err := reader.read(cmd.Context(), locations)
 			if err != nil {
-				cmd.Printf("Error parsing test plan: %v\n", err)
-				os.Exit(exitCodeConfigError)
+				cmd.Printf("Error reading test plan: %v\n", err)
+				return exitCode(exitCodeConfigError)
 			}
 
-			k, err := kubernetes.New(kontext)
+			plan, err = filter.apply(plan)
+			if err != nil {
+				cmd.Printf("Error filtering tests: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			clusters := planClusters(contexts, plan)
+
+			k, err := kubernetes.New(clusters[0])
 			if err != nil {
 				cmd.Printf("Error creating Kubernetes client: %v\n", err)
-				os.Exit(exitCodeConfigError)
+				return exitCode(exitCodeConfigError)
+			}
+
+			clients := []*kubernetes.Kubernetes{k}
+			for _, name := range clusters[1:] {
+				c, err := k.Cluster(name)
+				if err != nil {
+					cmd.Printf("Error creating Kubernetes client for context %s: %v\n", name, err)
+					return exitCode(exitCodeConfigError)
+				}
+				clients = append(clients, c)
 			}
 
 			kubernetes.DefaultProbeImage = defaultProbeImage
-			if !executeTest(cmd.Context(), k, plan) {
-				os.Exit(exitCodeFailure)
+
+			// run runs the plan in the cluster of k
+			run := func(ctx context.Context, k *kubernetes.Kubernetes) *Report {
+				start := time.Now()
+				report := executeTest(ctx, k, plan)
+				if dryRun {
+					return report
+				}
+
+				if untilPass {
+					printConvergence(ctx, report)
+				}
+
+				// report the results so far even if the run was cancelled
+				ctx = context.WithoutCancel(ctx)
+
+				recordOutcomes(ctx, k, report, start, outcomes)
+
+				if coverage {
+					cov, err := policyCoverage(ctx, k, report)
+					if err != nil {
+						indent(ctx, 0, "Error computing NetworkPolicy coverage: %v", err)
+					} else {
+						printCoverage(ctx, cov)
+					}
+				}
+
+				return report
 			}
+
+			shutdownTracing, err := setupTracing(cmd, otlpEndpoint)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+			defer shutdownTracing()
+
+			ctx := cmd.Context()
+			if maxDuration > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeoutCause(ctx, maxDuration, errMaxDuration)
+				defer cancel()
+			}
+			switch {
+			case dryRun:
+				ctx = withDryRun(ctx)
+			case untilPass:
+				ctx = withUntilPass(ctx, time.Now().Add(deadline))
+			}
+
+			var reports []*Report
+			if len(clients) == 1 {
+				reports = []*Report{run(ctx, k)}
+			} else {
+				reports = executeClusters(ctx, clients, run)
+				if !dryRun {
+					printComparison(ctx, plan, reports)
+				}
+			}
+
+			if code := reportsExitCode(reports); code != 0 {
+				return exitCode(code)
+			}
+			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&testFile, "file", "f", "", "Path to the test configuration YAML file")
-	cmd.MarkFlagRequired("file") //nolint:errcheck
+	cmd.Flags().StringArrayVarP(&testFiles, "file", "f", nil, "Path to the test configuration YAML file, a directory of them, an HTTP(S) URL, or - for the standard input. Can be repeated to merge several plans into one run.")
+	cmd.Flags().StringArrayVar(&configMaps, "configmap", nil, "ConfigMap to read the test plans from, as namespace/name for all its .yaml and .yml keys, or namespace/name/key. Can be repeated, and combined with --file.")
+	cmd.MarkFlagsOneRequired("file", "configmap")
 
-	cmd.Flags().StringVarP(&kontext, "context", "c", "", "Kubernetes context to connect. Leave empty for in-cluster context.")
+	cmd.Flags().StringArrayVarP(&contexts, "context", "c", nil, "Kubernetes context to connect. Leave empty for in-cluster context. Can be repeated to run the plan in several clusters in parallel, overriding the clusters of the plan.")
 
 	cmd.Flags().StringVar(&defaultProbeImage,
 		"default-probe-image",
@@ -67,112 +176,478 @@ func ExecuteTest() *cobra.Command {
 		"Default probe image to use if test plan doesn't specify one.",
 	)
 
+	cmd.Flags().BoolVar(&coverage, "coverage", false, "Report which NetworkPolicy rules were exercised by the tests")
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the objects the steps would change, and the pods and nodes that would run each probe, with its image and command, without changing anything in the cluster")
+
+	cmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "Cancel the run after this long, reporting the results so far and exiting with code 4. Setup changes are still reverted, and destinations removed. 0 for no limit.")
+
+	cmd.Flags().BoolVar(&untilPass, "until-pass", false, "Rerun the tests that fail until they pass or the deadline expires, reporting how long each took to pass")
+	cmd.Flags().DurationVar(&deadline, "deadline", 5*time.Minute, "How long after the start of the run to stop rerunning failing tests, with --until-pass")
+
+	addVarsFlag(cmd, &set)
+	addFilterFlags(cmd, &filter)
+	addOutcomeFlags(cmd, &outcomes)
+	addTracingFlags(cmd, &otlpEndpoint)
+
 	return cmd
 }
 
-func indent(level int, format string, a ...any) {
-	fmt.Print(strings.Repeat(" ", level))
-	fmt.Printf(format, a...)
-	fmt.Println()
+// reportsExitCode returns the exit code of a run with the given
+// reports: cancelled, or else failed to run some tests, because of the
+// cluster or the runner, or of the plan, or else with failed tests.
+func reportsExitCode(reports []*Report) int {
+	var nethaxErrors, configErrors int
+	for _, r := range reports {
+		if r.Cancelled != nil {
+			return exitCodeCancelled
+		}
+		for kind, n := range r.ErrorKinds() {
+			if kind == ErrorKindConfig {
+				configErrors += n
+			} else {
+				nethaxErrors += n
+			}
+		}
+	}
+
+	switch {
+	case nethaxErrors > 0:
+		return exitCodeNethaxError
+	case configErrors > 0:
+		return exitCodeConfigError
+	}
+
+	for _, r := range reports {
+		if !r.Passed() {
+			return exitCodeFailure
+		}
+	}
+	return 0
 }
 
-func executeTest(ctx context.Context, k *kubernetes.Kubernetes, plan *TestPlan) bool {
-	indent(0, "Test Plan: %s", plan.Name)
-	indent(0, "Description: %s", plan.Description)
-	fmt.Println()
+func executeTest(ctx context.Context, k *kubernetes.Kubernetes, plan *TestPlan) *Report {
+	ctx, span := tracer.Start(ctx, "plan", trace.WithAttributes(
+		attribute.String("nethax.plan", plan.Name),
+		attribute.String("nethax.cluster", k.Context()),
+	))
+	defer span.End()
+
+	indent(ctx, 0, "Test Plan: %s", plan.Name)
+	indent(ctx, 0, "Description: %s", plan.Description)
+	if isDryRun(ctx) {
+		indent(ctx, 0, "Dry Run: no objects are changed and no probes are launched")
+	}
+	newline(ctx)
+
+	report := &Report{Plan: plan.Name, Cluster: k.Context()}
+
+	changes, errs := runSetup(ctx, k, plan)
+	report.StepErrors = append(report.StepErrors, errs...)
+
+	if len(errs) == 0 {
+		executeTargets(ctx, k, plan, report)
+	}
+
+	if report.Cancelled = context.Cause(ctx); report.Cancelled != nil {
+		indent(ctx, 0, "Run cancelled: %v, skipping the tests that had not run", report.Cancelled)
+		newline(ctx)
+	}
 
-	allTestsPassed := true
+	// always clean up, even if the run was cancelled
+	errs = runTeardown(context.WithoutCancel(ctx), k, plan, changes)
+	report.StepErrors = append(report.StepErrors, errs...)
+
+	if !isDryRun(ctx) {
+		printSummary(ctx, report)
+	}
+
+	if !report.Passed() {
+		span.SetStatus(codes.Error, "test plan failed")
+	}
+
+	return report
+}
 
+// printSummary prints how many tests passed and failed in the run of
+// report, and the kinds of the errors preventing others from running.
+func printSummary(ctx context.Context, report *Report) {
+	var passed, failed int
+	for _, res := range report.Results {
+		switch {
+		case res.Passed():
+			passed++
+		case res.Err == nil:
+			failed++
+		}
+	}
+
+	kinds := report.ErrorKinds()
+	var errs int
+	for _, n := range kinds {
+		errs += n
+	}
+
+	indent(ctx, 0, "Summary: %d passed, %d failed, %d error(s)", passed, failed, errs)
+	for _, kind := range slices.Sorted(maps.Keys(kinds)) {
+		indent(ctx, 1, "%s: %d", kind, kinds[kind])
+	}
+	newline(ctx)
+}
+
+// executeTargets runs the targets of plan, adding their results to
+// report.
+func executeTargets(ctx context.Context, k *kubernetes.Kubernetes, plan *TestPlan, report *Report) {
 	for _, target := range plan.TestTargets {
-		indent(1, "Target: %s", target.Name)
-		indent(1, "Selector: %s", target.PodSelector)
-		if target.Namespace != "" {
-			indent(1, "Namespace: %s", target.Namespace)
+		if ctx.Err() != nil {
+			return
+		}
+		indent(ctx, 1, "Target: %s", target.Name)
+		runTarget(ctx, k, target, report)
+	}
+}
+
+// runTarget runs the tests of target from the nodes, or the pods of
+// the namespaces, it selects, adding their results to report.
+func runTarget(ctx context.Context, k *kubernetes.Kubernetes, target TestTarget, report *Report) {
+	ctx, span := tracer.Start(ctx, "target", trace.WithAttributes(attribute.String("nethax.target", target.Name)))
+	defer span.End()
+
+	// targetFailed records an error preventing a target from running
+	targetFailed := func(namespace string, err error) {
+		tracing.RecordError(span, err) //nolint:errcheck
+		indent(ctx, 1, "Error: %v", err)
+		newline(ctx)
+		report.Errors = append(report.Errors, TargetError{Target: target.Name, Namespace: namespace, Err: err, Kind: errorKind(ctx, err)})
+	}
+
+	if target.Destination != nil && isDryRun(ctx) {
+		printDestination(ctx, target.Destination)
+	} else if target.Destination != nil {
+		echo, err := deployDestination(ctx, k, target.Destination)
+		// always clean up, even if the run was cancelled
+		defer removeDestination(context.WithoutCancel(ctx), echo)
+		if err != nil {
+			targetFailed(target.Namespace, fmt.Errorf("deploying destination: %w", err))
+			return
 		}
+		target.Tests = echo.tests(target.Tests)
+	}
 
-		selectedPods, err := findPods(ctx, k, target.Namespace, target.PodSelector)
+	if target.Cluster != "" {
+		indent(ctx, 1, "Cluster: %s", target.Cluster)
+		var err error
+		if k, err = k.Cluster(target.Cluster); err != nil {
+			targetFailed(target.Namespace, err)
+			return
+		}
+	}
+
+	if target.NodeSelector != nil {
+		indent(ctx, 1, "Node Selector: %s", target.NodeSelector)
+		results, err := executeNodeTarget(ctx, k, target)
 		if err != nil {
-			indent(1, "Error: %v", err)
-			fmt.Println()
-			allTestsPassed = false
+			targetFailed(target.Namespace, err)
+		}
+		report.Results = append(report.Results, results...)
+		return
+	}
+
+	indent(ctx, 1, "Selector: %s", target.PodSelector)
+
+	if !target.PerNamespace() {
+		if target.Namespace != "" {
+			indent(ctx, 1, "Namespace: %s", target.Namespace)
+		}
+		results, err := executeTarget(ctx, k, target, target.Namespace)
+		if err != nil {
+			targetFailed(target.Namespace, err)
+		}
+		report.Results = append(report.Results, results...)
+		return
+	}
+
+	if target.AllNamespaces {
+		indent(ctx, 1, "Namespaces: all")
+	} else {
+		indent(ctx, 1, "Namespace Selector: %s", target.NamespaceSelector)
+	}
+
+	namespaces, err := k.GetNamespaces(ctx, target.NamespaceSelector)
+	if err != nil {
+		targetFailed("", err)
+		return
+	}
+
+	indent(ctx, 1, "Selected %d namespace(s) for testing", len(namespaces))
+	newline(ctx)
+
+	var failed, skipped []string
+	for _, ns := range namespaces {
+		indent(ctx, 1, "Namespace: %s", ns)
+		results, err := executeTarget(ctx, k, target, ns)
+		// most namespaces don't run the workload a target selects
+		if kubernetes.IsNoneFound(err) {
+			indent(ctx, 1, "No matching pods, skipped")
+			newline(ctx)
+			skipped = append(skipped, ns)
 			continue
 		}
+		if err != nil {
+			targetFailed(ns, err)
+		}
+		report.Results = append(report.Results, results...)
 
-		indent(1, "Selected %d ready pod(s) for testing", len(selectedPods))
+		if err != nil || slices.ContainsFunc(results, func(r TestResult) bool { return !r.Passed() }) {
+			failed = append(failed, ns)
+		}
+	}
 
-		// Execute tests for each selected pod
-		for _, pod := range selectedPods {
-			indent(1, "Pod: %s/%s", pod.Namespace, pod.Name)
+	if isDryRun(ctx) {
+		return
+	}
 
-			// Execute each test for this pod
-			for _, test := range target.Tests {
-				indent(2, "Test: %s", test.Name)
-				indent(3, "Endpoint: %s", test.Endpoint)
-				indent(3, "Type: %s", test.Type)
-				indent(3, "Expected Status: %d", test.StatusCode)
-				indent(3, "Expect Fail: %v", test.ExpectFail)
-				indent(3, "Timeout: %s", test.Timeout.String())
+	indent(ctx, 1, "Namespaces: %d passed, %d failed, %d skipped", len(namespaces)-len(failed)-len(skipped), len(failed), len(skipped))
+	for _, ns := range failed {
+		indent(ctx, 2, "FAILED: %s", ns)
+	}
+	newline(ctx)
+}
 
-				// Parse the endpoint URL for HTTP tests
-				if test.Type != TestTypeTCP {
-					_, err := url.Parse(test.Endpoint)
-					if err != nil {
-						indent(3, "Error: Invalid endpoint URL: %v", err)
-						fmt.Println()
-						allTestsPassed = false
-						continue
-					}
-				}
+// executeTarget runs the tests of the given target on the pods it
+// selects in namespace.
+func executeTarget(ctx context.Context, k *kubernetes.Kubernetes, target TestTarget, namespace string) ([]TestResult, error) {
+	selector := target.PodSelector
+	selector.Mode = dryRunSelection(ctx, selector.Mode)
 
-				// Prepare the test command
-				command := []string{"/nethax-probe"}
-				arguments := []string{
-					pf.Flagify(pf.ArgURL), test.Endpoint,
-					pf.Flagify(pf.ArgTimeout), test.Timeout.String(),
-					pf.Flagify(pf.ArgExpectedStatus), strconv.Itoa(test.StatusCode),
-				}
+	selectedPods, err := findPods(ctx, k, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
 
-				if test.Type == TestTypeTCP {
-					arguments = append(arguments, pf.Flagify(pf.ArgType), pf.TestTypeTCP)
-					if test.ExpectFail {
-						arguments = append(arguments, pf.Flagify(pf.ArgExpectFail))
-					}
-				}
+	indent(ctx, 1, "Selected %d ready pod(s) for testing", len(selectedPods))
 
-				indent(3, "Probe Image: '%s'", kubernetes.GetProbeImage(test.ProbeImage))
+	var results []TestResult
 
-				// Launch ephemeral container to execute the test
-				probedPod, probeContainerName, err := k.LaunchEphemeralContainer(ctx, &pod, test.ProbeImage, command, arguments)
-				if err != nil {
-					indent(3, "Error: Failed to launch ephemeral probe container: %v", err)
-					fmt.Println()
-					allTestsPassed = false
-					continue
-				}
+	// Execute tests for each selected pod
+	for _, pod := range selectedPods {
+		indent(ctx, 1, "Pod: %s/%s", pod.Namespace, pod.Name)
 
-				// Wait for the test to complete and get the exit status
-				exitStatus, err := k.PollEphemeralContainerStatus(ctx, probedPod, probeContainerName)
-				if err != nil {
-					indent(3, "Result: ERROR %v", err)
-					fmt.Println()
-					allTestsPassed = false
-					continue
-				}
+		source := TestResult{Target: target.Name, Pod: &pod, Cluster: target.Cluster}
+		results = append(results, runTests(ctx, source, target.Tests, podProber(k, &pod))...)
+	}
 
-				// Check if the test passed based on the probe's exit status
-				if exitStatus == 0 {
-					indent(3, "Result: PASSED")
-					fmt.Println()
-				} else {
-					indent(3, "Result: FAILED (exit code: %d)", exitStatus)
-					fmt.Println()
-					allTestsPassed = false
-				}
+	return results, nil
+}
+
+// executeNodeTarget runs the tests of the given target from the host
+// network namespace of the nodes it selects.
+func executeNodeTarget(ctx context.Context, k *kubernetes.Kubernetes, target TestTarget) ([]TestResult, error) {
+	namespace := target.Namespace
+	if namespace == "" {
+		namespace = corev1.NamespaceDefault
+	}
+
+	selector := *target.NodeSelector
+	selector.Mode = dryRunSelection(ctx, selector.Mode)
+
+	selectedNodes, err := findNodes(ctx, k, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	indent(ctx, 1, "Selected %d ready node(s) for testing", len(selectedNodes))
+
+	var results []TestResult
+
+	for _, node := range selectedNodes {
+		indent(ctx, 1, "Node: %s", node.Name)
+
+		source := TestResult{Target: target.Name, Node: node.Name, Cluster: target.Cluster}
+		results = append(results, runTests(ctx, source, target.Tests, nodeProber(k, &node, namespace))...)
+	}
+
+	return results, nil
+}
+
+// probeStartTimeout is how long to wait for a probe container to
+// start, including pulling its image, on top of the time its test
+// can take.
+const probeStartTimeout = 2 * time.Minute
+
+// prober runs the probe command in a given network namespace, waiting
+// up to timeout for it to terminate, and returns its exit status and
+// logs. The logs are empty if they could not be read.
+type prober func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error)
+
+// podProber returns a prober that runs in an ephemeral container of
+// pod.
+func podProber(k *kubernetes.Kubernetes, pod *corev1.Pod) prober {
+	return func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+		probedPod, probeContainerName, err := k.LaunchEphemeralContainer(ctx, pod, probeImage, command, args)
+		if err != nil {
+			return -1, "", fmt.Errorf("failed to launch ephemeral probe container: %w", err)
+		}
+
+		exitCode, err := k.PollEphemeralContainerStatus(ctx, probedPod, probeContainerName, timeout)
+		if err != nil {
+			return exitCode, "", err
+		}
+
+		logs, _ := k.GetContainerLogs(ctx, probedPod, probeContainerName)
+		return exitCode, logs, nil
+	}
+}
+
+// nodeProber returns a prober that runs in a host network pod pinned
+// to node, deleting the pod once the probe finishes.
+func nodeProber(k *kubernetes.Kubernetes, node *corev1.Node, namespace string) prober {
+	return func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+		probePod, err := k.LaunchHostNetworkPod(ctx, node, namespace, probeImage, command, args)
+		if err != nil {
+			return -1, "", fmt.Errorf("failed to launch host network probe pod: %w", err)
+		}
+		defer func() {
+			// always clean up, even if the run was cancelled
+			if err := k.DeletePod(context.WithoutCancel(ctx), probePod); err != nil {
+				indent(ctx, 3, "Warning: %v", err)
 			}
+		}()
+
+		exitCode, err := k.PollPodStatus(ctx, probePod, timeout)
+		if err != nil {
+			return exitCode, "", err
+		}
+
+		logs, _ := k.GetContainerLogs(ctx, probePod, probePod.Spec.Containers[0].Name)
+		return exitCode, logs, nil
+	}
+}
+
+// runTests runs each of the given tests with the prober, returning
+// their results. The source result holds where the tests are run
+// from. With --until-pass, the tests that failed are then rerun until
+// they pass or the deadline expires. In a dry run, the probes are only
+// printed, and there are no results.
+func runTests(ctx context.Context, source TestResult, tests []Test, probe prober) []TestResult {
+	if isDryRun(ctx) {
+		printProbes(ctx, tests)
+		return nil
+	}
+
+	results := make([]TestResult, len(tests))
+	starts := make([]time.Time, len(tests))
+
+	for i, test := range tests {
+		if ctx.Err() != nil {
+			// the run was cancelled, skip the remaining tests
+			results = results[:i]
+			break
+		}
+		starts[i] = time.Now()
+		results[i] = runTest(ctx, source, test, probe)
+	}
+
+	if deadline, ok := untilPassDeadline(ctx); ok {
+		rerunUntilPass(ctx, deadline, source, results, starts, probe)
+	}
+
+	return results
+}
+
+// runTest runs test with the prober, returning its result.
+func runTest(ctx context.Context, source TestResult, test Test, probe prober) TestResult {
+	result := source
+	result.Test = test
+
+	ctx, span := tracer.Start(ctx, "test", trace.WithAttributes(
+		attribute.String("nethax.test", test.Name),
+		attribute.String("nethax.test.endpoint", test.Endpoint),
+		attribute.String("nethax.test.type", test.Type.String()),
+		attribute.Bool("nethax.test.expect_fail", test.ExpectFail),
+		attribute.String("nethax.source", source.Source()),
+	))
+
+	indent(ctx, 2, "Test: %s", test.Name)
+	indent(ctx, 3, "Endpoint: %s", test.Endpoint)
+	indent(ctx, 3, "Type: %s", test.Type)
+	indent(ctx, 3, "Expected Status: %d", test.StatusCode)
+	indent(ctx, 3, "Expect Fail: %v", test.ExpectFail)
+	indent(ctx, 3, "Timeout: %s", test.Timeout.String())
+
+	// Parse the endpoint URL for HTTP tests
+	if test.Type != TestTypeTCP {
+		_, err := url.Parse(test.Endpoint)
+		if err != nil {
+			indent(ctx, 3, "Error: Invalid endpoint URL: %v", err)
+			newline(ctx)
+			result.Err = fmt.Errorf("%w: %w", errInvalidEndpoint, err)
+			result.ErrKind = ErrorKindConfig
+			endTestSpan(span, result)
+			return result
+		}
+	}
+
+	command, arguments := probeCommand(test)
+
+	indent(ctx, 3, "Probe Image: '%s'", kubernetes.GetProbeImage(test.ProbeImage))
+
+	// Run the probe and wait for the exit status
+	start := time.Now()
+	timeout := test.probeSpec().MaxDuration() + probeStartTimeout
+	exitCode, logs, err := probe(ctx, test.ProbeImage, command, arguments, timeout)
+	result.ExitCode, result.Err = exitCode, err
+	result.Duration = time.Since(start)
+	if err == nil && exitCode == probeExitCodeConfigError {
+		result.Err = fmt.Errorf("%w, exit code %d", errProbeConfig, exitCode)
+	}
+	if result.Err != nil {
+		result.ErrKind = errorKind(ctx, result.Err)
+	}
+	if a, ok := pf.ParseAttempts(logs); ok && a.Attempts > 1 {
+		result.Attempts = &a
+	}
+	traceProbe(ctx, logs)
+	endTestSpan(span, result)
+
+	if result.Err != nil {
+		indent(ctx, 3, "Result: ERROR (%s) %v", result.ErrKind, result.Err)
+		newline(ctx)
+		return result
+	}
+
+	// Check if the test passed based on the probe's exit status
+	if result.ExitCode == 0 {
+		indent(ctx, 3, "Result: PASSED")
+	} else {
+		indent(ctx, 3, "Result: FAILED (exit code: %d)", result.ExitCode)
+	}
+	if result.Flaky() {
+		a := result.Attempts
+		indent(ctx, 3, "Flaky: %d of %d repetition(s) passed, in %d attempt(s)", a.Passed, a.Repetitions, a.Attempts)
+	}
+	newline(ctx)
+
+	return result
+}
+
+// endTestSpan records the result of a test on its span, and ends it.
+func endTestSpan(span trace.Span, result TestResult) {
+	if result.Err != nil {
+		tracing.RecordError(span, result.Err) //nolint:errcheck
+	} else {
+		span.SetAttributes(attribute.Int("nethax.exit_code", int(result.ExitCode)))
+		if !result.Passed() {
+			span.SetStatus(codes.Error, "test failed")
 		}
 	}
+	span.End()
+}
 
-	return allTestsPassed
+// probeCommand returns the command and arguments used to run test in
+// the probe container.
+func probeCommand(test Test) ([]string, []string) {
+	return []string{"/nethax-probe"}, []string{pf.Flagify(pf.ArgSpec), test.probeSpec().Encode()}
 }
 
 // isPodReady checks if a pod is ready by looking at its Ready condition
@@ -185,8 +660,25 @@ func isPodReady(pod *corev1.Pod) bool {
 	return false
 }
 
-func findPods(ctx context.Context, k *kubernetes.Kubernetes, namespace string, selector PodSelector) ([]corev1.Pod, error) {
-	pods, err := k.GetPods(ctx, namespace, selector.Labels, selector.Fields)
+func findPods(ctx context.Context, k *kubernetes.Kubernetes, namespace string, selector PodSelector) (_ []corev1.Pod, err error) {
+	ctx, span := tracer.Start(ctx, "select pods", trace.WithAttributes(attribute.String("k8s.namespace.name", namespace)))
+	defer func() {
+		tracing.RecordError(span, err) //nolint:errcheck
+		span.End()
+	}()
+
+	var pods []corev1.Pod
+
+	if w := selector.Workload; w != nil {
+		// workloads are namespaced, so we cannot look for them
+		// across all namespaces
+		if namespace == "" {
+			namespace = corev1.NamespaceDefault
+		}
+		pods, err = k.GetWorkloadPods(ctx, namespace, w.Kind, w.Name, selector.Labels, selector.Fields)
+	} else {
+		pods, err = k.GetPods(ctx, namespace, selector.Labels, selector.Fields)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to find pods: %w", err)
 	}
@@ -194,35 +686,68 @@ func findPods(ctx context.Context, k *kubernetes.Kubernetes, namespace string, s
 	return selectPods(selector.Mode, pods)
 }
 
+var errMaxDuration = errors.New("--max-duration exceeded")
+
 var (
 	errInvalidSelectionMode = errors.New("invalid pod selection mode")
 	errNoReadyPods          = errors.New("no ready pods found")
+	errNoReadyNodes         = errors.New("no ready nodes found")
 )
 
 func selectPods(mode SelectionMode, pods []corev1.Pod) ([]corev1.Pod, error) {
+	return selectReady(mode, pods, isPodReady, errNoReadyPods)
+}
+
+// selectReady selects the ready items according to mode, returning
+// errNone if none of them are ready.
+func selectReady[T any](mode SelectionMode, items []T, ready func(*T) bool, errNone error) ([]T, error) {
 	if mode != SelectionModeAll && mode != SelectionModeRandom {
 		return nil, fmt.Errorf("%w: %s", errInvalidSelectionMode, mode)
 	}
 
-	var selectedPods []corev1.Pod
+	var selected []T
 
-	// Only select pods that have Ready condition set to true
-	for i := range pods {
-		if isPodReady(&pods[i]) {
-			selectedPods = append(selectedPods, pods[i])
+	// Only select items that have Ready condition set to true
+	for i := range items {
+		if ready(&items[i]) {
+			selected = append(selected, items[i])
 		}
 	}
 
-	if len(selectedPods) == 0 {
-		return nil, errNoReadyPods
+	if len(selected) == 0 {
+		return nil, errNone
 	}
 
-	// Select pods based on the selection mode
+	// Select items based on the selection mode
 	if mode == SelectionModeRandom {
-		// Select one random pod from the ready pods
-		randomIndex := rand.Intn(len(selectedPods))
-		selectedPods = []corev1.Pod{selectedPods[randomIndex]}
+		// Select one random item from the ready ones
+		randomIndex := rand.Intn(len(selected))
+		selected = []T{selected[randomIndex]}
 	}
 
-	return selectedPods, nil
+	return selected, nil
+}
+
+// isNodeReady checks if a node is ready by looking at its Ready
+// condition
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func findNodes(ctx context.Context, k *kubernetes.Kubernetes, selector NodeSelector) ([]corev1.Node, error) {
+	nodes, err := k.GetNodes(ctx, selector.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find nodes: %w", err)
+	}
+
+	return selectNodes(selector.Mode, nodes)
+}
+
+func selectNodes(mode SelectionMode, nodes []corev1.Node) ([]corev1.Node, error) {
+	return selectReady(mode, nodes, isNodeReady, errNoReadyNodes)
 }
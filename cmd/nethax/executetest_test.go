@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/grafana/nethax/pkg/kubernetes"
+	pf "github.com/grafana/nethax/pkg/probeflags"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testClient "k8s.io/client-go/kubernetes/fake"
 )
 
 func TestIsPodReady(t *testing.T) {
@@ -212,3 +220,259 @@ func dumpPods(pods []corev1.Pod) []podDump {
 	}
 	return res
 }
+
+func TestSelectNodes(t *testing.T) {
+	node := func(name string, status corev1.ConditionStatus) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: status},
+				},
+			},
+		}
+	}
+
+	nodes := []corev1.Node{
+		node("node-0", corev1.ConditionTrue),
+		node("node-1", corev1.ConditionFalse),
+		node("node-2", corev1.ConditionTrue),
+		node("node-3", corev1.ConditionUnknown),
+	}
+
+	t.Run("all", func(t *testing.T) {
+		got, err := selectNodes(SelectionModeAll, nodes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 2 || got[0].Name != "node-0" || got[1].Name != "node-2" {
+			t.Fatalf("expecting ready nodes node-0 and node-2, got %v", got)
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		got, err := selectNodes(SelectionModeRandom, nodes)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 1 || !isNodeReady(&got[0]) {
+			t.Fatalf("expecting 1 ready node, got %v", got)
+		}
+	})
+
+	t.Run("no ready nodes", func(t *testing.T) {
+		_, err := selectNodes(SelectionModeAll, nodes[1:2])
+		if !errors.Is(err, errNoReadyNodes) {
+			t.Fatalf("expecting error %v, got %v", errNoReadyNodes, err)
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		_, err := selectNodes("foo", nodes)
+		if !errors.Is(err, errInvalidSelectionMode) {
+			t.Fatalf("expecting error %v, got %v", errInvalidSelectionMode, err)
+		}
+	})
+}
+
+func TestProbeCommand(t *testing.T) {
+	tests := map[string]struct {
+		test Test
+		exp  pf.Spec
+	}{
+		"http": {
+			Test{Endpoint: "http://cart", Timeout: time.Second, StatusCode: 200},
+			pf.Spec{Type: pf.TestTypeHTTP, Endpoint: "http://cart", Timeout: time.Second, ExpectedStatus: 200},
+		},
+		"tcp": {
+			Test{Endpoint: "cart:80", Timeout: time.Second, Type: TestTypeTCP, ExpectFail: true},
+			pf.Spec{Type: pf.TestTypeTCP, Endpoint: "cart:80", Timeout: time.Second, ExpectFail: true},
+		},
+		"dns": {
+			Test{Endpoint: "cart.shop", Timeout: time.Second, Type: TestTypeDNS, ExpectFail: true},
+			pf.Spec{Type: pf.TestTypeDNS, Endpoint: "cart.shop", Timeout: time.Second, ExpectFail: true},
+		},
+		"attempts": {
+			Test{Endpoint: "cart:80", Timeout: time.Second, Type: TestTypeTCP, Retries: 2, RetryInterval: 3 * time.Second, Repeat: 5, MinSuccessRate: 0.8},
+			pf.Spec{Type: pf.TestTypeTCP, Endpoint: "cart:80", Timeout: time.Second, Retries: 2, RetryInterval: 3 * time.Second, Repeat: 5, MinSuccessRate: 0.8},
+		},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			command, args := probeCommand(tt.test)
+			if !slices.Equal([]string{"/nethax-probe"}, command) {
+				t.Errorf("unexpected command %q", command)
+			}
+			if len(args) != 2 || args[0] != "--spec" {
+				t.Fatalf("expecting the spec argument, got %q", args)
+			}
+
+			spec, err := pf.DecodeSpec(args[1])
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.exp.Version = pf.SpecVersion
+			if spec != tt.exp {
+				t.Errorf("expecting spec\n%+v\ngot\n%+v", tt.exp, spec)
+			}
+		})
+	}
+
+	t.Run("types", func(t *testing.T) {
+		for tt := TestTypeHTTP; tt <= TestTypeDNS; tt++ {
+			_, args := probeCommand(Test{Endpoint: "cart", Type: tt})
+			spec, err := pf.DecodeSpec(args[1])
+			if err != nil || spec.Type != tt.String() {
+				t.Errorf("%s: expecting the probe to run the type of the test, got %q (%v)", tt, spec.Type, err)
+			}
+		}
+	})
+}
+
+func TestRunTests_Attempts(t *testing.T) {
+	tests := []Test{
+		{Name: "flaky", Endpoint: "cart:80", Type: TestTypeTCP, Repeat: 4, MinSuccessRate: 0.75},
+		{Name: "steady", Endpoint: "cart:80", Type: TestTypeTCP, Repeat: 4},
+		{Name: "once", Endpoint: "cart:80", Type: TestTypeTCP},
+	}
+
+	logs := []string{
+		pf.Attempts{Repetitions: 4, Passed: 3, Attempts: 4}.String(),
+		pf.Attempts{Repetitions: 4, Passed: 4, Attempts: 4}.String(),
+		pf.Attempts{Repetitions: 1, Passed: 1, Attempts: 1}.String(),
+	}
+
+	var (
+		n        int
+		timeouts []time.Duration
+	)
+	probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+		defer func() { n++ }()
+		timeouts = append(timeouts, timeout)
+		return 0, "Probe succeeded\n" + logs[n] + "\n", nil
+	}
+
+	var out bytes.Buffer
+	results := runTests(withOutput(t.Context(), &out), TestResult{Target: "frontend", Node: "node-1"}, tests, probe)
+
+	for i, exp := range []bool{true, false, false} {
+		if g := results[i].Flaky(); exp != g {
+			t.Errorf("%s: expecting flaky %v, got %v", tests[i].Name, exp, g)
+		}
+	}
+	if results[2].Attempts != nil {
+		t.Errorf("expecting no attempts for a single attempt, got %+v", results[2].Attempts)
+	}
+	// all the repetitions must fit in the wait, besides pulling the image
+	expTimeouts := []time.Duration{4*pf.DefaultTimeout + probeStartTimeout, 4*pf.DefaultTimeout + probeStartTimeout, pf.DefaultTimeout + probeStartTimeout}
+	if !slices.Equal(expTimeouts, timeouts) {
+		t.Errorf("expecting probe timeouts %v, got %v", expTimeouts, timeouts)
+	}
+	if !strings.Contains(out.String(), "Flaky: 3 of 4 repetition(s) passed, in 4 attempt(s)") {
+		t.Errorf("expecting flaky test in output, got\n%s", out.String())
+	}
+}
+
+func TestExecuteTest_Cancelled(t *testing.T) {
+	tests := []Test{
+		{Name: "cart", Endpoint: "cart:80", Type: TestTypeTCP},
+		{Name: "ads", Endpoint: "ads:80", Type: TestTypeTCP},
+		{Name: "billing", Endpoint: "billing:80", Type: TestTypeTCP},
+	}
+
+	t.Run("tests", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(t.Context())
+
+		// interrupted while probing the second test
+		var n int
+		probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+			if n++; n == 2 {
+				cancel(errInterrupted)
+				return -1, "", ctx.Err()
+			}
+			return 0, "", nil
+		}
+
+		var out bytes.Buffer
+		results := runTests(withOutput(ctx, &out), TestResult{Target: "frontend", Node: "node-1"}, tests, probe)
+
+		if len(results) != 2 || !results[0].Passed() || results[1].Err == nil {
+			t.Errorf("expecting the first test to pass and the second to fail to run, got %+v", results)
+		}
+		if n != 2 {
+			t.Errorf("expecting the remaining tests not to run, ran %d", n)
+		}
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(t.Context())
+		cancel(errInterrupted)
+
+		plan := &TestPlan{
+			Name:        "shop",
+			TestTargets: []TestTarget{{Name: "frontend", PodSelector: PodSelector{Mode: SelectionModeAll}, Tests: tests}},
+		}
+
+		var out bytes.Buffer
+		report := executeTest(withOutput(ctx, &out), kubernetes.NewWithClient(testClient.NewClientset()), plan)
+
+		if !errors.Is(report.Cancelled, errInterrupted) || report.Passed() {
+			t.Errorf("expecting a failed report cancelled by %v, got %+v", errInterrupted, report)
+		}
+		if len(report.Errors) > 0 || len(report.Results) > 0 {
+			t.Errorf("expecting the targets not to run, got %+v", report)
+		}
+		if !strings.Contains(out.String(), "Run cancelled: interrupted") {
+			t.Errorf("expecting the cancellation in the output, got\n%s", out.String())
+		}
+	})
+}
+
+func TestExecuteTest_NamespacesWithoutPods(t *testing.T) {
+	ready := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+
+	var objects []runtime.Object
+	for _, ns := range []string{"tenant-a", "tenant-b", "kube-system"} {
+		objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}})
+	}
+	objects = append(objects, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "frontend-0", Labels: map[string]string{"app": "frontend"}},
+		Status:     corev1.PodStatus{Conditions: ready},
+	})
+
+	k := kubernetes.NewWithClient(testClient.NewClientset(objects...))
+
+	plan := &TestPlan{
+		Name: "tenants",
+		TestTargets: []TestTarget{{
+			Name:          "tenants can't reach kube-system",
+			AllNamespaces: true,
+			PodSelector:   PodSelector{Mode: SelectionModeAll, Labels: "app=frontend"},
+		}},
+	}
+
+	var out bytes.Buffer
+	report := executeTest(withOutput(t.Context(), &out), k, plan)
+
+	if len(report.Errors) > 0 || !report.Passed() {
+		t.Errorf("expecting the namespaces without pods to be skipped, got errors %+v", report.Errors)
+	}
+	for _, line := range []string{
+		" Namespace: tenant-b\n No matching pods, skipped",
+		" Namespaces: 1 passed, 0 failed, 2 skipped",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expecting %q in output, got\n%s", line, out.String())
+		}
+	}
+
+	t.Run("single namespace", func(t *testing.T) {
+		plan.TestTargets[0].AllNamespaces, plan.TestTargets[0].Namespace = false, "tenant-b"
+
+		report := executeTest(withOutput(t.Context(), &out), k, plan)
+		if len(report.Errors) != 1 || report.Errors[0].Kind != ErrorKindSelection {
+			t.Errorf("expecting a selection error, got %+v", report.Errors)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// testFilter selects the targets and tests of a plan to run.
+type testFilter struct {
+	// Targets and Tests are patterns the names of the targets and
+	// tests to run must match one of, if any.
+	Targets, Tests []string
+	// Tags are the tags the tests to run must have one of, if any, and
+	// SkipTags the tags they must have none of. The tags of a test
+	// include the tags of its target.
+	Tags, SkipTags []string
+}
+
+// addFilterFlags adds the flags selecting the tests to run to cmd.
+func addFilterFlags(cmd *cobra.Command, f *testFilter) {
+	cmd.Flags().StringArrayVar(&f.Targets, "target", nil, "Only run the targets whose name matches this glob pattern, or regular expression if enclosed in slashes (e.g. '/^shop/'). Can be repeated.")
+	cmd.Flags().StringArrayVar(&f.Tests, "test", nil, "Only run the tests whose name matches this glob pattern, or regular expression if enclosed in slashes. Can be repeated.")
+	cmd.Flags().StringSliceVar(&f.Tags, "tags", nil, "Only run the tests with any of these comma separated tags")
+	cmd.Flags().StringSliceVar(&f.SkipTags, "skip-tags", nil, "Skip the tests with any of these comma separated tags")
+}
+
+var (
+	errInvalidPattern  = errors.New("invalid pattern")
+	errNoMatchingTests = errors.New("no tests match the filters")
+	errEmptyPattern    = errors.New("empty pattern")
+)
+
+// compilePattern returns a regular expression matching the names that
+// match pattern: a regular expression if enclosed in slashes, or else
+// a glob pattern where * matches any characters and ? a single one.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errEmptyPattern
+	}
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errInvalidPattern, pattern, err)
+		}
+		return re, nil
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+
+	return regexp.MustCompile("^" + expr + "$"), nil
+}
+
+// matcher returns a function reporting whether a name matches any of
+// patterns, or true if there are none.
+func matcher(patterns []string) (func(string) bool, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+
+	return func(name string) bool {
+		return len(res) == 0 || slices.ContainsFunc(res, func(re *regexp.Regexp) bool {
+			return re.MatchString(name)
+		})
+	}, nil
+}
+
+// empty returns whether f selects every test.
+func (f testFilter) empty() bool {
+	return len(f.Targets) == 0 && len(f.Tests) == 0 && len(f.Tags) == 0 && len(f.SkipTags) == 0
+}
+
+// apply returns a copy of plan with only the targets and tests
+// selected by f. Targets left without tests are removed, so their
+// destinations are not deployed.
+func (f testFilter) apply(plan *TestPlan) (*TestPlan, error) {
+	if f.empty() {
+		return plan, nil
+	}
+
+	matchTarget, err := matcher(f.Targets)
+	if err != nil {
+		return nil, fmt.Errorf("--target: %w", err)
+	}
+	matchTest, err := matcher(f.Tests)
+	if err != nil {
+		return nil, fmt.Errorf("--test: %w", err)
+	}
+
+	hasAny := func(tags, of []string) bool {
+		return slices.ContainsFunc(tags, func(t string) bool { return slices.Contains(of, t) })
+	}
+
+	res := *plan
+	res.TestTargets = nil
+
+	for _, target := range plan.TestTargets {
+		if !matchTarget(target.Name) {
+			continue
+		}
+
+		var tests []Test
+		for _, test := range target.Tests {
+			tags := slices.Concat(target.Tags, test.Tags)
+			if !matchTest(test.Name) || (len(f.Tags) > 0 && !hasAny(tags, f.Tags)) || hasAny(tags, f.SkipTags) {
+				continue
+			}
+			tests = append(tests, test)
+		}
+
+		if len(tests) > 0 {
+			target.Tests = tests
+			res.TestTargets = append(res.TestTargets, target)
+		}
+	}
+
+	if len(res.TestTargets) == 0 {
+		return nil, errNoMatchingTests
+	}
+
+	return &res, nil
+}
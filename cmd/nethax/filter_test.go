@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTestFilter(t *testing.T) {
+	plan := &TestPlan{
+		Name: "shop",
+		TestTargets: []TestTarget{
+			{
+				Name: "frontend egress",
+				Tags: []string{"egress"},
+				Tests: []Test{
+					{Name: "internet", Tags: []string{"internet"}},
+					{Name: "dns lookup", Tags: []string{"dns"}},
+					{Name: "cart"},
+				},
+			},
+			{
+				Name: "coredns",
+				Tests: []Test{
+					{Name: "dns upstream", Tags: []string{"dns", "slow"}},
+					{Name: "metrics"},
+				},
+			},
+			{
+				Name:  "billing ingress",
+				Tags:  []string{"ingress"},
+				Tests: []Test{{Name: "blocked from shop"}},
+			},
+		},
+	}
+
+	// tests returns the target/test names of plan
+	tests := func(plan *TestPlan) []string {
+		var res []string
+		for _, target := range plan.TestTargets {
+			for _, test := range target.Tests {
+				res = append(res, target.Name+"/"+test.Name)
+			}
+		}
+		return res
+	}
+
+	for n, tt := range map[string]struct {
+		filter testFilter
+		exp    []string
+	}{
+		"none": {
+			testFilter{},
+			tests(plan),
+		},
+		"target glob": {
+			testFilter{Targets: []string{"*egress"}},
+			[]string{"frontend egress/internet", "frontend egress/dns lookup", "frontend egress/cart"},
+		},
+		"target regexp": {
+			testFilter{Targets: []string{"/^(core|billing)/"}},
+			[]string{"coredns/dns upstream", "coredns/metrics", "billing ingress/blocked from shop"},
+		},
+		"tests": {
+			testFilter{Tests: []string{"dns*", "metric?"}},
+			[]string{"frontend egress/dns lookup", "coredns/dns upstream", "coredns/metrics"},
+		},
+		"tags": {
+			testFilter{Tags: []string{"dns"}},
+			[]string{"frontend egress/dns lookup", "coredns/dns upstream"},
+		},
+		"target tags": {
+			testFilter{Tags: []string{"egress", "ingress"}},
+			[]string{"frontend egress/internet", "frontend egress/dns lookup", "frontend egress/cart", "billing ingress/blocked from shop"},
+		},
+		"skip tags": {
+			testFilter{Tags: []string{"dns"}, SkipTags: []string{"slow"}},
+			[]string{"frontend egress/dns lookup"},
+		},
+		"skip target tags": {
+			testFilter{SkipTags: []string{"egress"}},
+			[]string{"coredns/dns upstream", "coredns/metrics", "billing ingress/blocked from shop"},
+		},
+		"combined": {
+			testFilter{Targets: []string{"frontend*"}, Tests: []string{"/^(cart|internet)$/"}, SkipTags: []string{"internet"}},
+			[]string{"frontend egress/cart"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := tt.filter.apply(plan)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if g := tests(got); !slices.Equal(tt.exp, g) {
+				t.Errorf("expecting tests\n%q\ngot\n%q", tt.exp, g)
+			}
+			if got.Name != plan.Name {
+				t.Errorf("expecting plan %s, got %s", plan.Name, got.Name)
+			}
+		})
+	}
+
+	if len(plan.TestTargets) != 3 || len(plan.TestTargets[0].Tests) != 3 {
+		t.Error("expecting the plan not to be modified")
+	}
+
+	for n, tt := range map[string]struct {
+		filter testFilter
+		err    error
+	}{
+		"no match":        {testFilter{Tags: []string{"l7"}}, errNoMatchingTests},
+		"invalid regexp":  {testFilter{Tests: []string{"/(/"}}, errInvalidPattern},
+		"empty pattern":   {testFilter{Targets: []string{""}}, errEmptyPattern},
+		"skip everything": {testFilter{Targets: []string{"coredns"}, SkipTags: []string{"dns"}, Tests: []string{"dns*"}}, errNoMatchingTests},
+	} {
+		t.Run(n, func(t *testing.T) {
+			if _, err := tt.filter.apply(plan); !errors.Is(err, tt.err) {
+				t.Errorf("expecting error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/netpol"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Generate returns the generate command
+func Generate() *cobra.Command {
+	var (
+		fromNetworkPolicies          bool
+		manifests                    []string
+		kontext, namespace, planName string
+		output                       string
+		timeout                      time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "generate --from-networkpolicies [-f manifests.yaml]",
+		Short: "Generate a test plan",
+		Long: `Generate a test plan from the network policies in the cluster, or in the
+given manifest files. The plan contains positive tests for every allowed
+peer and port that can be resolved to a Service or IP address, and
+negative tests for representative denied peers.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !fromNetworkPolicies {
+				cmd.Println("Error: a source must be specified, e.g. --from-networkpolicies")
+				cmd.Help() //nolint:errcheck
+				os.Exit(exitCodeConfigError)
+			}
+
+			inv, err := loadInventory(cmd.Context(), kontext, namespace, manifests)
+			if err != nil {
+				cmd.PrintErrf("Error loading network policies: %v\n", err)
+				os.Exit(exitCodeConfigError)
+			}
+
+			g := &generator{inv: inv, timeout: timeout}
+			plan := g.generate(planName, namespace)
+			for _, w := range append(inv.Warnings, g.warnings...) {
+				cmd.PrintErrf("Warning: %s\n", w)
+			}
+
+			out := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					cmd.PrintErrf("Error creating output file: %v\n", err)
+					os.Exit(exitCodeConfigError)
+				}
+				defer f.Close() //nolint:errcheck
+				out = f
+			}
+
+			if err := writeTestPlan(out, plan); err != nil {
+				cmd.PrintErrf("Error writing test plan: %v\n", err)
+				os.Exit(exitCodeFailure)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&fromNetworkPolicies, "from-networkpolicies", false, "Generate tests from NetworkPolicy objects")
+	cmd.Flags().StringSliceVarP(&manifests, "file", "f", nil, "Manifest files to read objects from instead of the cluster. Can be repeated.")
+	cmd.Flags().StringVarP(&kontext, "context", "c", "", "Kubernetes context to connect. Leave empty for in-cluster context.")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only generate tests for policies in this namespace. Leave empty for all namespaces.")
+	cmd.Flags().StringVar(&planName, "name", "Generated from NetworkPolicies", "Name of the generated test plan")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the test plan to this file instead of stdout")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "Timeout of the generated tests")
+
+	return cmd
+}
+
+// loadInventory loads network policies and related objects from the
+// given manifest files or, if none, from the cluster. Policies are
+// filtered by namespace, if not blank.
+func loadInventory(ctx context.Context, kontext, namespace string, manifests []string) (*netpol.Inventory, error) {
+	inv := new(netpol.Inventory)
+
+	if len(manifests) > 0 {
+		for _, m := range manifests {
+			if err := readManifestFile(inv, m); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		k, err := kubernetes.New(kontext)
+		if err != nil {
+			return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+		}
+		if err := loadClusterInventory(ctx, k, inv); err != nil {
+			return nil, err
+		}
+	}
+
+	if namespace != "" {
+		inv.Policies = slices.DeleteFunc(inv.Policies, func(p netpol.Policy) bool {
+			return p.Namespace != namespace
+		})
+	}
+
+	return inv, nil
+}
+
+func readManifestFile(inv *netpol.Inventory, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("opening manifest: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	if err := inv.ReadManifests(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+func loadClusterInventory(ctx context.Context, k *kubernetes.Kubernetes, inv *netpol.Inventory) error {
+	nps, err := k.ListNetworkPolicies(ctx, "")
+	if err != nil {
+		return err
+	}
+	if err := inv.AddNetworkPolicies(nps...); err != nil {
+		return err
+	}
+	if err := loadCustomPolicies(ctx, k, inv); err != nil {
+		return err
+	}
+
+	if inv.Namespaces, err = k.ListNamespaces(ctx); err != nil {
+		return err
+	}
+	if inv.Services, err = k.ListServices(ctx, ""); err != nil {
+		return err
+	}
+	if inv.Pods, err = k.ListPods(ctx, ""); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadCustomPolicies loads the Cilium and Calico policies in the
+// cluster, if their CRDs are installed. Calico policies are read from
+// the Calico API server, or from its CRDs if it is not installed.
+func loadCustomPolicies(ctx context.Context, k *kubernetes.Kubernetes, inv *netpol.Inventory) error {
+	resources := [][]schema.GroupVersionResource{
+		{netpol.CiliumNetworkPolicies},
+		{netpol.CiliumClusterwideNetworkPolicies},
+		{netpol.CalicoNetworkPolicies, netpol.CalicoCRDNetworkPolicies},
+		{netpol.CalicoGlobalNetworkPolicies, netpol.CalicoCRDGlobalNetworkPolicies},
+	}
+
+	for _, alternatives := range resources {
+		for _, gvr := range alternatives {
+			objs, err := k.ListResources(ctx, gvr, "")
+			if apierrors.IsNotFound(err) {
+				continue // not installed
+			} else if err != nil {
+				return err
+			}
+
+			if err := inv.AddCustomPolicies(objs...); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// writeTestPlan writes plan in the format read by ParseTestPlan.
+func writeTestPlan(w io.Writer, plan *TestPlan) error {
+	doc := struct {
+		TestPlan *TestPlan `yaml:"testPlan"`
+	}{plan}
+
+	return yaml.NewEncoder(w).Encode(doc)
+}
+
+// generator builds a TestPlan from network policies.
+type generator struct {
+	inv      *netpol.Inventory
+	timeout  time.Duration
+	warnings []string
+}
+
+func (g *generator) warnf(format string, a ...any) {
+	g.warnings = append(g.warnings, fmt.Sprintf(format, a...))
+}
+
+func (g *generator) generate(name, namespace string) *TestPlan {
+	policies := slices.Clone(g.inv.Policies)
+	slices.SortFunc(policies, func(a, b netpol.Policy) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+
+	plan := &TestPlan{
+		Name:        name,
+		Description: fmt.Sprintf("Generated by nethax from %d network policies", len(policies)),
+	}
+	if namespace != "" {
+		plan.Description += " in namespace " + namespace
+	}
+
+	for i := range policies {
+		p := &policies[i]
+		if p.IsolatesEgress {
+			plan.TestTargets = append(plan.TestTargets, g.egressTargets(p)...)
+		}
+		if p.IsolatesIngress {
+			plan.TestTargets = append(plan.TestTargets, g.ingressTargets(p)...)
+		}
+	}
+
+	return plan
+}
+
+// egressTargets returns a target running tests from the pods selected by
+// the policy to its allowed and denied peers, and to a representative
+// denied one.
+func (g *generator) egressTargets(p *netpol.Policy) []TestTarget {
+	target := g.policyTarget(p, policyID(p)+" egress")
+
+	for _, rule := range p.Egress {
+		if rule.Action == netpol.ActionPass {
+			continue
+		}
+		verb, expectFail := ruleVerb(rule)
+
+		for _, peer := range rule.Peers {
+			switch {
+			case peer.IPBlock != nil:
+				target.Tests = append(target.Tests, g.ipBlockTests(p, peer.IPBlock, rule)...)
+				continue
+			case peer.FQDN != "":
+				target.Tests = append(target.Tests, g.fqdnTests(p, peer.FQDN, rule)...)
+				continue
+			case peer.Entity != "":
+				g.warnf("%s: cannot pick a destination for egress peer %s, skipping", p, peer)
+				continue
+			}
+
+			svcs := g.peerServices(p.Namespace, peer)
+			if len(svcs) == 0 {
+				g.warnf("%s: no Service found for egress peer %s, skipping", p, peer)
+			}
+			for _, svc := range svcs {
+				for _, port := range servicePorts(svc, rule) {
+					target.Tests = append(target.Tests, g.serviceTests(p, fmt.Sprintf("%s egress to", verb), svc, port, rule, expectFail)...)
+				}
+			}
+		}
+	}
+
+	if test, ok := g.deniedEgressTest(p); ok {
+		target.Tests = append(target.Tests, test)
+	}
+
+	if len(target.Tests) == 0 {
+		return nil
+	}
+
+	return []TestTarget{target}
+}
+
+// ingressTargets returns targets running tests from each allowed or
+// denied peer of the policy to the Services selecting its pods, and
+// from a representative denied namespace.
+func (g *generator) ingressTargets(p *netpol.Policy) []TestTarget {
+	var dsts []corev1.Service
+	for _, ns := range g.policyNamespaces(p) {
+		dsts = append(dsts, g.services(ns, p.PodSelector)...)
+	}
+	if len(dsts) == 0 {
+		g.warnf("%s: no Service selects the policy pods, skipping ingress tests", p)
+		return nil
+	}
+
+	var targets []TestTarget
+
+	for i, rule := range p.Ingress {
+		if rule.Action == netpol.ActionPass {
+			continue
+		}
+		verb, expectFail := ruleVerb(rule)
+
+		peers := rule.Peers
+		if len(peers) == 0 {
+			// all sources are allowed, so test from the policy's own
+			// namespace
+			peers = []netpol.Peer{{PodSelector: &metav1.LabelSelector{}}}
+		}
+
+		for j, peer := range peers {
+			if !peer.SelectsPods() && peer.Entity != netpol.EntityCluster && peer.Entity != netpol.EntityAll {
+				g.warnf("%s: cannot run tests from ingress peer %s, skipping", p, peer)
+				continue
+			}
+
+			ns, ok := g.peerNamespace(p.Namespace, peer)
+			if !ok {
+				g.warnf("%s: no namespace found for ingress peer %s, skipping", p, peer)
+				continue
+			}
+
+			target := TestTarget{
+				Name:      fmt.Sprintf("%s ingress rule %d peer %d", policyID(p), i, j),
+				Namespace: ns,
+				PodSelector: PodSelector{
+					Mode:   SelectionModeRandom,
+					Labels: netpol.SelectorString(peer.PodSelector),
+				},
+			}
+			for _, svc := range dsts {
+				for _, port := range servicePorts(svc, rule) {
+					target.Tests = append(target.Tests, g.serviceTests(p, fmt.Sprintf("%s ingress to", verb), svc, port, rule, expectFail)...)
+				}
+			}
+
+			if len(target.Tests) > 0 {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	if target, ok := g.deniedIngressTarget(p, dsts); ok {
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// policyID identifies a policy in target and test names.
+func policyID(p *netpol.Policy) string {
+	if p.Namespace == "" {
+		return p.Name
+	}
+	return p.Namespace + "/" + p.Name
+}
+
+// ruleVerb returns how tests of the rule are named, and whether they
+// are expected to fail.
+func ruleVerb(rule netpol.Rule) (string, bool) {
+	if rule.Action == netpol.ActionDeny {
+		return "denies", true
+	}
+	return "allows", false
+}
+
+// policyTarget returns a target selecting the pods the policy applies
+// to, in all the namespaces it applies to.
+func (g *generator) policyTarget(p *netpol.Policy, name string) TestTarget {
+	target := TestTarget{
+		Name:      name,
+		Namespace: p.Namespace,
+		PodSelector: PodSelector{
+			Mode:   SelectionModeRandom,
+			Labels: netpol.SelectorString(p.PodSelector),
+		},
+	}
+
+	if p.Namespace == "" {
+		target.NamespaceSelector = netpol.SelectorString(p.NamespaceSelector)
+		target.AllNamespaces = target.NamespaceSelector == ""
+	}
+
+	return target
+}
+
+// policyNamespaces returns the namespaces the policy applies to, sorted
+// alphabetically.
+func (g *generator) policyNamespaces(p *netpol.Policy) []string {
+	if p.Namespace != "" {
+		return []string{p.Namespace}
+	}
+
+	var namespaces []string
+	for _, ns := range g.inv.NamespaceNames() {
+		if selectorMatches(p.NamespaceSelector, g.inv.NamespaceLabels(ns)) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// deniedEgressTest returns a test from the policy pods to the first
+// Service that no policy allows them to reach.
+func (g *generator) deniedEgressTest(p *netpol.Policy) (Test, bool) {
+	namespaces := g.policyNamespaces(p)
+	if len(namespaces) == 0 {
+		return Test{}, false
+	}
+
+	src, ok := g.sampleEndpoint(namespaces[0], p.PodSelector)
+	if !ok {
+		return Test{}, false
+	}
+
+	for _, svc := range g.sortedServices() {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		dst := netpol.Endpoint{
+			Namespace:       svc.Namespace,
+			NamespaceLabels: g.inv.NamespaceLabels(svc.Namespace),
+			Labels:          svc.Spec.Selector,
+		}
+
+		for _, port := range servicePorts(svc, netpol.Rule{}) {
+			target, ok := targetPortNumber(port)
+			if !ok {
+				continue
+			}
+			d := netpol.Evaluate(g.inv.Policies, netpol.Egress, src, dst, target, corev1.ProtocolTCP)
+			if !d.Allowed() {
+				return g.test(fmt.Sprintf("%s denies egress to", p.Name), svc, port, true), true
+			}
+		}
+	}
+
+	return Test{}, false
+}
+
+// deniedIngressTarget returns a target running tests from the first
+// namespace whose pods no policy allows to reach the policy pods.
+func (g *generator) deniedIngressTarget(p *netpol.Policy, dsts []corev1.Service) (TestTarget, bool) {
+	svc := dsts[0]
+
+	dst, ok := g.sampleEndpoint(svc.Namespace, p.PodSelector)
+	if !ok {
+		return TestTarget{}, false
+	}
+
+	ports := servicePorts(svc, netpol.Rule{})
+	if len(ports) == 0 {
+		return TestTarget{}, false
+	}
+	port := ports[0]
+
+	namespaces := g.inv.NamespaceNames()
+	slices.Sort(namespaces)
+
+	for _, ns := range namespaces {
+		if g.hasPods() && !g.hasPodsIn(ns) {
+			continue
+		}
+		if g.namespaceAllowed(ns, dst) {
+			continue
+		}
+
+		return TestTarget{
+			Name:        fmt.Sprintf("%s ingress denied from %s", policyID(p), ns),
+			Namespace:   ns,
+			PodSelector: PodSelector{Mode: SelectionModeRandom},
+			Tests: []Test{
+				g.test(fmt.Sprintf("%s denies ingress to", p.Name), svc, port, true),
+			},
+		}, true
+	}
+
+	return TestTarget{}, false
+}
+
+// namespaceAllowed returns whether any pod of namespace ns could be
+// allowed ingress to dst by the policies isolating it.
+func (g *generator) namespaceAllowed(ns string, dst netpol.Endpoint) bool {
+	nsLabels := g.inv.NamespaceLabels(ns)
+
+	for i := range g.inv.Policies {
+		p := &g.inv.Policies[i]
+		if !p.IsolatesIngress || !p.Selects(dst) {
+			continue
+		}
+		for _, rule := range p.Ingress {
+			if rule.Action != netpol.ActionAllow {
+				continue
+			}
+			if len(rule.Peers) == 0 {
+				return true
+			}
+			for _, peer := range rule.Peers {
+				if peer.MatchesNamespace(p.Namespace, ns, nsLabels) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// ipBlockTests returns tests connecting to a sample address of an IP
+// block on every TCP port of rule, or 443 if it matches all.
+func (g *generator) ipBlockTests(p *netpol.Policy, b *netpol.IPBlock, rule netpol.Rule) []Test {
+	addr, ok := sampleAddr(b)
+	if !ok {
+		g.warnf("%s: no usable address in ipBlock %s, skipping", p, b.CIDR)
+		return nil
+	}
+
+	return g.hostTests(p, addr.String(), rule)
+}
+
+// fqdnTests returns tests connecting to the name of an FQDN peer on
+// every TCP port of rule, or 443 if it matches all. Patterns with
+// wildcards are skipped, as there's no way to pick a name that
+// resolves.
+func (g *generator) fqdnTests(p *netpol.Policy, fqdn string, rule netpol.Rule) []Test {
+	if strings.Contains(fqdn, "*") {
+		g.warnf("%s: cannot pick a name for egress peer fqdn %s, skipping", p, fqdn)
+		return nil
+	}
+
+	return g.hostTests(p, strings.TrimSuffix(fqdn, "."), rule)
+}
+
+// hostTests returns tests connecting to host on every TCP port of rule,
+// or 443 if it matches all.
+func (g *generator) hostTests(p *netpol.Policy, host string, rule netpol.Rule) []Test {
+	var ports []uint16
+	for _, port := range rule.Ports {
+		if port.Protocol != corev1.ProtocolTCP || port.Port == nil || port.Port.Type != intstr.Int {
+			continue
+		}
+		ports = append(ports, uint16(port.Port.IntVal))
+	}
+	if len(rule.Ports) == 0 {
+		ports = append(ports, 443)
+	}
+
+	verb, expectFail := ruleVerb(rule)
+
+	var tests []Test
+	for _, port := range ports {
+		endpoint := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		tests = append(tests, Test{
+			Name:       fmt.Sprintf("%s %s egress to %s", p.Name, verb, endpoint),
+			Endpoint:   endpoint,
+			Type:       TestTypeTCP,
+			ExpectFail: expectFail,
+			Timeout:    g.timeout,
+		})
+	}
+
+	return tests
+}
+
+// l7DeniedPath is requested by tests checking that HTTP rules are
+// enforced.
+const l7DeniedPath = "/nethax-l7-denied"
+
+// serviceTests returns a TCP test connecting to the given Service port
+// and, if rule restricts the HTTP requests to the port, an HTTP test
+// expecting a request it doesn't allow to be answered with a 403
+// status, as Cilium does.
+func (g *generator) serviceTests(p *netpol.Policy, what string, svc corev1.Service, port corev1.ServicePort, rule netpol.Rule, expectFail bool) []Test {
+	tests := []Test{g.test(p.Name+" "+what, svc, port, expectFail)}
+	if expectFail {
+		return tests
+	}
+
+	var httpRules []netpol.HTTPRule
+	for _, rp := range rule.Ports {
+		if !targetPortMatches(port, rp) {
+			continue
+		}
+		if len(rp.HTTP) == 0 {
+			return tests // not restricted
+		}
+		httpRules = append(httpRules, rp.HTTP...)
+	}
+
+	host := fmt.Sprintf("%s.%s.svc.cluster.local", svc.Name, svc.Namespace)
+	req := &netpol.HTTPRequest{Method: "GET", Host: host, Path: l7DeniedPath}
+	if len(httpRules) == 0 || slices.ContainsFunc(httpRules, func(r netpol.HTTPRule) bool { return r.Matches(req) }) {
+		return tests
+	}
+
+	return append(tests, Test{
+		Name:       fmt.Sprintf("%s enforces HTTP rules on %s/%s:%d", p.Name, svc.Namespace, svc.Name, port.Port),
+		Endpoint:   fmt.Sprintf("http://%s:%d%s", host, port.Port, l7DeniedPath),
+		Type:       TestTypeHTTP,
+		StatusCode: http.StatusForbidden,
+		Timeout:    g.timeout,
+	})
+}
+
+// test returns a TCP test connecting to the given Service port.
+func (g *generator) test(prefix string, svc corev1.Service, port corev1.ServicePort, expectFail bool) Test {
+	endpoint := fmt.Sprintf("%s.%s.svc.cluster.local:%d", svc.Name, svc.Namespace, port.Port)
+
+	return Test{
+		Name:       fmt.Sprintf("%s %s/%s:%d", prefix, svc.Namespace, svc.Name, port.Port),
+		Endpoint:   endpoint,
+		Type:       TestTypeTCP,
+		ExpectFail: expectFail,
+		Timeout:    g.timeout,
+	}
+}
+
+// peerServices returns the Services selecting pods matched by a peer
+// of a policy in the given namespace.
+func (g *generator) peerServices(policyNamespace string, peer netpol.Peer) []corev1.Service {
+	var svcs []corev1.Service
+	for _, ns := range g.peerNamespaces(policyNamespace, peer) {
+		svcs = append(svcs, g.services(ns, peer.PodSelector)...)
+	}
+	return svcs
+}
+
+// peerNamespaces returns the namespaces matched by a peer of a policy
+// in the given namespace, sorted alphabetically.
+func (g *generator) peerNamespaces(policyNamespace string, peer netpol.Peer) []string {
+	var namespaces []string
+	for _, ns := range g.inv.NamespaceNames() {
+		if peer.MatchesNamespace(policyNamespace, ns, g.inv.NamespaceLabels(ns)) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	slices.Sort(namespaces)
+	return namespaces
+}
+
+// peerNamespace returns a representative namespace for the peer,
+// preferring namespaces with pods it selects.
+func (g *generator) peerNamespace(policyNamespace string, peer netpol.Peer) (string, bool) {
+	namespaces := g.peerNamespaces(policyNamespace, peer)
+	if len(namespaces) == 0 {
+		if peer.NamespaceSelector == nil {
+			return policyNamespace, true
+		}
+		return "", false
+	}
+
+	for _, ns := range namespaces {
+		if len(g.podsIn(ns, peer.PodSelector)) > 0 {
+			return ns, true
+		}
+	}
+
+	return namespaces[0], true
+}
+
+// services returns the Services in namespace selecting pods matched by
+// sel, sorted by name. When pods are known they are used to resolve
+// the Services; otherwise a Service matches if its own selector
+// satisfies sel.
+func (g *generator) services(namespace string, sel *metav1.LabelSelector) []corev1.Service {
+	pods := g.podsIn(namespace, sel)
+
+	var svcs []corev1.Service
+	for _, svc := range g.sortedServices() {
+		if svc.Namespace != namespace || len(svc.Spec.Selector) == 0 {
+			continue
+		}
+
+		if g.hasPods() {
+			svcSel := labels.SelectorFromSet(svc.Spec.Selector)
+			if slices.ContainsFunc(pods, func(p corev1.Pod) bool {
+				return svcSel.Matches(labels.Set(p.Labels))
+			}) {
+				svcs = append(svcs, svc)
+			}
+			continue
+		}
+
+		if selectorMatches(sel, svc.Spec.Selector) {
+			svcs = append(svcs, svc)
+		}
+	}
+
+	return svcs
+}
+
+func (g *generator) sortedServices() []corev1.Service {
+	svcs := slices.Clone(g.inv.Services)
+	slices.SortFunc(svcs, func(a, b corev1.Service) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+	return svcs
+}
+
+func (g *generator) hasPods() bool {
+	return len(g.inv.Pods) > 0
+}
+
+func (g *generator) hasPodsIn(namespace string) bool {
+	return slices.ContainsFunc(g.inv.Pods, func(p corev1.Pod) bool {
+		return p.Namespace == namespace
+	})
+}
+
+func (g *generator) podsIn(namespace string, sel *metav1.LabelSelector) []corev1.Pod {
+	var pods []corev1.Pod
+	for _, p := range g.inv.Pods {
+		if p.Namespace == namespace && selectorMatches(sel, p.Labels) {
+			pods = append(pods, p)
+		}
+	}
+	return pods
+}
+
+// sampleEndpoint returns a representative pod endpoint in namespace
+// matching sel.
+func (g *generator) sampleEndpoint(namespace string, sel *metav1.LabelSelector) (netpol.Endpoint, bool) {
+	set, ok := netpol.SampleLabels(sel)
+	if !ok {
+		return netpol.Endpoint{}, false
+	}
+
+	return netpol.Endpoint{
+		Namespace:       namespace,
+		NamespaceLabels: g.inv.NamespaceLabels(namespace),
+		Labels:          set,
+	}, true
+}
+
+// servicePorts returns the TCP ports of svc whose target port is
+// allowed by rule.
+func servicePorts(svc corev1.Service, rule netpol.Rule) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+
+	for _, sp := range svc.Spec.Ports {
+		if sp.Protocol != "" && sp.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		if len(rule.Ports) == 0 || slices.ContainsFunc(rule.Ports, func(p netpol.Port) bool {
+			return targetPortMatches(sp, p)
+		}) {
+			ports = append(ports, sp)
+		}
+	}
+
+	return ports
+}
+
+// targetPortMatches returns whether a policy port matches the target
+// port of a Service port.
+func targetPortMatches(sp corev1.ServicePort, p netpol.Port) bool {
+	if p.Protocol != corev1.ProtocolTCP {
+		return false
+	}
+	if p.Port == nil {
+		return true
+	}
+
+	if p.Port.Type == intstr.String {
+		return sp.TargetPort.Type == intstr.String && sp.TargetPort.StrVal == p.Port.StrVal
+	}
+
+	target, ok := targetPortNumber(sp)
+	if !ok {
+		return false
+	}
+	return p.Matches(target, corev1.ProtocolTCP, nil)
+}
+
+// targetPortNumber returns the numeric target port of a Service port.
+func targetPortNumber(sp corev1.ServicePort) (int32, bool) {
+	switch {
+	case sp.TargetPort.Type == intstr.String:
+		return 0, false
+	case sp.TargetPort.IntVal != 0:
+		return sp.TargetPort.IntVal, true
+	default:
+		return sp.Port, true
+	}
+}
+
+// sampleAddr returns the first host address of the IP block that is not
+// excluded.
+func sampleAddr(b *netpol.IPBlock) (netip.Addr, bool) {
+	addr := b.CIDR.Addr()
+	if b.CIDR.Bits() < addr.BitLen()-1 {
+		addr = addr.Next() // skip the network address
+	}
+
+	// don't walk huge ranges if they are mostly excluded
+	for range 1024 {
+		if !addr.IsValid() || !b.CIDR.Contains(addr) {
+			return netip.Addr{}, false
+		}
+		if b.Contains(addr) {
+			return addr, true
+		}
+		addr = addr.Next()
+	}
+
+	return netip.Addr{}, false
+}
+
+func selectorMatches(sel *metav1.LabelSelector, set map[string]string) bool {
+	if sel == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
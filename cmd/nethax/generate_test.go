@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/netpol"
+)
+
+func TestGenerate(t *testing.T) {
+	var inv netpol.Inventory
+	if err := readManifestFile(&inv, "testdata/networkpolicies.yml"); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &generator{inv: &inv, timeout: 3 * time.Second}
+	plan := g.generate("generated", "")
+
+	if len(g.warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", g.warnings)
+	}
+
+	type test struct {
+		endpoint   string
+		expectFail bool
+	}
+	got := make(map[string][]test)
+	for _, target := range plan.TestTargets {
+		for _, tt := range target.Tests {
+			if tt.Type != TestTypeTCP {
+				t.Errorf("expecting TCP tests, got %s", tt.Type)
+			}
+			got[target.Namespace+" "+target.PodSelector.Labels] = append(got[target.Namespace+" "+target.PodSelector.Labels], test{tt.Endpoint, tt.ExpectFail})
+		}
+	}
+
+	exp := map[string][]test{
+		// egress from the cart pods
+		"shop app=cart": {
+			{"redis.shop.svc.cluster.local:6379", false},
+			{"10.0.1.0:5432", false},
+			{"cart.shop.svc.cluster.local:7070", true},
+		},
+		// ingress from the frontend pods
+		"shop app=frontend": {{"cart.shop.svc.cluster.local:7070", false}},
+		// ingress from the monitoring namespace
+		"monitoring ": {{"cart.shop.svc.cluster.local:7070", false}},
+		// denied ingress
+		"sandbox ": {{"cart.shop.svc.cluster.local:7070", true}},
+	}
+
+	if len(exp) != len(got) {
+		t.Fatalf("expecting %d targets, got %d: %v", len(exp), len(got), got)
+	}
+	for k, e := range exp {
+		if g := got[k]; !slices.Equal(e, g) {
+			t.Errorf("%s: expecting tests %v, got %v", k, e, g)
+		}
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeTestPlan(&buf, plan); err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseTestPlan(&buf)
+		if err != nil {
+			t.Fatalf("generated plan cannot be parsed: %v\n%s", err, buf.String())
+		}
+		if e, g := len(plan.TestTargets), len(parsed.TestTargets); e != g {
+			t.Fatalf("expecting %d targets, got %d", e, g)
+		}
+		for i := range plan.TestTargets {
+			if !reflect.DeepEqual(plan.TestTargets[i].Tests, parsed.TestTargets[i].Tests) {
+				t.Errorf("target %d: expecting tests %v, got %v", i, plan.TestTargets[i].Tests, parsed.TestTargets[i].Tests)
+			}
+		}
+	})
+
+	t.Run("missing services", func(t *testing.T) {
+		b, err := os.ReadFile("testdata/networkpolicies.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// keep only the namespaces and the policy
+		var docs []string
+		for _, d := range strings.Split(string(b), "---") {
+			if !strings.Contains(d, "kind: Service") {
+				docs = append(docs, d)
+			}
+		}
+
+		var inv netpol.Inventory
+		if err := inv.ReadManifests(strings.NewReader(strings.Join(docs, "---"))); err != nil {
+			t.Fatal(err)
+		}
+
+		g := &generator{inv: &inv, timeout: time.Second}
+		plan := g.generate("generated", "shop")
+
+		if len(g.warnings) != 2 {
+			t.Errorf("expecting warnings for the redis peer and the ingress tests, got %v", g.warnings)
+		}
+		// only the ipBlock test can be generated
+		if len(plan.TestTargets) != 1 || len(plan.TestTargets[0].Tests) != 1 {
+			t.Fatalf("expecting a single test, got %v", plan.TestTargets)
+		}
+	})
+}
+
+func TestSampleAddr(t *testing.T) {
+	tests := []struct {
+		cidr   string
+		except []string
+		exp    string
+	}{
+		{"10.0.0.0/8", nil, "10.0.0.1"},
+		{"10.0.0.0/8", []string{"10.0.0.0/24"}, "10.0.1.0"},
+		{"192.0.2.7/32", nil, "192.0.2.7"},
+		{"192.0.2.6/31", nil, "192.0.2.6"},
+		{"2001:db8::/64", nil, "2001:db8::1"},
+		{"10.0.0.0/24", []string{"10.0.0.0/24"}, ""},
+	}
+
+	for _, tt := range tests {
+		b := &netpol.IPBlock{CIDR: netip.MustParsePrefix(tt.cidr)}
+		for _, e := range tt.except {
+			b.Except = append(b.Except, netip.MustParsePrefix(e))
+		}
+
+		addr, ok := sampleAddr(b)
+		if tt.exp == "" {
+			if ok {
+				t.Errorf("%s except %v: expecting no address, got %s", tt.cidr, tt.except, addr)
+			}
+			continue
+		}
+		if !ok || addr.String() != tt.exp {
+			t.Errorf("%s except %v: expecting %s, got %s", tt.cidr, tt.except, tt.exp, addr)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -11,8 +15,20 @@ import (
 const (
 	exitCodeFailure     = 1
 	exitCodeConfigError = 2
+	exitCodeNethaxError = 3
+	exitCodeCancelled   = 4
 )
 
+// exitCode is returned by commands to exit with the given code, once
+// they have printed why and cleaned up.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+var errInterrupted = errors.New("interrupted")
+
 func main() {
 	root := &cobra.Command{
 		Use:   "nethax-runner --help",
@@ -20,8 +36,28 @@ func main() {
 	}
 
 	root.AddCommand(ExecuteTest())
+	root.AddCommand(Generate())
+	root.AddCommand(Predict())
+	root.AddCommand(Serve())
+	root.AddCommand(Controller())
+
+	// cancel the run on interrupt, so the changes made by setup steps
+	// are reverted and the results so far reported before exiting
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		cancel(fmt.Errorf("%w by %s", errInterrupted, sig))
+		// a second signal terminates the process right away
+		signal.Stop(signals)
+	}()
 
-	if err := root.Execute(); err != nil {
+	if err := root.ExecuteContext(ctx); err != nil {
+		var code exitCode
+		if errors.As(err, &code) {
+			os.Exit(int(code))
+		}
 		if !strings.Contains(err.Error(), "unknown command") {
 			fmt.Println(err)
 		}
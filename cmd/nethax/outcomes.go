@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Reasons of the events recorded on probed pods.
+const (
+	reasonTestPassed = "NethaxTestPassed"
+	reasonTestFailed = "NethaxTestFailed"
+)
+
+// Annotations holding the outcome of the last run on probed pods.
+const (
+	annotationLastRun     = "nethax.grafana.com/last-run"
+	annotationLastPlan    = "nethax.grafana.com/last-plan"
+	annotationLastResult  = "nethax.grafana.com/last-result"
+	annotationFailedTests = "nethax.grafana.com/failed-tests"
+)
+
+// outcomeOptions configures how test outcomes are recorded on the pods
+// the tests ran from.
+type outcomeOptions struct {
+	events      bool
+	annotations bool
+}
+
+// addOutcomeFlags adds the flags configuring opts to cmd.
+func addOutcomeFlags(cmd *cobra.Command, opts *outcomeOptions) {
+	cmd.Flags().BoolVar(&opts.events, "record-events", false, "Record a Kubernetes Event with the outcome of each test on the pod it ran from")
+	cmd.Flags().BoolVar(&opts.annotations, "annotate-pods", false, "Annotate the pods tests ran from with the outcome of the run")
+}
+
+// recordOutcomes records the results of report on the pods they ran
+// from, as configured by opts. Tests run from nodes are not recorded.
+// Failing to record an outcome only prints a warning, as pods may have
+// gone away since they were probed.
+func recordOutcomes(ctx context.Context, k *kubernetes.Kubernetes, report *Report, start time.Time, opts outcomeOptions) {
+	if !opts.events && !opts.annotations {
+		return
+	}
+
+	type podResults struct {
+		k      *kubernetes.Kubernetes
+		pod    *corev1.Pod
+		failed []string
+	}
+
+	var (
+		pods []*podResults
+		seen = make(map[string]*podResults)
+	)
+
+	for _, res := range report.Results {
+		if res.Pod == nil {
+			continue
+		}
+
+		// the pod may be in another cluster than the plan
+		pk, err := k.Cluster(res.Cluster)
+		if err != nil {
+			indent(ctx, 0, "Warning: %v", err)
+			continue
+		}
+
+		if opts.events {
+			eventType, reason := corev1.EventTypeNormal, reasonTestPassed
+			if !res.Passed() {
+				eventType, reason = corev1.EventTypeWarning, reasonTestFailed
+			}
+			if err := pk.RecordPodEvent(ctx, res.Pod, eventType, reason, outcomeMessage(report.Plan, res)); err != nil {
+				indent(ctx, 0, "Warning: %v", err)
+			}
+		}
+
+		key := res.Cluster + "/" + res.Source()
+		p, ok := seen[key]
+		if !ok {
+			p = &podResults{k: pk, pod: res.Pod}
+			seen[key] = p
+			pods = append(pods, p)
+		}
+		if !res.Passed() {
+			p.failed = append(p.failed, res.Test.Name)
+		}
+	}
+
+	if !opts.annotations {
+		return
+	}
+
+	lastRun := start.UTC().Format(time.RFC3339)
+	for _, p := range pods {
+		result := "passed"
+		var failed *string
+		if len(p.failed) > 0 {
+			result = "failed"
+			failed = ptr.To(strings.Join(p.failed, ","))
+		}
+
+		err := p.k.AnnotatePod(ctx, p.pod, map[string]*string{
+			annotationLastRun:     &lastRun,
+			annotationLastPlan:    &report.Plan,
+			annotationLastResult:  &result,
+			annotationFailedTests: failed, // removed if none failed
+		})
+		if err != nil {
+			indent(ctx, 0, "Warning: %v", err)
+		}
+	}
+}
+
+// outcomeMessage returns the message of the event recording res.
+func outcomeMessage(plan string, res TestResult) string {
+	msg := fmt.Sprintf("Test %q of plan %q (%s %s)", res.Test.Name, plan, res.Test.Type, res.Test.Endpoint)
+
+	switch {
+	case res.Err != nil:
+		return fmt.Sprintf("%s could not run: %v", msg, res.Err)
+	case res.ExitCode != 0:
+		return fmt.Sprintf("%s failed with exit code %d", msg, res.ExitCode)
+	case res.Test.ExpectFail:
+		return msg + " passed: connection failed as expected"
+	}
+	return msg + " passed"
+}
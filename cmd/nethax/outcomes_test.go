@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordOutcomes(t *testing.T) {
+	ctx := context.Background()
+
+	frontend := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "shop", Name: "frontend",
+		Annotations: map[string]string{annotationFailedTests: "cart", "owner": "shop-team"},
+	}}
+	checkout := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout"}}
+
+	result := func(pod *corev1.Pod, test string, exitCode int32, err error) TestResult {
+		return TestResult{Target: "shop", Pod: pod, Test: Test{Name: test, Type: TestTypeTCP, Endpoint: test + ":80"}, ExitCode: exitCode, Err: err}
+	}
+
+	report := &Report{
+		Plan: "shop",
+		Results: []TestResult{
+			result(frontend, "cart", 0, nil),
+			result(frontend, "ads", 0, nil),
+			result(checkout, "cart", 1, nil),
+			result(checkout, "payments", -1, errors.New("probe failed")),
+			{Target: "nodes", Node: "node-1", Test: Test{Name: "kubelet"}},
+		},
+	}
+
+	client := testClient.NewClientset(frontend, checkout)
+	k := kubernetes.NewWithClient(client)
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("disabled", func(t *testing.T) {
+		recordOutcomes(ctx, k, report, start, outcomeOptions{})
+
+		events, _ := client.CoreV1().Events("shop").List(ctx, metav1.ListOptions{})
+		if len(events.Items) != 0 {
+			t.Fatalf("expecting no events, got %d", len(events.Items))
+		}
+	})
+
+	recordOutcomes(ctx, k, report, start, outcomeOptions{events: true, annotations: true})
+
+	t.Run("events", func(t *testing.T) {
+		events, err := client.CoreV1().Events("shop").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, e := range events.Items {
+			got = append(got, e.InvolvedObject.Name+" "+e.Type+" "+e.Reason+" "+e.Message)
+		}
+		slices.Sort(got)
+
+		exp := []string{
+			`checkout Warning NethaxTestFailed Test "cart" of plan "shop" (tcp cart:80) failed with exit code 1`,
+			`checkout Warning NethaxTestFailed Test "payments" of plan "shop" (tcp payments:80) could not run: probe failed`,
+			`frontend Normal NethaxTestPassed Test "ads" of plan "shop" (tcp ads:80) passed`,
+			`frontend Normal NethaxTestPassed Test "cart" of plan "shop" (tcp cart:80) passed`,
+		}
+		if !slices.Equal(exp, got) {
+			t.Errorf("expecting events\n%q\ngot\n%q", exp, got)
+		}
+	})
+
+	t.Run("annotations", func(t *testing.T) {
+		exp := map[string]map[string]string{
+			"frontend": {
+				annotationLastRun:    "2025-06-01T12:00:00Z",
+				annotationLastPlan:   "shop",
+				annotationLastResult: "passed",
+				"owner":              "shop-team",
+			},
+			"checkout": {
+				annotationLastRun:     "2025-06-01T12:00:00Z",
+				annotationLastPlan:    "shop",
+				annotationLastResult:  "failed",
+				annotationFailedTests: "cart,payments",
+			},
+		}
+
+		for name, e := range exp {
+			pod, err := client.CoreV1().Pods("shop").Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if g := pod.Annotations; len(e) != len(g) {
+				t.Errorf("%s: expecting annotations %v, got %v", name, e, g)
+				continue
+			}
+			for key, v := range e {
+				if g := pod.Annotations[key]; v != g {
+					t.Errorf("%s: expecting annotation %s=%q, got %q", name, key, v, g)
+				}
+			}
+		}
+	})
+}
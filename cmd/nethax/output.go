@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type outputKey struct{}
+
+// withOutput returns a copy of ctx in which the output of runs is
+// written to w instead of the standard output, e.g. to buffer the
+// output of runs in parallel.
+func withOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// output returns where the output of the run of ctx is written.
+func output(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(outputKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
+
+// indent writes a line to the output of ctx, indented by level.
+func indent(ctx context.Context, level int, format string, a ...any) {
+	fmt.Fprint(output(ctx), strings.Repeat(" ", level)+fmt.Sprintf(format, a...)+"\n") //nolint:errcheck
+}
+
+// newline writes an empty line to the output of ctx.
+func newline(ctx context.Context) {
+	fmt.Fprintln(output(ctx)) //nolint:errcheck
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// planFetchTimeout is how long to wait for a test plan to be fetched
+// from a URL.
+const planFetchTimeout = 30 * time.Second
+
+// configMapScheme is the scheme of the locations of test plans in
+// ConfigMaps, configmap://<namespace>/<name>[/<key>].
+const configMapScheme = "configmap"
+
+var (
+	errIncludeCycle       = errors.New("include cycle")
+	errNoPlanFiles        = errors.New("no .yaml or .yml files in directory")
+	errNoPlanKeys         = errors.New("no .yaml or .yml keys in ConfigMap")
+	errNoPlanKey          = errors.New("no such key in ConfigMap")
+	errInvalidConfigMap   = errors.New("expecting ConfigMap as namespace/name, or namespace/name/key")
+	errRemoteStepFiles    = errors.New("steps of plans not read from files can only use absolute file paths")
+	errUnexpectedHTTPCode = errors.New("unexpected HTTP status")
+	errNoClient           = errors.New("no Kubernetes client")
+)
+
+// planReader reads test plans, and the plans they include, from files,
+// directories, the standard input ("-"), HTTP URLs, and ConfigMaps.
+type planReader struct {
+	// vars override the variables of all the plans.
+	vars map[string]string
+	// kubernetes returns the client to read ConfigMaps with, only
+	// called if a plan is in a ConfigMap.
+	kubernetes func() (*kubernetes.Kubernetes, error)
+
+	stdin  io.Reader    // defaults to os.Stdin
+	client *http.Client // defaults to a client with planFetchTimeout
+	k      *kubernetes.Kubernetes
+}
+
+// ReadTestPlan reads and parses the test plan file at path, and the
+// plan files it includes. If path is a directory, the plan files in it
+// are read and merged.
+func ReadTestPlan(path string) (*TestPlan, error) {
+	return new(planReader).read(context.Background(), []string{path})
+}
+
+// configMapLocation returns the location of the plans of the given
+// ConfigMap, as namespace/name for all the plans in it, or
+// namespace/name/key for one.
+func configMapLocation(cm string) (string, error) {
+	if n := strings.Count(cm, "/"); n < 1 || n > 2 || slices.Contains(strings.Split(cm, "/"), "") {
+		return "", fmt.Errorf("%w: %q", errInvalidConfigMap, cm)
+	}
+	return configMapScheme + "://" + cm, nil
+}
+
+// planLocations returns the locations of the plans given with --file
+// and --configmap.
+func planLocations(files, configMaps []string) ([]string, error) {
+	locations := slices.Clone(files)
+	for _, cm := range configMaps {
+		loc, err := configMapLocation(cm)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, loc)
+	}
+	return locations, nil
+}
+
+// read reads the test plans at the given locations, and merges them
+// into a single plan named after all of them.
+func (r *planReader) read(ctx context.Context, locations []string) (*TestPlan, error) {
+	var plans []*TestPlan
+	for _, loc := range locations {
+		files, err := r.list(ctx, loc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			plan, err := r.readPlan(ctx, f, nil)
+			if err != nil {
+				if len(locations) > 1 || len(files) > 1 {
+					err = fmt.Errorf("%s: %w", f, err)
+				}
+				return nil, err
+			}
+			plans = append(plans, plan)
+		}
+	}
+
+	if len(plans) == 1 {
+		return plans[0], nil
+	}
+
+	var names, descriptions []string
+	for _, p := range plans {
+		names = append(names, p.Name)
+		if p.Description != "" {
+			descriptions = append(descriptions, p.Description)
+		}
+	}
+
+	plan := mergePlans(plans[0], plans[1:])
+	plan.Name = strings.Join(names, ", ")
+	plan.Description = strings.Join(descriptions, " ")
+	// the plans may run in the same clusters
+	for _, p := range plans[1:] {
+		for _, c := range p.Clusters {
+			if !slices.Contains(plan.Clusters, c) {
+				plan.Clusters = append(plan.Clusters, c)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// readPlan reads the test plan at loc, and the plans it includes.
+// includedBy holds the locations of the plans including it, to detect
+// cycles.
+func (r *planReader) readPlan(ctx context.Context, loc string, includedBy []string) (*TestPlan, error) {
+	id := loc
+	if !isRemote(loc) && loc != "-" {
+		abs, err := filepath.Abs(loc)
+		if err != nil {
+			return nil, err
+		}
+		id = abs
+	}
+	if slices.Contains(includedBy, id) {
+		return nil, fmt.Errorf("%w: %s", errIncludeCycle, strings.Join(append(includedBy, id), " -> "))
+	}
+
+	b, err := r.open(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := parseTestPlan(bytes.NewReader(b), r.vars)
+	if err != nil {
+		return nil, fmt.Errorf("parsing test plan: %w", err)
+	}
+
+	switch {
+	case isRemote(loc):
+		// there is no directory for paths to be relative to
+		for _, s := range slices.Concat(plan.Setup, plan.Teardown) {
+			if slices.ContainsFunc(s.Files, func(f string) bool { return !filepath.IsAbs(f) }) {
+				return nil, fmt.Errorf("step %q: %w", s.Name, errRemoteStepFiles)
+			}
+		}
+	case loc != "-":
+		plan.Dir = filepath.Dir(loc)
+	}
+
+	var included []*TestPlan
+	for _, inc := range plan.Include {
+		inc, err := resolveLocation(loc, inc)
+		if err != nil {
+			return nil, fmt.Errorf("including %s: %w", inc, err)
+		}
+
+		files, err := r.list(ctx, inc)
+		if err != nil {
+			return nil, fmt.Errorf("including %s: %w", inc, err)
+		}
+
+		for _, f := range files {
+			p, err := r.readPlan(ctx, f, append(includedBy, id))
+			if err != nil {
+				return nil, fmt.Errorf("including %s: %w", f, err)
+			}
+			included = append(included, p)
+		}
+	}
+
+	return mergePlans(plan, included), nil
+}
+
+// isRemote returns whether loc is the location of a plan that is not
+// read from a file or the standard input.
+func isRemote(loc string) bool {
+	u, err := url.Parse(loc)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == configMapScheme)
+}
+
+// resolveLocation returns the location of ref, included by the plan at
+// base: relative to the directory of base if it is a file, to the URL
+// of base if it is remote, or to the working directory if base is the
+// standard input.
+func resolveLocation(base, ref string) (string, error) {
+	switch {
+	case isRemote(ref):
+		return ref, nil
+	case isRemote(base):
+		b, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		r, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return b.ResolveReference(r).String(), nil
+	case base == "-" || filepath.IsAbs(ref):
+		return ref, nil
+	}
+
+	return filepath.Join(filepath.Dir(base), ref), nil
+}
+
+// list returns the locations of the plans at loc: loc itself, or the
+// .yaml and .yml files of the directory, or keys of the ConfigMap, at
+// loc.
+func (r *planReader) list(ctx context.Context, loc string) ([]string, error) {
+	if loc == "-" {
+		return []string{loc}, nil
+	}
+
+	if isRemote(loc) {
+		ns, name, key, ok := configMapKey(loc)
+		if !ok || key != "" {
+			return []string{loc}, nil
+		}
+
+		cm, err := r.configMap(ctx, ns, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var locs []string
+		for key := range cm.Data {
+			if isPlanFile(key) {
+				locs = append(locs, loc+"/"+key)
+			}
+		}
+		if len(locs) == 0 {
+			return nil, fmt.Errorf("%w %s/%s", errNoPlanKeys, ns, name)
+		}
+		slices.Sort(locs)
+
+		return locs, nil
+	}
+
+	info, err := os.Stat(loc)
+	if err != nil {
+		return nil, fmt.Errorf("opening test file: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{loc}, nil
+	}
+
+	entries, err := os.ReadDir(loc)
+	if err != nil {
+		return nil, fmt.Errorf("reading test plan directory: %w", err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && isPlanFile(e.Name()) {
+			files = append(files, filepath.Join(loc, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w %s", errNoPlanFiles, loc)
+	}
+
+	return files, nil
+}
+
+func isPlanFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// open returns the content of the plan at loc.
+func (r *planReader) open(ctx context.Context, loc string) ([]byte, error) {
+	if loc == "-" {
+		stdin := r.stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		b, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading test plan from standard input: %w", err)
+		}
+		return b, nil
+	}
+
+	if ns, name, key, ok := configMapKey(loc); ok {
+		cm, err := r.configMap(ctx, ns, name)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := cm.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("%w %s/%s: %q", errNoPlanKey, ns, name, key)
+		}
+		return []byte(data), nil
+	}
+
+	if isRemote(loc) {
+		return r.fetch(ctx, loc)
+	}
+
+	b, err := os.ReadFile(loc)
+	if err != nil {
+		return nil, fmt.Errorf("opening test file: %w", err)
+	}
+	return b, nil
+}
+
+// fetch returns the content of the plan at URL u.
+func (r *planReader) fetch(ctx context.Context, u string) ([]byte, error) {
+	client := r.client
+	if client == nil {
+		client = &http.Client{Timeout: planFetchTimeout}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching test plan: %w", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching test plan: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching test plan %s: %w %s", u, errUnexpectedHTTPCode, res.Status)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching test plan: %w", err)
+	}
+	return b, nil
+}
+
+// configMapKey returns the namespace, name and key, if any, of the
+// ConfigMap at loc, and whether loc is the location of a ConfigMap.
+func configMapKey(loc string) (namespace, name, key string, ok bool) {
+	u, err := url.Parse(loc)
+	if err != nil || u.Scheme != configMapScheme {
+		return "", "", "", false
+	}
+
+	name, key, _ = strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	return u.Host, name, key, true
+}
+
+// configMap returns the given ConfigMap, creating the client to read it
+// on first use.
+func (r *planReader) configMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	if r.k == nil {
+		if r.kubernetes == nil {
+			return nil, fmt.Errorf("reading ConfigMap %s/%s: %w", namespace, name, errNoClient)
+		}
+
+		k, err := r.kubernetes()
+		if err != nil {
+			return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+		}
+		r.k = k
+	}
+
+	return r.k.GetConfigMap(ctx, namespace, name)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPlanReader(t *testing.T) {
+	plan := func(name string, targets ...string) string {
+		var b strings.Builder
+		b.WriteString("testPlan:\n  name: " + name + "\n  testTargets:\n")
+		for _, target := range targets {
+			b.WriteString("  - name: " + target + "\n    tests: []\n")
+		}
+		return b.String()
+	}
+
+	targets := func(plan *TestPlan) []string {
+		var res []string
+		for _, t := range plan.TestTargets {
+			res = append(res, strings.TrimSuffix(t.Name+"."+t.Namespace, "."))
+		}
+		return res
+	}
+
+	plans := map[string]string{
+		"/plans/main.yaml":   plan("main", "frontend") + "  include: [common.yaml]\n",
+		"/plans/common.yaml": plan("common", "coredns") + "  include: [/shared/dns.yaml]\n",
+		"/shared/dns.yaml":   plan("dns", "coredns\n    namespace: ${dns}") + "  vars:\n    dns: kube-dns\n",
+		"/clusters/eu.yaml":  plan("eu", "frontend") + "  clusters: [prod-eu, staging]\n",
+		"/clusters/us.yaml":  plan("us", "frontend") + "  clusters: [staging, prod-us]\n",
+		"/plans/steps.yaml": plan("steps", "frontend") + `
+  setup:
+  - name: policies
+    files: [policies.yaml]
+`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := plans[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(p)) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "nethax", Name: "plans"},
+		Data: map[string]string{
+			"shop.yaml":    plan("shop", "cart") + "  include: [common.yml]\n",
+			"common.yml":   plan("common", "coredns"),
+			"README.md":    "not a plan",
+			"billing.yaml": plan("billing", "billing"),
+		},
+	}
+	k := kubernetes.NewWithClient(testClient.NewClientset(cm))
+
+	newReader := func() *planReader {
+		return &planReader{
+			vars:       map[string]string{"dns": "kube-system"},
+			kubernetes: func() (*kubernetes.Kubernetes, error) { return k, nil },
+			stdin:      strings.NewReader(plan("stdin", "ads") + "  include: [" + srv.URL + "/shared/dns.yaml]\n"),
+		}
+	}
+
+	for n, tt := range map[string]struct {
+		locations []string
+		name      string
+		targets   []string
+	}{
+		"stdin": {
+			[]string{"-"},
+			"stdin",
+			[]string{"ads", "coredns.kube-system"},
+		},
+		"url": {
+			[]string{srv.URL + "/plans/main.yaml"},
+			"main",
+			[]string{"frontend", "coredns", "coredns.kube-system"},
+		},
+		"configmap": {
+			[]string{"configmap://nethax/plans"},
+			"billing, common, shop",
+			[]string{"billing", "coredns", "cart", "coredns"},
+		},
+		"configmap key": {
+			[]string{"configmap://nethax/plans/shop.yaml"},
+			"shop",
+			[]string{"cart", "coredns"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			tp, err := newReader().read(t.Context(), tt.locations)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tp.Name != tt.name {
+				t.Errorf("expecting plan %s, got %s", tt.name, tp.Name)
+			}
+			if g := targets(tp); !slices.Equal(tt.targets, g) {
+				t.Errorf("expecting targets %q, got %q", tt.targets, g)
+			}
+		})
+	}
+
+	t.Run("clusters", func(t *testing.T) {
+		tp, err := newReader().read(t.Context(), []string{srv.URL + "/clusters/eu.yaml", srv.URL + "/clusters/us.yaml"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e := []string{"prod-eu", "staging", "prod-us"}; !slices.Equal(e, tp.Clusters) {
+			t.Errorf("expecting clusters %q, got %q", e, tp.Clusters)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for loc, exp := range map[string]error{
+			srv.URL + "/plans/nope.yaml":     errUnexpectedHTTPCode,
+			srv.URL + "/plans/steps.yaml":    errRemoteStepFiles,
+			"configmap://nethax/plans/nope":  errNoPlanKey,
+			"configmap://nethax/other/a.yml": errNoClient,
+		} {
+			r := newReader()
+			if strings.HasSuffix(loc, "a.yml") {
+				r.kubernetes = nil
+			}
+			if _, err := r.read(t.Context(), []string{loc}); !errors.Is(err, exp) {
+				t.Errorf("%s: expecting error %v, got %v", loc, exp, err)
+			}
+		}
+	})
+
+	t.Run("configmap location", func(t *testing.T) {
+		for cm, exp := range map[string]string{
+			"nethax/plans":           "configmap://nethax/plans",
+			"nethax/plans/shop.yaml": "configmap://nethax/plans/shop.yaml",
+			"plans":                  "",
+			"nethax//shop.yaml":      "",
+			"a/b/c/d":                "",
+		} {
+			loc, err := configMapLocation(cm)
+			if exp == "" {
+				if !errors.Is(err, errInvalidConfigMap) {
+					t.Errorf("%s: expecting error %v, got %v", cm, errInvalidConfigMap, err)
+				}
+				continue
+			}
+			if err != nil || loc != exp {
+				t.Errorf("%s: expecting %s, got %s (%v)", cm, exp, loc, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/netpol"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Predict returns the predict command
+func Predict() *cobra.Command {
+	var testFile, kontext string
+	var set []string
+
+	cmd := &cobra.Command{
+		Use:   "predict -f example/OtelDemoTestPlan.yaml",
+		Short: "Predict test outcomes from the network policies in the cluster",
+		Long: `Predict the outcome of each test in a plan by evaluating the NetworkPolicy
+objects in the cluster, without running any probe. Tests whose expectation
+disagrees with the policies are flagged, and make the command fail.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			vars, err := parseVarFlags(set)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				os.Exit(exitCodeConfigError)
+			}
+
+			reader := &planReader{
+				vars: vars,
+				kubernetes: func() (*kubernetes.Kubernetes, error) {
+					return kubernetes.New(kontext)
+				},
+			}
+
+			plan, err := reader.read(cmd.Context(), []string{testFile})
+			if err != nil {
+				cmd.Printf("Error reading test plan: %v\n", err)
+				os.Exit(exitCodeConfigError)
+			}
+
+			k, err := kubernetes.New(kontext)
+			if err != nil {
+				cmd.Printf("Error creating Kubernetes client: %v\n", err)
+				os.Exit(exitCodeConfigError)
+			}
+
+			inv := new(netpol.Inventory)
+			if err := loadClusterInventory(cmd.Context(), k, inv); err != nil {
+				cmd.Printf("Error loading network policies: %v\n", err)
+				os.Exit(exitCodeConfigError)
+			}
+			for _, w := range inv.Warnings {
+				cmd.PrintErrf("Warning: %s\n", w)
+			}
+
+			if !printPredictions(cmd.Context(), predictTestPlan(cmd.Context(), k, netpol.NewEvaluator(inv), plan)) {
+				os.Exit(exitCodeFailure)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&testFile, "file", "f", "", "Path to the test configuration YAML file, a directory of them, an HTTP(S) URL, or - for the standard input")
+	cmd.MarkFlagRequired("file") //nolint:errcheck
+
+	cmd.Flags().StringVarP(&kontext, "context", "c", "", "Kubernetes context to connect. Leave empty for in-cluster context.")
+
+	addVarsFlag(cmd, &set)
+
+	return cmd
+}
+
+// prediction is the predicted outcome of a test run from a pod.
+type prediction struct {
+	Target string
+	Pod    string // namespace/name, blank if the target failed
+	Test   Test
+	// Err is set when the prediction could not be made, e.g. the
+	// target selected no pods.
+	Err     error
+	Verdict netpol.Verdict
+}
+
+// Mismatch returns whether the test expectation disagrees with the
+// predicted outcome.
+func (p prediction) Mismatch() bool {
+	switch p.Verdict.Outcome {
+	case netpol.OutcomeAllowed:
+		return expectsFailure(p.Test)
+	case netpol.OutcomeDenied:
+		if p.Verdict.HTTPDenied {
+			// the connection succeeds, but the request is denied
+			return p.Test.Type != TestTypeHTTP || p.Test.StatusCode != http.StatusForbidden
+		}
+		return !expectsFailure(p.Test)
+	default:
+		return false
+	}
+}
+
+// expectsFailure returns whether the test expects the connection to
+// fail.
+func expectsFailure(test Test) bool {
+	if test.Type == TestTypeHTTP {
+		return test.StatusCode == 0
+	}
+	return test.ExpectFail
+}
+
+// predictTestPlan predicts the outcome of every test of the plan for
+// every pod the targets could select. Node targets are skipped, as
+// policies don't apply to the host network.
+func predictTestPlan(ctx context.Context, k *kubernetes.Kubernetes, ev *netpol.Evaluator, plan *TestPlan) []prediction {
+	var preds []prediction
+
+	for _, target := range plan.TestTargets {
+		if target.NodeSelector != nil {
+			continue
+		}
+
+		// the policies are only loaded from the cluster of the plan
+		if target.Cluster != "" {
+			preds = append(preds, prediction{Target: target.Name, Err: errOtherClusterTarget})
+			continue
+		}
+
+		namespaces := []string{target.Namespace}
+		if target.PerNamespace() {
+			var err error
+			namespaces, err = k.GetNamespaces(ctx, target.NamespaceSelector)
+			if err != nil {
+				preds = append(preds, prediction{Target: target.Name, Err: err})
+				continue
+			}
+		}
+
+		for _, ns := range namespaces {
+			// random targets could run from any ready pod, so we
+			// predict for all of them
+			selector := target.PodSelector
+			selector.Mode = SelectionModeAll
+
+			pods, err := findPods(ctx, k, ns, selector)
+			if err != nil {
+				preds = append(preds, prediction{Target: target.Name, Err: err})
+				continue
+			}
+
+			for _, pod := range pods {
+				for _, test := range target.Tests {
+					p := prediction{
+						Target: target.Name,
+						Pod:    pod.Namespace + "/" + pod.Name,
+						Test:   test,
+					}
+					if usesDestination(test) {
+						p.Err = errDestinationTest
+					} else {
+						p.Verdict, p.Err = predictTest(ev, &pod, test)
+					}
+					preds = append(preds, p)
+				}
+			}
+		}
+	}
+
+	return preds
+}
+
+var (
+	errUnpredictableTest  = errors.New("test type cannot be predicted")
+	errDestinationTest    = errors.New("destination is only deployed when running the tests")
+	errOtherClusterTarget = errors.New("target runs in another cluster")
+)
+
+// predictTest predicts the outcome of running test from pod. The
+// request of HTTP tests is evaluated against the HTTP rules of the
+// policies.
+func predictTest(ev *netpol.Evaluator, pod *corev1.Pod, test Test) (netpol.Verdict, error) {
+	host, port, err := testHostPort(test)
+	if err != nil {
+		return netpol.Verdict{}, err
+	}
+
+	var req *netpol.HTTPRequest
+	if test.Type == TestTypeHTTP {
+		u, err := url.Parse(test.Endpoint)
+		if err != nil {
+			return netpol.Verdict{}, fmt.Errorf("invalid endpoint URL: %w", err)
+		}
+		req = &netpol.HTTPRequest{Method: http.MethodGet, Host: u.Hostname(), Path: cmp.Or(u.EscapedPath(), "/")}
+	}
+
+	return ev.EvaluateHTTP(pod, host, port, corev1.ProtocolTCP, req), nil
+}
+
+// testHostPort returns the host and port the test connects to.
+func testHostPort(test Test) (string, int32, error) {
+	var host, port string
+
+	switch test.Type {
+	case TestTypeTCP:
+		var err error
+		if host, port, err = net.SplitHostPort(test.Endpoint); err != nil {
+			return "", 0, fmt.Errorf("invalid endpoint: %w", err)
+		}
+
+	case TestTypeHTTP:
+		u, err := url.Parse(test.Endpoint)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid endpoint URL: %w", err)
+		}
+		host, port = u.Hostname(), u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+
+	default:
+		return "", 0, fmt.Errorf("%w: %s", errUnpredictableTest, test.Type)
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %w", port, err)
+	}
+
+	return host, int32(n), nil
+}
+
+// printPredictions prints the predictions grouped by target and pod,
+// returning whether none of them mismatch the test expectations.
+func printPredictions(ctx context.Context, preds []prediction) bool {
+	var lastTarget, lastPod string
+	var tests, mismatches, unknown int
+
+	for _, p := range preds {
+		if p.Target != lastTarget {
+			if lastTarget != "" {
+				newline(ctx)
+			}
+			indent(ctx, 1, "Target: %s", p.Target)
+			lastTarget, lastPod = p.Target, ""
+		}
+
+		if p.Pod == "" {
+			indent(ctx, 1, "Error: %v", p.Err)
+			continue
+		}
+		if p.Pod != lastPod {
+			indent(ctx, 1, "Pod: %s", p.Pod)
+			lastPod = p.Pod
+		}
+
+		tests++
+		indent(ctx, 2, "Test: %s", p.Test.Name)
+		indent(ctx, 3, "Endpoint: %s", p.Test.Endpoint)
+		indent(ctx, 3, "Expect Fail: %v", expectsFailure(p.Test))
+
+		switch {
+		case p.Err != nil:
+			unknown++
+			indent(ctx, 3, "Predicted: unknown (%v)", p.Err)
+		case p.Mismatch():
+			mismatches++
+			indent(ctx, 3, "Predicted: %s (%s)", p.Verdict.Outcome, p.Verdict.Reason)
+			indent(ctx, 3, "Result: MISMATCH")
+		default:
+			if p.Verdict.Outcome == netpol.OutcomeUnknown {
+				unknown++
+			}
+			indent(ctx, 3, "Predicted: %s (%s)", p.Verdict.Outcome, p.Verdict.Reason)
+		}
+	}
+
+	newline(ctx)
+	indent(ctx, 0, "Predicted %d test(s): %d mismatch(es), %d unknown", tests, mismatches, unknown)
+
+	return mismatches == 0
+}
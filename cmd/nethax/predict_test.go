@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/netpol"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPredictTestPlan(t *testing.T) {
+	readyPod := func(name, ip string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Labels: labels},
+			Status: corev1.PodStatus{
+				PodIP:      ip,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	objs := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		readyPod("frontend-0", "10.0.0.1", map[string]string{"app": "frontend"}),
+		readyPod("frontend-1", "10.0.0.2", map[string]string{"app": "frontend"}),
+		readyPod("cart", "10.0.0.3", map[string]string{"app": "cart"}),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "cart"},
+				Ports:    []corev1.ServicePort{{Port: 7070}},
+			},
+		},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "frontend"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			},
+		},
+	}
+
+	k := kubernetes.NewWithClient(testClient.NewClientset(objs...))
+
+	inv := new(netpol.Inventory)
+	if err := loadClusterInventory(t.Context(), k, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: predict
+  testTargets:
+  - name: frontend
+    namespace: shop
+    podSelector:
+      mode: random
+      labels: app=frontend
+    tests:
+    - name: cart is blocked
+      endpoint: cart.shop.svc.cluster.local:7070
+      type: tcp
+      expectFail: true
+      timeout: 1s
+    - name: cart is reachable
+      endpoint: http://cart.shop:7070/
+      statusCode: 200
+      timeout: 1s
+    - name: dns
+      endpoint: grafana.com
+      type: dns
+      timeout: 1s
+  - name: cart
+    namespace: shop
+    podSelector:
+      mode: all
+      labels: app=cart
+    tests:
+    - name: frontend is blocked
+      endpoint: "10.0.0.1:8080"
+      type: tcp
+      expectFail: true
+      timeout: 1s
+  - name: nodes
+    nodeSelector:
+      mode: all
+    tests: []
+  - name: missing
+    namespace: shop
+    podSelector:
+      mode: all
+      labels: app=missing
+    tests: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preds := predictTestPlan(t.Context(), k, netpol.NewEvaluator(inv), plan)
+
+	// 2 frontend pods * 3 tests + 1 cart test + 1 missing target
+	if e, g := 8, len(preds); e != g {
+		t.Fatalf("expecting %d predictions, got %d: %+v", e, g, preds)
+	}
+
+	type exp struct {
+		outcome  netpol.Outcome
+		mismatch bool
+		err      error
+	}
+	tests := map[string]exp{
+		"cart is blocked":     {netpol.OutcomeDenied, false, nil},
+		"cart is reachable":   {netpol.OutcomeDenied, true, nil},
+		"dns":                 {netpol.OutcomeUnknown, false, errUnpredictableTest},
+		"frontend is blocked": {netpol.OutcomeAllowed, true, nil},
+	}
+
+	for _, p := range preds {
+		if p.Target == "missing" {
+			if p.Pod != "" || p.Err == nil {
+				t.Errorf("expecting target error, got %+v", p)
+			}
+			continue
+		}
+
+		e, ok := tests[p.Test.Name]
+		if !ok {
+			t.Fatalf("unexpected prediction %+v", p)
+		}
+		if !errors.Is(p.Err, e.err) {
+			t.Errorf("%s: expecting error %v, got %v", p.Test.Name, e.err, p.Err)
+		}
+		if e.outcome != p.Verdict.Outcome {
+			t.Errorf("%s from %s: expecting outcome %s, got %s (%s)", p.Test.Name, p.Pod, e.outcome, p.Verdict.Outcome, p.Verdict.Reason)
+		}
+		if e.mismatch != p.Mismatch() {
+			t.Errorf("%s: expecting mismatch %v, got %v", p.Test.Name, e.mismatch, p.Mismatch())
+		}
+	}
+
+	if printPredictions(t.Context(), preds) {
+		t.Error("expecting mismatches to be reported")
+	}
+}
+
+func TestTestHostPort(t *testing.T) {
+	tests := []struct {
+		test Test
+		host string
+		port int32
+		fail bool
+	}{
+		{Test{Type: TestTypeHTTP, Endpoint: "http://grafana.com"}, "grafana.com", 80, false},
+		{Test{Type: TestTypeHTTP, Endpoint: "https://grafana.com/foo"}, "grafana.com", 443, false},
+		{Test{Type: TestTypeHTTP, Endpoint: "http://10.0.0.1:8080/metrics"}, "10.0.0.1", 8080, false},
+		{Test{Type: TestTypeTCP, Endpoint: "cart.shop:7070"}, "cart.shop", 7070, false},
+		{Test{Type: TestTypeTCP, Endpoint: "[2001:db8::1]:443"}, "2001:db8::1", 443, false},
+		{Test{Type: TestTypeTCP, Endpoint: "cart.shop"}, "", 0, true},
+		{Test{Type: TestTypeTCP, Endpoint: "cart.shop:99999"}, "", 0, true},
+		{Test{Type: TestTypeDNS, Endpoint: "grafana.com"}, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		host, port, err := testHostPort(tt.test)
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: expecting failure %v, got %v", tt.test.Endpoint, tt.fail, err)
+			continue
+		}
+		if tt.host != host || tt.port != port {
+			t.Errorf("%s: expecting %s %d, got %s %d", tt.test.Endpoint, tt.host, tt.port, host, port)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestResult is the outcome of running a test from a pod or a node.
+type TestResult struct {
+	Target string
+	Pod    *corev1.Pod // nil for node targets
+	Node   string      // only set for node targets
+	// Cluster is the kubeconfig context of the cluster of Pod or Node,
+	// blank for the cluster the plan ran in.
+	Cluster string
+	Test    Test
+
+	ExitCode int32
+	// Duration is how long it took to run the probe, including
+	// launching its container.
+	Duration time.Duration
+	// Err is set when the probe could not be run, or its result
+	// could not be retrieved, and ErrKind classifies it.
+	Err     error
+	ErrKind ErrorKind
+	// Attempts is the outcome of the attempts of the probe, nil
+	// unless it made more than one.
+	Attempts *pf.Attempts
+	// Runs is the number of times the test ran with --until-pass, and
+	// ConvergedAfter how long it took to pass since it first ran.
+	Runs           int
+	ConvergedAfter time.Duration
+}
+
+// Passed returns whether the test passed.
+func (r TestResult) Passed() bool {
+	return r.Err == nil && r.ExitCode == 0
+}
+
+// Flaky returns whether some attempts of the probe passed and others
+// failed, whatever the outcome of the test.
+func (r TestResult) Flaky() bool {
+	return r.Err == nil && r.Attempts != nil && r.Attempts.Flaky()
+}
+
+// Source returns where the test ran from.
+func (r TestResult) Source() string {
+	if r.Pod != nil {
+		return r.Pod.Namespace + "/" + r.Pod.Name
+	}
+	return "node/" + r.Node
+}
+
+// TargetError is an error that prevented the tests of a target from
+// running, e.g. no pods could be selected.
+type TargetError struct {
+	Target    string
+	Namespace string
+	Err       error
+	Kind      ErrorKind
+}
+
+// StepError is an error of a setup or teardown step. A failed setup
+// prevents the tests from running, and a failed teardown may leave
+// changes in the cluster.
+type StepError struct {
+	Stage string // "setup" or "teardown"
+	Step  string
+	Err   error
+	Kind  ErrorKind
+}
+
+// Report holds the results of running a test plan.
+type Report struct {
+	Plan string
+	// Cluster is the kubeconfig context of the cluster the plan ran in,
+	// blank for the in-cluster or current context.
+	Cluster    string
+	Results    []TestResult
+	Errors     []TargetError
+	StepErrors []StepError
+	// Cancelled is why the run was cancelled before all its tests ran,
+	// e.g. an interrupt or --max-duration, nil if it wasn't.
+	Cancelled error
+}
+
+// Passed returns whether all the tests passed, and all the targets and
+// steps could run.
+func (r *Report) Passed() bool {
+	if len(r.Errors) > 0 || len(r.StepErrors) > 0 || r.Cancelled != nil {
+		return false
+	}
+	for _, res := range r.Results {
+		if !res.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrorKinds returns the number of errors of each kind in the run: of
+// the tests that could not run, and of the targets and steps.
+func (r *Report) ErrorKinds() map[ErrorKind]int {
+	kinds := make(map[ErrorKind]int)
+	for _, res := range r.Results {
+		if res.Err != nil {
+			kinds[res.ErrKind]++
+		}
+	}
+	for _, e := range r.Errors {
+		kinds[e.Kind]++
+	}
+	for _, e := range r.StepErrors {
+		kinds[e.Kind]++
+	}
+	return kinds
+}
+
+// Errored returns whether any test, target or step of the run could
+// not run, as opposed to tests failing.
+func (r *Report) Errored() bool {
+	return len(r.ErrorKinds()) > 0
+}
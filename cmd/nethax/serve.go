@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+)
+
+// Serve returns the serve command
+func Serve() *cobra.Command {
+	var (
+		testFiles, configMaps, set []string
+		defaultProbeImage, kontext string
+		listenAddr, otlpEndpoint   string
+		interval                   time.Duration
+		outcomes                   outcomeOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve -f example/OtelDemoTestPlan.yaml",
+		Short: "Run test plans on an interval and expose Prometheus metrics",
+		Long: `Run one or more test plans on an interval, until terminated, exposing
+their results as Prometheus metrics on /metrics. Plans are read again on
+every run, so they can be updated without restarting.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are printed as they happen, with their own exit
+			// codes
+			cmd.SilenceErrors, cmd.SilenceUsage = true, true
+
+			if interval <= 0 {
+				cmd.Println("Error: interval must be positive")
+				return exitCode(exitCodeConfigError)
+			}
+			if slices.Contains(testFiles, "-") {
+				cmd.Printf("Error: %v\n", errServeStdin)
+				return exitCode(exitCodeConfigError)
+			}
+
+			locations, err := planLocations(testFiles, configMaps)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			vars, err := parseVarFlags(set)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			k, err := kubernetes.New(kontext)
+			if err != nil {
+				cmd.Printf("Error creating Kubernetes client: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+
+			reader := &planReader{
+				vars:       vars,
+				kubernetes: func() (*kubernetes.Kubernetes, error) { return k, nil },
+			}
+
+			kubernetes.DefaultProbeImage = defaultProbeImage
+
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(
+				collectors.NewGoCollector(),
+				collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+			)
+			m := newMetrics(reg)
+
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+			mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			srv := &http.Server{Addr: listenAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+			ctx := cmd.Context()
+
+			shutdownTracing, err := setupTracing(cmd, otlpEndpoint)
+			if err != nil {
+				cmd.Printf("Error: %v\n", err)
+				return exitCode(exitCodeConfigError)
+			}
+			defer shutdownTracing()
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- srv.ListenAndServe()
+			}()
+
+			go func() {
+				servePlans(ctx, k, reader, locations, interval, outcomes, m)
+				srv.Close() //nolint:errcheck
+			}()
+
+			if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+				cmd.Printf("Error serving metrics: %v\n", err)
+				return exitCode(exitCodeNethaxError)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&testFiles, "file", "f", nil, "Path to a test configuration YAML file, a directory of them merged into one plan, or an HTTP(S) URL. Can be repeated.")
+	cmd.Flags().StringArrayVar(&configMaps, "configmap", nil, "ConfigMap to read a test plan from, as namespace/name for all its .yaml and .yml keys merged into one plan, or namespace/name/key. Can be repeated, and combined with --file.")
+	cmd.MarkFlagsOneRequired("file", "configmap")
+
+	cmd.Flags().StringVarP(&kontext, "context", "c", "", "Kubernetes context to connect. Leave empty for in-cluster context.")
+
+	cmd.Flags().StringVar(&defaultProbeImage,
+		"default-probe-image",
+		kubernetes.DefaultProbeImage,
+		"Default probe image to use if test plan doesn't specify one.",
+	)
+
+	cmd.Flags().StringVar(&listenAddr, "listen", ":9090", "Address to serve metrics on")
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Minute, "Time between the start of two runs of the test plans")
+
+	addVarsFlag(cmd, &set)
+	addOutcomeFlags(cmd, &outcomes)
+	addTracingFlags(cmd, &otlpEndpoint)
+
+	return cmd
+}
+
+// errServeStdin is returned when serve is asked to read a plan from the
+// standard input, which can't be read again on every run.
+var errServeStdin = errors.New("serve cannot read plans from the standard input")
+
+// servePlans runs the plans at the given locations, read with reader,
+// one after the other, every interval until ctx is done.
+func servePlans(ctx context.Context, k *kubernetes.Kubernetes, reader *planReader, locations []string, interval time.Duration, outcomes outcomeOptions, m *metrics) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, loc := range locations {
+			if ctx.Err() != nil {
+				return
+			}
+			servePlan(ctx, k, reader, loc, outcomes, m)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// servePlan runs the plan at the given location once, recording its
+// results in the metrics, and on the probed pods as configured by
+// outcomes.
+func servePlan(ctx context.Context, k *kubernetes.Kubernetes, reader *planReader, location string, outcomes outcomeOptions, m *metrics) {
+	plan, err := reader.read(ctx, []string{location})
+	if err != nil {
+		indent(ctx, 0, "Error reading test plan %s: %v", location, err)
+		newline(ctx)
+		m.errors.WithLabelValues(location, errorTypeConfig, string(ErrorKindConfig)).Inc()
+		return
+	}
+
+	start := time.Now()
+	report := executeTest(ctx, k, plan)
+	if report.Cancelled != nil {
+		// shutting down, the results are partial
+		return
+	}
+	m.observe(plan, report, start, time.Since(start))
+	recordOutcomes(ctx, k, report, start, outcomes)
+}
+
+// Types of errors counted by metrics.
+const (
+	errorTypeConfig = "config"
+	errorTypeStep   = "step"
+	errorTypeTarget = "target"
+	errorTypeProbe  = "probe"
+)
+
+// metrics holds the Prometheus metrics of test plan runs.
+type metrics struct {
+	testSuccess   *prometheus.GaugeVec
+	testFlaky     *prometheus.CounterVec
+	probeDuration *prometheus.HistogramVec
+	planSuccess   *prometheus.GaugeVec
+	planRuns      *prometheus.CounterVec
+	lastRun       *prometheus.GaugeVec
+	runDuration   *prometheus.GaugeVec
+	errors        *prometheus.CounterVec
+}
+
+// newMetrics creates the metrics and registers them with reg.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		testSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nethax",
+			Name:      "test_success",
+			Help:      "Whether the test passed from all its sources in the last run of the plan (1) or not (0).",
+		}, []string{"plan", "target", "test"}),
+		testFlaky: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nethax",
+			Name:      "test_flaky_total",
+			Help:      "Number of runs of the test from a source in which some attempts of the probe passed and others failed.",
+		}, []string{"plan", "target", "test"}),
+		probeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nethax",
+			Name:      "probe_duration_seconds",
+			Help:      "Time taken to run a probe, including launching its container.",
+			Buckets:   prometheus.ExponentialBuckets(0.25, 2, 9),
+		}, []string{"plan", "target", "test"}),
+		planSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nethax",
+			Name:      "plan_success",
+			Help:      "Whether the last run of the plan passed (1) or not (0).",
+		}, []string{"plan"}),
+		planRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nethax",
+			Name:      "plan_runs_total",
+			Help:      "Number of runs of the plan.",
+		}, []string{"plan"}),
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nethax",
+			Name:      "plan_last_run_timestamp_seconds",
+			Help:      "Time the last run of the plan started, as a Unix timestamp.",
+		}, []string{"plan"}),
+		runDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nethax",
+			Name:      "plan_last_run_duration_seconds",
+			Help:      "Time taken by the last run of the plan.",
+		}, []string{"plan"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nethax",
+			Name:      "errors_total",
+			Help:      "Number of errors preventing tests from running, by type: config, step, target or probe, and kind, e.g. permission or timeout. The plan label is the location of the plan for config errors.",
+		}, []string{"plan", "type", "kind"}),
+	}
+
+	reg.MustRegister(m.testSuccess, m.testFlaky, m.probeDuration, m.planSuccess, m.planRuns, m.lastRun, m.runDuration, m.errors)
+
+	return m
+}
+
+// observe records the results of a run of plan started at start. Tests
+// that could not run, e.g. because their target selected no pods, are
+// recorded as failed.
+func (m *metrics) observe(plan *TestPlan, report *Report, start time.Time, duration time.Duration) {
+	name := report.Plan
+
+	type testKey struct{ target, test string }
+
+	passed := make(map[testKey]bool)
+	for _, target := range plan.TestTargets {
+		for _, test := range target.Tests {
+			passed[testKey{target.Name, test.Name}] = false
+		}
+	}
+
+	ran := make(map[testKey]bool)
+	for _, res := range report.Results {
+		key := testKey{res.Target, res.Test.Name}
+		if !ran[key] {
+			passed[key], ran[key] = true, true
+		}
+		passed[key] = passed[key] && res.Passed()
+
+		if res.Err != nil {
+			m.errors.WithLabelValues(name, errorTypeProbe, string(res.ErrKind)).Inc()
+			continue
+		}
+		m.probeDuration.WithLabelValues(name, res.Target, res.Test.Name).Observe(res.Duration.Seconds())
+		if res.Flaky() {
+			m.testFlaky.WithLabelValues(name, res.Target, res.Test.Name).Inc()
+		}
+	}
+
+	// forget the tests removed from the plan since the last run
+	m.testSuccess.DeletePartialMatch(prometheus.Labels{"plan": name})
+	for key, p := range passed {
+		m.testSuccess.WithLabelValues(name, key.target, key.test).Set(boolValue(p))
+	}
+
+	for _, e := range report.Errors {
+		m.errors.WithLabelValues(name, errorTypeTarget, string(e.Kind)).Inc()
+	}
+	for _, e := range report.StepErrors {
+		m.errors.WithLabelValues(name, errorTypeStep, string(e.Kind)).Inc()
+	}
+
+	m.planSuccess.WithLabelValues(name).Set(boolValue(report.Passed()))
+	m.planRuns.WithLabelValues(name).Inc()
+	m.lastRun.WithLabelValues(name).Set(float64(start.Unix()))
+	m.runDuration.WithLabelValues(name).Set(duration.Seconds())
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
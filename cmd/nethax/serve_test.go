@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMetrics(t *testing.T) {
+	plan := &TestPlan{
+		Name: "shop",
+		TestTargets: []TestTarget{
+			{Name: "frontend", Tests: []Test{{Name: "cart"}, {Name: "internet"}}},
+			{Name: "admin", Tests: []Test{{Name: "cart"}}},
+		},
+	}
+
+	result := func(target, test string, exitCode int32, err error) TestResult {
+		return TestResult{Target: target, Test: Test{Name: test}, ExitCode: exitCode, Err: err, Duration: time.Second}
+	}
+
+	report := &Report{
+		Plan: "shop",
+		Results: []TestResult{
+			result("frontend", "cart", 0, nil),
+			result("frontend", "cart", 0, nil),
+			result("frontend", "internet", 0, nil),
+			result("frontend", "internet", 1, nil),
+			result("frontend", "internet", -1, errors.New("probe failed")),
+		},
+		Errors: []TargetError{{Target: "admin", Err: errNoReadyPods, Kind: ErrorKindSelection}},
+	}
+	report.Results[4].ErrKind = ErrorKindTimeout
+
+	reg := prometheus.NewPedanticRegistry()
+	m := newMetrics(reg)
+
+	start := time.Unix(1700000000, 0)
+	m.observe(plan, report, start, time.Minute)
+
+	exp := `
+# HELP nethax_test_success Whether the test passed from all its sources in the last run of the plan (1) or not (0).
+# TYPE nethax_test_success gauge
+nethax_test_success{plan="shop",target="admin",test="cart"} 0
+nethax_test_success{plan="shop",target="frontend",test="cart"} 1
+nethax_test_success{plan="shop",target="frontend",test="internet"} 0
+# HELP nethax_plan_success Whether the last run of the plan passed (1) or not (0).
+# TYPE nethax_plan_success gauge
+nethax_plan_success{plan="shop"} 0
+# HELP nethax_plan_last_run_timestamp_seconds Time the last run of the plan started, as a Unix timestamp.
+# TYPE nethax_plan_last_run_timestamp_seconds gauge
+nethax_plan_last_run_timestamp_seconds{plan="shop"} 1.7e+09
+# HELP nethax_errors_total Number of errors preventing tests from running, by type: config, step, target or probe, and kind, e.g. permission or timeout. The plan label is the location of the plan for config errors.
+# TYPE nethax_errors_total counter
+nethax_errors_total{kind="selection",plan="shop",type="target"} 1
+nethax_errors_total{kind="timeout",plan="shop",type="probe"} 1
+`
+	names := []string{"nethax_test_success", "nethax_plan_success", "nethax_plan_last_run_timestamp_seconds", "nethax_errors_total"}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp), names...); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, g := 2, testutil.CollectAndCount(m.probeDuration); e != g {
+		t.Errorf("expecting %d probe duration series, got %d", e, g)
+	}
+
+	t.Run("removed tests", func(t *testing.T) {
+		plan := &TestPlan{Name: "shop", TestTargets: []TestTarget{{Name: "frontend", Tests: []Test{{Name: "cart"}}}}}
+		report := &Report{Plan: "shop", Results: []TestResult{result("frontend", "cart", 0, nil)}}
+
+		m.observe(plan, report, start, time.Minute)
+
+		if e, g := 1, testutil.CollectAndCount(m.testSuccess); e != g {
+			t.Errorf("expecting %d test success series, got %d", e, g)
+		}
+		if e, g := 1.0, testutil.ToFloat64(m.planSuccess.WithLabelValues("shop")); e != g {
+			t.Errorf("expecting plan success %v, got %v", e, g)
+		}
+	})
+}
+
+func TestServePlan(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "nethax", Name: "plans"},
+		Data: map[string]string{
+			"shop.yaml": "testPlan:\n  name: shop\n  testTargets:\n  - name: frontend\n    namespace: ${namespace}\n    tests: []\n",
+		},
+	}
+	k := kubernetes.NewWithClient(testClient.NewClientset(cm))
+	const location = "configmap://nethax/plans"
+
+	for n, tt := range map[string]struct {
+		vars map[string]string
+		runs float64
+		errs float64
+	}{
+		"vars set":     {map[string]string{"namespace": "shop"}, 1, 0},
+		"vars not set": {nil, 0, 1},
+	} {
+		t.Run(n, func(t *testing.T) {
+			m := newMetrics(prometheus.NewRegistry())
+			reader := &planReader{vars: tt.vars, kubernetes: func() (*kubernetes.Kubernetes, error) { return k, nil }}
+
+			var out bytes.Buffer
+			servePlan(withOutput(t.Context(), &out), k, reader, location, outcomeOptions{}, m)
+
+			if g := testutil.ToFloat64(m.planRuns.WithLabelValues("shop")); g != tt.runs {
+				t.Errorf("expecting %v runs, got %v", tt.runs, g)
+			}
+			if g := testutil.ToFloat64(m.errors.WithLabelValues(location, errorTypeConfig, string(ErrorKindConfig))); g != tt.errs {
+				t.Errorf("expecting %v config errors, got %v\n%s", tt.errs, g, out.String())
+			}
+		})
+	}
+}
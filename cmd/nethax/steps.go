@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	"github.com/grafana/nethax/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// stepTimeout is how long to wait for the changes of a step, or their
+// revert, to take effect by default.
+const stepTimeout = 2 * time.Minute
+
+// runSetup runs the setup steps of plan, stopping at the first one
+// that fails. It returns the changes made, which must be reverted with
+// runTeardown even if a step failed.
+func runSetup(ctx context.Context, k *kubernetes.Kubernetes, plan *TestPlan) ([]*kubernetes.Change, []StepError) {
+	if len(plan.Setup) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "setup")
+	defer span.End()
+
+	indent(ctx, 0, "Setup:")
+	defer newline(ctx)
+
+	var changes []*kubernetes.Change
+	for _, step := range plan.Setup {
+		indent(ctx, 1, "Step: %s", step.Name)
+
+		cs, err := runStep(ctx, k, plan.Dir, step)
+		changes = append(changes, cs...)
+		if err != nil {
+			indent(ctx, 2, "Error: %v", err)
+			tracing.RecordError(span, err) //nolint:errcheck
+			return changes, []StepError{{Stage: "setup", Step: step.Name, Err: err, Kind: errorKind(ctx, err)}}
+		}
+	}
+
+	return changes, nil
+}
+
+// runTeardown reverts the changes made by the setup steps of plan, in
+// reverse order, and then runs its teardown steps. Errors don't stop
+// the teardown, so as much as possible is cleaned up.
+func runTeardown(ctx context.Context, k *kubernetes.Kubernetes, plan *TestPlan, changes []*kubernetes.Change) []StepError {
+	if len(plan.Teardown) == 0 && len(changes) == 0 {
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "teardown")
+	defer span.End()
+
+	indent(ctx, 0, "Teardown:")
+	defer newline(ctx)
+
+	var errs []StepError
+
+	if len(changes) > 0 {
+		indent(ctx, 1, "Reverting setup changes")
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		err := k.Revert(ctx, changes[i])
+		if err == nil {
+			err = k.WaitReverted(ctx, changes[i], stepTimeout)
+		}
+		if err != nil {
+			indent(ctx, 2, "Error: %v", err)
+			tracing.RecordError(span, err) //nolint:errcheck
+			errs = append(errs, StepError{Stage: "teardown", Step: "revert setup", Err: err, Kind: errorKind(ctx, err)})
+			continue
+		}
+		indent(ctx, 2, "Reverted: %s", changes[i])
+	}
+
+	for _, step := range plan.Teardown {
+		indent(ctx, 1, "Step: %s", step.Name)
+
+		// teardown changes are meant to stay
+		if _, err := runStep(ctx, k, plan.Dir, step); err != nil {
+			indent(ctx, 2, "Error: %v", err)
+			tracing.RecordError(span, err) //nolint:errcheck
+			errs = append(errs, StepError{Stage: "teardown", Step: step.Name, Err: err, Kind: errorKind(ctx, err)})
+		}
+	}
+
+	return errs
+}
+
+// runStep applies or deletes the objects of step, waits for the applied
+// ones to be ready and the deleted ones to be gone, and then waits for
+// step.Wait. It returns the changes made, even if it fails.
+func runStep(ctx context.Context, k *kubernetes.Kubernetes, dir string, step Step) (_ []*kubernetes.Change, err error) {
+	ctx, span := tracer.Start(ctx, "step", trace.WithAttributes(attribute.String("nethax.step", step.Name)))
+	defer func() {
+		tracing.RecordError(span, err) //nolint:errcheck
+		span.End()
+	}()
+
+	objs, err := readStepManifests(dir, step)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDryRun(ctx) {
+		printStepObjects(ctx, step, objs)
+		return nil, nil
+	}
+
+	var changes []*kubernetes.Change
+	for _, obj := range objs {
+		var c *kubernetes.Change
+		if step.Delete {
+			c, err = k.Delete(ctx, obj)
+		} else {
+			c, err = k.Apply(ctx, obj)
+		}
+		if err != nil {
+			return changes, err
+		}
+
+		if c == nil {
+			indent(ctx, 2, "Not found: %s %s", obj.GetKind(), obj.GetName())
+			continue
+		}
+		indent(ctx, 2, "Change: %s", c)
+		changes = append(changes, c)
+	}
+
+	for _, c := range changes {
+		if err := k.WaitChange(ctx, c, cmp.Or(step.Timeout, stepTimeout)); err != nil {
+			return changes, err
+		}
+	}
+
+	if step.Wait > 0 {
+		indent(ctx, 2, "Waiting %s for the changes to take effect", step.Wait)
+
+		select {
+		case <-ctx.Done():
+			return changes, ctx.Err()
+		case <-time.After(step.Wait):
+		}
+	}
+
+	return changes, nil
+}
+
+// readStepManifests returns the objects of the manifests of step,
+// followed by the ones of its files.
+func readStepManifests(dir string, step Step) ([]*unstructured.Unstructured, error) {
+	objs, err := kubernetes.DecodeManifests(strings.NewReader(step.Manifests))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range step.Files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("opening manifests file: %w", err)
+		}
+
+		fobjs, err := kubernetes.DecodeManifests(f)
+		f.Close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+
+		objs = append(objs, fobjs...)
+	}
+
+	return objs, nil
+}
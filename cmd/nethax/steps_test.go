@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	dynamicClient "k8s.io/client-go/dynamic/fake"
+	testClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestSetupTeardown(t *testing.T) {
+	ctx := context.Background()
+
+	existing := &networkingv1.NetworkPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "allow-frontend"},
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"), meta.RESTScopeNamespace)
+
+	newClients := func() (*kubernetes.Kubernetes, func() []string) {
+		dyn := dynamicClient.NewSimpleDynamicClient(scheme.Scheme, existing)
+		k := kubernetes.NewWithClients(testClient.NewClientset(), dyn, mapper)
+
+		// names returns the names of the policies in the cluster
+		names := func() []string {
+			list, err := dyn.Resource(networkingv1.SchemeGroupVersion.WithResource("networkpolicies")).Namespace("shop").List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.GetName())
+			}
+			return names
+		}
+
+		return k, names
+	}
+
+	deleteExisting := Step{
+		Name:   "delete allow-frontend",
+		Delete: true,
+		Manifests: `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-frontend
+  namespace: shop
+`,
+	}
+
+	t.Run("revert", func(t *testing.T) {
+		k, names := newClients()
+
+		plan := &TestPlan{
+			Dir:   "testdata",
+			Setup: []Step{{Name: "deny all", Files: []string{"deny-all.yaml"}}, deleteExisting},
+		}
+
+		changes, errs := runSetup(ctx, k, plan)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if e, g := "[deny-all]", fmt.Sprint(names()); e != g {
+			t.Fatalf("expecting policies %s after setup, got %s", e, g)
+		}
+
+		if errs := runTeardown(ctx, k, plan, changes); len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if e, g := "[allow-frontend]", fmt.Sprint(names()); e != g {
+			t.Fatalf("expecting policies %s after teardown, got %s", e, g)
+		}
+	})
+
+	t.Run("failed setup", func(t *testing.T) {
+		k, names := newClients()
+
+		plan := &TestPlan{
+			Dir: "testdata",
+			Setup: []Step{
+				{Name: "deny all", Files: []string{"deny-all.yaml"}},
+				{Name: "missing", Files: []string{"missing.yaml"}},
+				deleteExisting,
+			},
+			Teardown: []Step{deleteExisting},
+		}
+
+		changes, errs := runSetup(ctx, k, plan)
+		if len(errs) != 1 || errs[0].Step != "missing" {
+			t.Fatalf("expecting missing step to fail, got %v", errs)
+		}
+		if len(changes) != 1 {
+			t.Fatalf("expecting 1 change, got %v", changes)
+		}
+
+		if errs := runTeardown(ctx, k, plan, changes); len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if e, g := "[]", fmt.Sprint(names()); e != g {
+			t.Fatalf("expecting policies %s after teardown, got %s", e, g)
+		}
+	})
+}
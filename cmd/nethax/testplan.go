@@ -1,31 +1,78 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/goccy/go-yaml"
+	"github.com/grafana/nethax/pkg/kubernetes"
+	pf "github.com/grafana/nethax/pkg/probeflags"
 )
 
 // Test represents a single network connectivity test
 type Test struct {
 	Name       string        `yaml:"name"`
 	Endpoint   string        `yaml:"endpoint"`
-	StatusCode int           `yaml:"statusCode"`
+	StatusCode int           `yaml:"statusCode,omitempty"`
 	Type       TestType      `yaml:"type,omitempty"`
 	ExpectFail bool          `yaml:"expectFail,omitempty"`
 	Timeout    time.Duration `yaml:"timeout"`
 	ProbeImage string        `yaml:"probeImage,omitempty"`
+	// Retries is how many times the probe retries a failed attempt,
+	// waiting RetryInterval (default 1s) in between. Timeout applies
+	// to each attempt.
+	Retries       int           `yaml:"retries,omitempty"`
+	RetryInterval time.Duration `yaml:"retryInterval,omitempty"`
+	// Repeat is how many times the probe runs the test, each with its
+	// own retries, and MinSuccessRate the ratio of repetitions that
+	// must pass for the test to pass (default 1, all of them).
+	Repeat         int     `yaml:"repeat,omitempty"`
+	MinSuccessRate float64 `yaml:"minSuccessRate,omitempty"`
+	// Tags are used to select the tests to run, with --tags and
+	// --skip-tags.
+	Tags []string `yaml:"tags,omitempty"`
+}
+
+var (
+	errInvalidAttempts    = errors.New("retries, retryInterval and repeat cannot be negative")
+	errInvalidSuccessRate = errors.New("minSuccessRate must be between 0 and 1")
+)
+
+func (t Test) validate() error {
+	if t.Retries < 0 || t.RetryInterval < 0 || t.Repeat < 0 {
+		return errInvalidAttempts
+	}
+	if t.MinSuccessRate < 0 || t.MinSuccessRate > 1 {
+		return fmt.Errorf("%w: %v", errInvalidSuccessRate, t.MinSuccessRate)
+	}
+	return nil
 }
 
 // PodSelector represents how pods should be selected for testing
 type PodSelector struct {
-	Mode   SelectionMode `yaml:"mode"` // "all" or "random"
-	Labels string        `yaml:"labels"`
-	Fields string        `yaml:"fields"`
+	Mode     SelectionMode `yaml:"mode"` // "all" or "random"
+	Labels   string        `yaml:"labels,omitempty"`
+	Fields   string        `yaml:"fields,omitempty"`
+	Workload *WorkloadRef  `yaml:"workload,omitempty"`
+}
+
+// WorkloadRef references a workload resource whose pods should be
+// selected for testing.
+type WorkloadRef struct {
+	Kind kubernetes.WorkloadKind `yaml:"kind"` // Deployment, StatefulSet, DaemonSet or Job
+	Name string                  `yaml:"name"`
+}
+
+func (w WorkloadRef) String() string {
+	return string(w.Kind) + "/" + w.Name
 }
 
 func (s PodSelector) String() string {
@@ -41,37 +88,466 @@ func (s PodSelector) String() string {
 		b.WriteString(", fields: ")
 		b.WriteString(s.Fields)
 	}
+	if s.Workload != nil {
+		b.WriteString(", workload: ")
+		b.WriteString(s.Workload.String())
+	}
 
 	return b.String()
 }
 
-// TestTarget represents a pod target with multiple tests
+// NodeSelector represents how nodes should be selected for testing.
+// Tests run from the host network namespace of the selected nodes.
+type NodeSelector struct {
+	Mode   SelectionMode `yaml:"mode"` // "all" or "random"
+	Labels string        `yaml:"labels,omitempty"`
+}
+
+func (s NodeSelector) String() string {
+	var b strings.Builder
+	b.WriteString("mode: ")
+	b.WriteString(string(s.Mode))
+
+	if s.Labels != "" {
+		b.WriteString(", labels: ")
+		b.WriteString(s.Labels)
+	}
+
+	return b.String()
+}
+
+// TestTarget represents a pod or node target with multiple tests
 type TestTarget struct {
-	Name        string      `yaml:"name"`
-	Namespace   string      `yaml:"namespace,omitempty"`
-	PodSelector PodSelector `yaml:"podSelector"`
-	Tests       []Test      `yaml:"tests"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+	// NamespaceSelector is a labels selector for Namespace objects;
+	// the target is run once for each matching namespace.
+	NamespaceSelector string `yaml:"namespaceSelector,omitempty"`
+	// AllNamespaces runs the target once for each namespace in the
+	// cluster.
+	AllNamespaces bool        `yaml:"allNamespaces,omitempty"`
+	PodSelector   PodSelector `yaml:"podSelector,omitempty"`
+	// NodeSelector makes this a node target: probes run in short-lived
+	// host network pods created in Namespace (or "default") and pinned
+	// to each selected node.
+	NodeSelector *NodeSelector `yaml:"nodeSelector,omitempty"`
+	// Destination is deployed before running the tests of the target,
+	// and removed afterwards.
+	Destination *Destination `yaml:"destination,omitempty"`
+	// Cluster is the kubeconfig context of the cluster the pods or
+	// nodes of the target are in, if not the one the plan runs in.
+	Cluster string `yaml:"cluster,omitempty"`
+	// Tags apply to all the tests of the target.
+	Tags []string `yaml:"tags,omitempty"`
+	// Defaults override the defaults of the plan for the tests of the
+	// target.
+	Defaults *TestDefaults `yaml:"defaults,omitempty"`
+	Tests    []Test        `yaml:"tests"`
+}
+
+// TestDefaults are the values of the fields of tests that don't set
+// them.
+type TestDefaults struct {
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+	ProbeImage string        `yaml:"probeImage,omitempty"`
+	Type       *TestType     `yaml:"type,omitempty"`
+}
+
+// override returns d with the fields o sets replaced.
+func (d TestDefaults) override(o TestDefaults) TestDefaults {
+	d.Timeout = cmp.Or(o.Timeout, d.Timeout)
+	d.ProbeImage = cmp.Or(o.ProbeImage, d.ProbeImage)
+	if o.Type != nil {
+		d.Type = o.Type
+	}
+	return d
+}
+
+// PlanDefaults are the defaults of the tests of a plan, and of the
+// namespace of its targets.
+type PlanDefaults struct {
+	TestDefaults `yaml:",inline"`
+	// Namespace is the namespace of the targets that don't select one,
+	// and of their destinations.
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// Destination is a temporary echo server for the tests of a target to
+// connect to. Tests with an empty endpoint connect to its first port,
+// and tests with an endpoint starting with a colon to the given port
+// and, for HTTP tests, path (e.g. ":9090/metrics").
+type Destination struct {
+	// Namespace defaults to "default".
+	Namespace string `yaml:"namespace,omitempty"`
+	// Labels are set on the echo server pod, so that network policies
+	// select it like the workload it stands for.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Ports are the TCP ports the echo server listens on.
+	Ports []int32 `yaml:"ports"`
+	// Service exposes the echo server with a Service, which tests then
+	// connect to instead of the pod IP.
+	Service bool `yaml:"service,omitempty"`
+	// LoadBalancer exposes the echo server with a LoadBalancer Service,
+	// which tests then connect to through the load balancer.
+	LoadBalancer bool `yaml:"loadBalancer,omitempty"`
+	// Export exposes the echo server with a Service exported to the
+	// other clusters of its clusterset with the multi-cluster services
+	// API, which tests then connect to by its clusterset.local name.
+	Export bool `yaml:"export,omitempty"`
+	// Cluster is the kubeconfig context of the cluster to deploy the
+	// echo server in, if not the one the plan runs in.
+	Cluster    string `yaml:"cluster,omitempty"`
+	ProbeImage string `yaml:"probeImage,omitempty"`
+	// Existing is a pod or Service of Namespace in Cluster to connect
+	// to instead of deploying an echo server, as pod/<name> or
+	// service/<name>. Pods are connected to by IP, and Services through
+	// their load balancer with LoadBalancer, by their clusterset.local
+	// name with Export, or else by their cluster.local name.
+	Existing string `yaml:"existing,omitempty"`
+}
+
+// Kinds of the existing destinations.
+const (
+	existingPod     = "pod"
+	existingService = "service"
+)
+
+var (
+	errNoDestinationPorts     = errors.New("destination ports must be specified")
+	errInvalidDestinationPort = errors.New("invalid destination port")
+	errNoDestination          = errors.New("test endpoint requires a destination")
+	errUnknownDestinationPort = errors.New("test endpoint port is not a destination port")
+	errConflictingExposure    = errors.New("loadBalancer and export are mutually exclusive")
+	errInvalidExisting        = errors.New("expecting existing destination as pod/<name> or service/<name>")
+	errExistingEchoServer     = errors.New("labels and probeImage only apply to echo servers, not existing destinations")
+	errExistingPodExposure    = errors.New("existing pods are connected to by IP, without service, loadBalancer or export")
+	errDNSDestination         = errors.New("DNS tests can only connect to destinations whose host is a name: Services without loadBalancer")
+)
+
+func (d Destination) validate() error {
+	if len(d.Ports) == 0 {
+		return errNoDestinationPorts
+	}
+	if d.LoadBalancer && d.Export {
+		return errConflictingExposure
+	}
+	if d.Existing != "" {
+		kind, name, _ := strings.Cut(d.Existing, "/")
+		switch {
+		case kind != existingPod && kind != existingService || name == "":
+			return fmt.Errorf("%w: %q", errInvalidExisting, d.Existing)
+		case len(d.Labels) > 0 || d.ProbeImage != "":
+			return errExistingEchoServer
+		case kind == existingPod && (d.Service || d.LoadBalancer || d.Export):
+			return errExistingPodExposure
+		}
+	}
+	for _, p := range d.Ports {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("%w: %d", errInvalidDestinationPort, p)
+		}
+	}
+	return nil
+}
+
+// hostIsName returns whether tests connect to d by a DNS name rather
+// than by IP.
+func (d Destination) hostIsName() bool {
+	if d.LoadBalancer {
+		return false
+	}
+	if d.Existing != "" {
+		return strings.HasPrefix(d.Existing, existingService+"/")
+	}
+	return d.Service || d.Export
+}
+
+// usesDestination returns whether test connects to the destination of
+// its target.
+func usesDestination(test Test) bool {
+	return test.Endpoint == "" || strings.HasPrefix(test.Endpoint, ":")
+}
+
+// portPath returns the port and path of the endpoint of a
+// test connecting to destination d.
+func (d Destination) portPath(test Test) (int32, string, error) {
+	port, path, _ := strings.Cut(strings.TrimPrefix(test.Endpoint, ":"), "/")
+	if port == "" {
+		return d.Ports[0], path, nil
+	}
+
+	n, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || !slices.Contains(d.Ports, int32(n)) {
+		return 0, "", fmt.Errorf("%w: %q", errUnknownDestinationPort, port)
+	}
+
+	return int32(n), path, nil
+}
+
+var (
+	errConflictingNamespaces = errors.New("namespace, namespaceSelector and allNamespaces are mutually exclusive")
+	errConflictingSelectors  = errors.New("podSelector and nodeSelector are mutually exclusive")
+	errNodeTargetNamespaces  = errors.New("node targets cannot use namespaceSelector or allNamespaces")
+)
+
+// PerNamespace returns whether the target should be run once for each
+// of a set of namespaces instead of a single one.
+func (t TestTarget) PerNamespace() bool {
+	return t.NamespaceSelector != "" || t.AllNamespaces
+}
+
+func (t TestTarget) validate() error {
+	var n int
+	for _, set := range []bool{t.Namespace != "", t.NamespaceSelector != "", t.AllNamespaces} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errConflictingNamespaces
+	}
+
+	if t.NodeSelector != nil {
+		if t.PodSelector != (PodSelector{}) {
+			return errConflictingSelectors
+		}
+		if t.PerNamespace() {
+			return errNodeTargetNamespaces
+		}
+	}
+
+	if t.Destination != nil {
+		if err := t.Destination.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, test := range t.Tests {
+		if err := test.validate(); err != nil {
+			return fmt.Errorf("test %q: %w", test.Name, err)
+		}
+		if !usesDestination(test) {
+			continue
+		}
+		if t.Destination == nil {
+			return fmt.Errorf("test %q: %w", test.Name, errNoDestination)
+		}
+		if _, _, err := t.Destination.portPath(test); err != nil {
+			return fmt.Errorf("test %q: %w", test.Name, err)
+		}
+		// resolving the IP of a pod or load balancer always succeeds
+		if test.Type == TestTypeDNS && !t.Destination.hostIsName() {
+			return fmt.Errorf("test %q: %w", test.Name, errDNSDestination)
+		}
+	}
+
+	return nil
+}
+
+// Step is a setup or teardown step of a test plan, applying or
+// deleting Kubernetes objects.
+type Step struct {
+	Name string `yaml:"name"`
+	// Manifests holds the YAML manifests of the objects, and Files the
+	// paths of files holding more, relative to the test plan file.
+	Manifests string   `yaml:"manifests,omitempty"`
+	Files     []string `yaml:"files,omitempty"`
+	// Delete deletes the objects instead of applying them.
+	Delete bool `yaml:"delete,omitempty"`
+	// Timeout is how long to wait for the applied objects to be ready,
+	// or for the deleted ones to be gone, 2 minutes if unset.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Wait is how long to wait after that for the changes to take
+	// effect, e.g. for the CNI to enforce a new NetworkPolicy.
+	Wait time.Duration `yaml:"wait,omitempty"`
+}
+
+var errEmptyStep = errors.New("manifests or files must be specified")
+
+func (s Step) validate() error {
+	if s.Manifests == "" && len(s.Files) == 0 {
+		return errEmptyStep
+	}
+	return nil
 }
 
 // TestPlan represents a collection of test targets with metadata
 type TestPlan struct {
-	Name        string       `yaml:"name"`
-	Description string       `yaml:"description"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	// Setup steps run before the tests. Once the tests have run, even
+	// if they could not, their changes are reverted and then the
+	// Teardown steps run.
+	Setup       []Step       `yaml:"setup,omitempty"`
+	Teardown    []Step       `yaml:"teardown,omitempty"`
 	TestTargets []TestTarget `yaml:"testTargets"`
+	// Clusters are the kubeconfig contexts of the clusters execute-test
+	// runs the plan in, in parallel, unless overridden by --context.
+	Clusters []string `yaml:"clusters,omitempty"`
+	// Defaults apply to the targets and tests of the plan, but not to
+	// the ones of the plans it includes.
+	Defaults *PlanDefaults `yaml:"defaults,omitempty"`
+	// Include holds the paths of plan files, or directories of plan
+	// files, whose targets and steps are added to the plan, relative to
+	// the plan file.
+	Include []string `yaml:"include,omitempty"`
+	// Vars are the default values of the variables the endpoints,
+	// namespaces and selectors of the plan reference as ${name}, see
+	// expandVars.
+	Vars map[string]string `yaml:"vars,omitempty"`
+
+	// Dir is the directory the files of the steps are relative to.
+	Dir string `yaml:"-"`
 }
 
 // ParseTestPlan reads YAML content and returns a TestPlan
 func ParseTestPlan(reader io.Reader) (*TestPlan, error) {
+	return parseTestPlan(reader, nil)
+}
+
+// parseTestPlan reads YAML content and returns a TestPlan, with the
+// variables it references expanded, overriding its defaults with vars.
+func parseTestPlan(reader io.Reader, vars map[string]string) (*TestPlan, error) {
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
 	var plan struct {
 		TestPlan TestPlan `yaml:"testPlan"`
 	}
-	if err := yaml.NewDecoder(reader, yaml.Strict()).Decode(&plan); err != nil {
+	if err := yaml.NewDecoder(bytes.NewReader(b), yaml.Strict()).Decode(&plan); err != nil {
+		return nil, err
+	}
+
+	if err := plan.TestPlan.expandVars(vars); err != nil {
+		return nil, err
+	}
+
+	// the type of tests defaults to HTTP, so whether they set it can
+	// only be told from the document
+	var raw struct {
+		TestPlan struct {
+			TestTargets []struct {
+				Tests []map[string]any `yaml:"tests"`
+			} `yaml:"testTargets"`
+		} `yaml:"testPlan"`
+	}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
 		return nil, err
 	}
+	plan.TestPlan.applyDefaults(func(target, test int) bool {
+		_, ok := raw.TestPlan.TestTargets[target].Tests[test]["type"]
+		return ok
+	})
+
+	for _, t := range plan.TestPlan.TestTargets {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("test target %q: %w", t.Name, err)
+		}
+	}
+
+	for _, s := range slices.Concat(plan.TestPlan.Setup, plan.TestPlan.Teardown) {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("step %q: %w", s.Name, err)
+		}
+	}
 
 	return &plan.TestPlan, nil
 }
 
+// applyDefaults sets the fields the targets and tests of p don't set
+// to the defaults of p and of their target. hasType returns whether
+// the given test of the given target sets its type.
+func (p *TestPlan) applyDefaults(hasType func(target, test int) bool) {
+	var pd PlanDefaults
+	if p.Defaults != nil {
+		pd = *p.Defaults
+	}
+
+	for i := range p.TestTargets {
+		t := &p.TestTargets[i]
+
+		if pd.Namespace != "" {
+			if t.Namespace == "" && !t.PerNamespace() {
+				t.Namespace = pd.Namespace
+			}
+			if t.Destination != nil && t.Destination.Namespace == "" {
+				t.Destination.Namespace = pd.Namespace
+			}
+		}
+
+		d := pd.TestDefaults
+		if t.Defaults != nil {
+			d = d.override(*t.Defaults)
+		}
+
+		for j := range t.Tests {
+			test := &t.Tests[j]
+			test.Timeout = cmp.Or(test.Timeout, d.Timeout)
+			test.ProbeImage = cmp.Or(test.ProbeImage, d.ProbeImage)
+			if d.Type != nil && !hasType(i, j) {
+				test.Type = *d.Type
+			}
+		}
+	}
+}
+
+// mergePlans returns plan with the targets and steps of others added
+// after its own, in order. The files of the steps of others are made
+// absolute, as they are relative to their own plan file.
+func mergePlans(plan *TestPlan, others []*TestPlan) *TestPlan {
+	if len(others) == 0 {
+		return plan
+	}
+
+	res := *plan
+	res.TestTargets = slices.Clone(plan.TestTargets)
+	res.Setup = slices.Clone(plan.Setup)
+	res.Teardown = slices.Clone(plan.Teardown)
+
+	for _, o := range others {
+		res.TestTargets = append(res.TestTargets, o.TestTargets...)
+		res.Setup = append(res.Setup, o.stepsWithAbsoluteFiles(o.Setup)...)
+		res.Teardown = append(res.Teardown, o.stepsWithAbsoluteFiles(o.Teardown)...)
+	}
+
+	return &res
+}
+
+// stepsWithAbsoluteFiles returns copies of the given steps of p with
+// the paths of their files made absolute.
+func (p *TestPlan) stepsWithAbsoluteFiles(steps []Step) []Step {
+	res := make([]Step, len(steps))
+	for i, s := range steps {
+		s.Files = slices.Clone(s.Files)
+		for j, f := range s.Files {
+			if !filepath.IsAbs(f) {
+				if abs, err := filepath.Abs(filepath.Join(p.Dir, f)); err == nil {
+					s.Files[j] = abs
+				}
+			}
+		}
+		res[i] = s
+	}
+	return res
+}
+
+// probeSpec returns the spec the probe runs test with.
+func (t Test) probeSpec() pf.Spec {
+	return pf.Spec{
+		Type:           t.Type.String(),
+		Endpoint:       t.Endpoint,
+		Timeout:        t.Timeout,
+		ExpectedStatus: t.StatusCode,
+		ExpectFail:     t.ExpectFail,
+		Retries:        t.Retries,
+		RetryInterval:  t.RetryInterval,
+		Repeat:         t.Repeat,
+		MinSuccessRate: t.MinSuccessRate,
+	}
+}
+
 type TestType int
 
 func (tt TestType) String() string {
@@ -110,9 +586,26 @@ func yamlUnmarshalTestType(tt *TestType, b []byte) error {
 	return nil
 }
 
+func yamlMarshalTestType(tt TestType) ([]byte, error) {
+	return []byte(tt.String()), nil
+}
+
 func init() {
+	yaml.RegisterCustomMarshaler(yamlMarshalTestType)
 	yaml.RegisterCustomUnmarshaler(yamlUnmarshalTestType)
 	yaml.RegisterCustomUnmarshaler(yamlUnmarshalSelectionMode)
+	yaml.RegisterCustomUnmarshaler(yamlUnmarshalWorkloadKind)
+}
+
+func yamlUnmarshalWorkloadKind(k *kubernetes.WorkloadKind, b []byte) error {
+	// see yamlUnmarshalSelectionMode for why we trim the quotes
+	kind, err := kubernetes.ParseWorkloadKind(strings.Trim(strings.TrimSpace(string(b)), `"'`))
+	if err != nil {
+		return err
+	}
+	*k = kind
+
+	return nil
 }
 
 type SelectionMode string
@@ -4,8 +4,15 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/grafana/nethax/pkg/kubernetes"
 )
 
 //go:embed testdata/example.yml
@@ -19,7 +26,7 @@ func TestParseTestPlan(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if e, g := 2, len(tp.TestTargets); e != g {
+	if e, g := 3, len(tp.TestTargets); e != g {
 		t.Fatalf("expecting %d test targets, got %d", e, g)
 	}
 
@@ -54,6 +61,20 @@ func TestParseTestPlan(t *testing.T) {
 			t.Errorf("expecting probe image to be %q, got %q", e, g)
 		}
 	})
+
+	t.Run("parse workload", func(t *testing.T) {
+		if w := tp.TestTargets[0].PodSelector.Workload; w != nil {
+			t.Errorf("expecting no workload, got %v", w)
+		}
+
+		w := tp.TestTargets[2].PodSelector.Workload
+		if w == nil {
+			t.Fatal("expecting workload, got nil")
+		}
+		if e, g := (WorkloadRef{Kind: kubernetes.WorkloadKindDeployment, Name: "frontend"}), *w; e != g {
+			t.Errorf("expecting workload %v, got %v", e, g)
+		}
+	})
 }
 
 func TestPodSelector_String(t *testing.T) {
@@ -71,6 +92,9 @@ func TestPodSelector_String(t *testing.T) {
 		{PodSelector{Mode: "random", Labels: "app=grafana"}, "mode: random, labels: app=grafana"},
 		{PodSelector{Mode: "random", Fields: "spec.nodeName=foo-bar-23"}, "mode: random, fields: spec.nodeName=foo-bar-23"},
 		{PodSelector{Mode: "random", Labels: "app=grafana", Fields: "spec.nodeName=foo-bar-23"}, "mode: random, labels: app=grafana, fields: spec.nodeName=foo-bar-23"},
+
+		{PodSelector{Mode: "all", Workload: &WorkloadRef{Kind: "Deployment", Name: "frontend"}}, "mode: all, workload: Deployment/frontend"},
+		{PodSelector{Mode: "random", Labels: "tier=web", Workload: &WorkloadRef{Kind: "StatefulSet", Name: "db"}}, "mode: random, labels: tier=web, workload: StatefulSet/db"},
 	}
 
 	for _, tt := range tests {
@@ -153,3 +177,491 @@ func TestSelectionMode_UnmarshalYAML(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkloadKind_UnmarshalYAML(t *testing.T) {
+	tests := map[string]struct {
+		exp  kubernetes.WorkloadKind
+		fail bool
+	}{
+		"Deployment":     {kubernetes.WorkloadKindDeployment, false},
+		"\"deployment\"": {kubernetes.WorkloadKindDeployment, false},
+		"'StatefulSet'":  {kubernetes.WorkloadKindStatefulSet, false},
+		"daemonset":      {kubernetes.WorkloadKindDaemonSet, false},
+		"Job":            {kubernetes.WorkloadKindJob, false},
+		"":               {"", true},
+		"CronJob":        {"", true},
+	}
+
+	for in, tt := range tests {
+		t.Run("in="+in, func(t *testing.T) {
+			var got kubernetes.WorkloadKind
+
+			err := yamlUnmarshalWorkloadKind(&got, []byte(in))
+			if tt.fail != (err != nil) {
+				t.Fatalf("expecting failure %v, got error %v", tt.fail, err)
+			}
+			if tt.exp != got {
+				t.Fatalf("expecting WorkloadKind %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestParseTestPlan_Namespaces(t *testing.T) {
+	parse := func(target string) (*TestPlan, error) {
+		return ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: namespaces
+  testTargets:
+  - name: tenants
+    podSelector:
+      mode: all
+` + target + `
+    tests: []
+`))
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tests := map[string]struct {
+			target       string
+			perNamespace bool
+		}{
+			"none":              {"", false},
+			"namespace":         {"    namespace: kube-system", false},
+			"namespaceSelector": {"    namespaceSelector: tenant=true", true},
+			"allNamespaces":     {"    allNamespaces: true", true},
+		}
+
+		for n, tt := range tests {
+			t.Run(n, func(t *testing.T) {
+				tp, err := parse(tt.target)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if g := tp.TestTargets[0].PerNamespace(); tt.perNamespace != g {
+					t.Fatalf("expecting per namespace %v, got %v", tt.perNamespace, g)
+				}
+			})
+		}
+	})
+
+	t.Run("conflicting", func(t *testing.T) {
+		tests := map[string]string{
+			"namespace and selector":      "    namespace: kube-system\n    namespaceSelector: tenant=true",
+			"namespace and all":           "    namespace: kube-system\n    allNamespaces: true",
+			"selector and all":            "    namespaceSelector: tenant=true\n    allNamespaces: true",
+			"namespace, selector and all": "    namespace: kube-system\n    namespaceSelector: tenant=true\n    allNamespaces: true",
+		}
+
+		for n, target := range tests {
+			t.Run(n, func(t *testing.T) {
+				if _, err := parse(target); !errors.Is(err, errConflictingNamespaces) {
+					t.Fatalf("expecting error %v, got %v", errConflictingNamespaces, err)
+				}
+			})
+		}
+	})
+}
+
+func TestParseTestPlan_NodeTargets(t *testing.T) {
+	parse := func(target string) (*TestPlan, error) {
+		return ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: nodes
+  testTargets:
+  - name: kubelet
+` + target + `
+    tests: []
+`))
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tp, err := parse("    namespace: nethax\n    nodeSelector:\n      mode: all\n      labels: pool=default")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		exp := NodeSelector{Mode: SelectionModeAll, Labels: "pool=default"}
+		if got := tp.TestTargets[0].NodeSelector; got == nil || exp != *got {
+			t.Fatalf("expecting node selector %v, got %v", exp, got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]struct {
+			target string
+			err    error
+		}{
+			"pod and node selector": {"    nodeSelector:\n      mode: all\n    podSelector:\n      mode: all", errConflictingSelectors},
+			"namespace selector":    {"    nodeSelector:\n      mode: all\n    namespaceSelector: tenant=true", errNodeTargetNamespaces},
+			"all namespaces":        {"    nodeSelector:\n      mode: all\n    allNamespaces: true", errNodeTargetNamespaces},
+		}
+
+		for n, tt := range tests {
+			t.Run(n, func(t *testing.T) {
+				if _, err := parse(tt.target); !errors.Is(err, tt.err) {
+					t.Fatalf("expecting error %v, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+}
+
+func TestNodeSelector_String(t *testing.T) {
+	tests := []struct {
+		sel NodeSelector
+		exp string
+	}{
+		{NodeSelector{Mode: "all"}, "mode: all"},
+		{NodeSelector{Mode: "random", Labels: "pool=default"}, "mode: random, labels: pool=default"},
+	}
+
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.sel); tt.exp != got {
+			t.Errorf("for %#v expecting %q, got %q", tt.sel, tt.exp, got)
+		}
+	}
+}
+
+func TestParseTestPlan_Steps(t *testing.T) {
+	parse := func(steps string) (*TestPlan, error) {
+		return ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: steps
+` + steps + `
+  testTargets: []
+`))
+	}
+
+	tp, err := parse(`  setup:
+  - name: deny all
+    files: [deny-all.yaml]
+    wait: 5s
+  teardown:
+  - name: remove debug pod
+    delete: true
+    manifests: |
+      apiVersion: v1
+      kind: Pod
+      metadata:
+        name: debug`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, g := (Step{Name: "deny all", Files: []string{"deny-all.yaml"}, Wait: 5 * time.Second}), tp.Setup[0]; !reflect.DeepEqual(e, g) {
+		t.Errorf("expecting setup step %+v, got %+v", e, g)
+	}
+	if s := tp.Teardown[0]; !s.Delete || !strings.Contains(s.Manifests, "name: debug") {
+		t.Errorf("unexpected teardown step %+v", s)
+	}
+
+	if _, err := parse("  setup:\n  - name: empty"); !errors.Is(err, errEmptyStep) {
+		t.Fatalf("expecting error %v, got %v", errEmptyStep, err)
+	}
+}
+
+func TestParseTestPlan_Destination(t *testing.T) {
+	parse := func(destination, endpoint string) (*TestPlan, error) {
+		return ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: destination
+  testTargets:
+  - name: shop to billing
+    namespace: shop
+` + destination + `
+    tests:
+    - name: blocked
+      type: tcp
+      endpoint: "` + endpoint + `"
+      expectFail: true
+`))
+	}
+
+	const dst = `    destination:
+      namespace: billing
+      labels:
+        app: billing
+      ports: [8080, 9090]
+      service: true`
+
+	t.Run("valid", func(t *testing.T) {
+		for _, endpoint := range []string{"", ":9090", "billing.billing:8080"} {
+			tp, err := parse(dst, endpoint)
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", endpoint, err)
+			}
+
+			exp := Destination{Namespace: "billing", Labels: map[string]string{"app": "billing"}, Ports: []int32{8080, 9090}, Service: true}
+			if got := tp.TestTargets[0].Destination; got == nil || !reflect.DeepEqual(exp, *got) {
+				t.Fatalf("expecting destination %+v, got %+v", exp, got)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]struct {
+			destination, endpoint string
+			err                   error
+		}{
+			"no destination":        {"", "", errNoDestination},
+			"no destination port":   {"", ":8080", errNoDestination},
+			"no ports":              {"    destination:\n      namespace: billing", "", errNoDestinationPorts},
+			"invalid port":          {"    destination:\n      ports: [0]", "", errInvalidDestinationPort},
+			"unknown port":          {dst, ":7070", errUnknownDestinationPort},
+			"conflicting exposure":  {"    destination:\n      ports: [8080]\n      loadBalancer: true\n      export: true", "", errConflictingExposure},
+			"existing kind":         {"    destination:\n      ports: [8080]\n      existing: deployment/billing", "", errInvalidExisting},
+			"existing labels":       {"    destination:\n      ports: [8080]\n      existing: pod/billing-0\n      labels:\n        app: billing", "", errExistingEchoServer},
+			"existing pod exposure": {"    destination:\n      ports: [8080]\n      existing: pod/billing-0\n      export: true", "", errExistingPodExposure},
+		}
+
+		for n, tt := range tests {
+			t.Run(n, func(t *testing.T) {
+				if _, err := parse(tt.destination, tt.endpoint); !errors.Is(err, tt.err) {
+					t.Fatalf("expecting error %v, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+
+	t.Run("dns", func(t *testing.T) {
+		for exposure, valid := range map[string]bool{
+			"":                          false,
+			"service: true":             true,
+			"export: true":              true,
+			"loadBalancer: true":        false,
+			"existing: pod/billing-0":   false,
+			"existing: service/billing": true,
+			"existing: service/billing\n      loadBalancer: true": false,
+		} {
+			_, err := ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: destination
+  testTargets:
+  - name: shop to billing
+    destination:
+      ports: [8080]
+      ` + exposure + `
+    tests:
+    - name: resolves
+      type: dns
+      endpoint: ""
+`))
+			if valid && err != nil {
+				t.Errorf("%q: unexpected error: %v", exposure, err)
+			}
+			if !valid && !errors.Is(err, errDNSDestination) {
+				t.Errorf("%q: expecting error %v, got %v", exposure, errDNSDestination, err)
+			}
+		}
+	})
+}
+
+func TestParseTestPlan_Attempts(t *testing.T) {
+	parse := func(attempts string) (*TestPlan, error) {
+		return ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: attempts
+  testTargets:
+  - name: frontend
+    tests:
+    - name: cart
+      type: tcp
+      endpoint: cart:8080
+      timeout: 2s
+` + attempts + `
+`))
+	}
+
+	tp, err := parse("      retries: 2\n      retryInterval: 500ms\n      repeat: 10\n      minSuccessRate: 0.9")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	test := tp.TestTargets[0].Tests[0]
+	if test.Retries != 2 || test.RetryInterval != 500*time.Millisecond || test.Repeat != 10 || test.MinSuccessRate != 0.9 {
+		t.Errorf("unexpected test %+v", test)
+	}
+
+	tests := map[string]struct {
+		attempts string
+		err      error
+	}{
+		"negative retries":      {"      retries: -1", errInvalidAttempts},
+		"negative interval":     {"      retries: 1\n      retryInterval: -1s", errInvalidAttempts},
+		"negative repeat":       {"      repeat: -3", errInvalidAttempts},
+		"success rate too high": {"      repeat: 3\n      minSuccessRate: 1.5", errInvalidSuccessRate},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			if _, err := parse(tt.attempts); !errors.Is(err, tt.err) {
+				t.Fatalf("expecting error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseTestPlan_Defaults(t *testing.T) {
+	tp, err := ParseTestPlan(strings.NewReader(`
+testPlan:
+  name: defaults
+  defaults:
+    timeout: 3s
+    type: tcp
+    namespace: shop
+  testTargets:
+  - name: frontend
+    destination:
+      ports: [8080]
+    tests:
+    - name: inherits
+      endpoint: cart:8080
+    - name: overrides
+      endpoint: http://cart:8080
+      type: http
+      timeout: 10s
+  - name: coredns
+    namespace: kube-system
+    defaults:
+      type: dns
+      probeImage: probe:debug
+    tests:
+    - name: target defaults
+      endpoint: grafana.com
+  - name: all
+    allNamespaces: true
+    tests: []
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	frontend, coredns, all := tp.TestTargets[0], tp.TestTargets[1], tp.TestTargets[2]
+
+	if frontend.Namespace != "shop" || frontend.Destination.Namespace != "shop" {
+		t.Errorf("expecting target and destination in shop, got %q and %q", frontend.Namespace, frontend.Destination.Namespace)
+	}
+	if coredns.Namespace != "kube-system" || all.Namespace != "" {
+		t.Errorf("expecting targets selecting a namespace to keep it, got %q and %q", coredns.Namespace, all.Namespace)
+	}
+
+	exp := []Test{
+		{Name: "inherits", Endpoint: "cart:8080", Type: TestTypeTCP, Timeout: 3 * time.Second},
+		{Name: "overrides", Endpoint: "http://cart:8080", Type: TestTypeHTTP, Timeout: 10 * time.Second},
+		{Name: "target defaults", Endpoint: "grafana.com", Type: TestTypeDNS, Timeout: 3 * time.Second, ProbeImage: "probe:debug"},
+	}
+	got := append(slices.Clone(frontend.Tests), coredns.Tests...)
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("expecting tests\n%+v\ngot\n%+v", exp, got)
+	}
+}
+
+func TestReadTestPlans(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	plan := func(name string, targets ...string) string {
+		var b strings.Builder
+		b.WriteString("testPlan:\n  name: " + name + "\n  testTargets:\n")
+		for _, target := range targets {
+			b.WriteString("  - name: " + target + "\n    tests: []\n")
+		}
+		return b.String()
+	}
+
+	main := write("main.yaml", plan("main", "frontend")+`
+  defaults:
+    timeout: 3s
+  include: [common.yaml, teams]
+  setup:
+  - name: main policies
+    files: [policies.yaml]
+`)
+	write("common.yaml", plan("common", "coredns")+`
+  setup:
+  - name: common policies
+    files: [policies/common.yaml]
+`)
+	write("teams/billing.yaml", plan("billing", "billing"))
+	write("teams/shop.yml", plan("shop", "shop"))
+	write("teams/README.md", "not a plan")
+	other := write("other.yaml", plan("other", "ads")+"  clusters: [prod]\n")
+
+	targets := func(plan *TestPlan) []string {
+		var res []string
+		for _, t := range plan.TestTargets {
+			res = append(res, t.Name)
+		}
+		return res
+	}
+
+	t.Run("include", func(t *testing.T) {
+		tp, err := ReadTestPlan(main)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tp.Name != "main" || tp.Dir != dir {
+			t.Errorf("expecting plan main in %s, got %s in %s", dir, tp.Name, tp.Dir)
+		}
+		if exp, g := []string{"frontend", "coredns", "billing", "shop"}, targets(tp); !slices.Equal(exp, g) {
+			t.Errorf("expecting targets %q, got %q", exp, g)
+		}
+
+		exp := [][]string{{"policies.yaml"}, {filepath.Join(dir, "policies/common.yaml")}}
+		for i, e := range exp {
+			if g := tp.Setup[i].Files; !slices.Equal(e, g) {
+				t.Errorf("expecting files of step %d %q, got %q", i, e, g)
+			}
+		}
+	})
+
+	t.Run("multiple files", func(t *testing.T) {
+		tp, err := new(planReader).read(t.Context(), []string{filepath.Join(dir, "teams"), other})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tp.Name != "billing, shop, other" {
+			t.Errorf("expecting merged plan name, got %s", tp.Name)
+		}
+		if exp, g := []string{"billing", "shop", "ads"}, targets(tp); !slices.Equal(exp, g) {
+			t.Errorf("expecting targets %q, got %q", exp, g)
+		}
+		if !slices.Equal([]string{"prod"}, tp.Clusters) {
+			t.Errorf("expecting clusters [prod], got %q", tp.Clusters)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cycle := write("cycle/a.yaml", plan("a")+"  include: [b.yaml]\n")
+		write("cycle/b.yaml", plan("b")+"  include: [a.yaml]\n")
+		empty := filepath.Join(dir, "empty")
+		if err := os.Mkdir(empty, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		for path, exp := range map[string]error{
+			cycle:                           errIncludeCycle,
+			empty:                           errNoPlanFiles,
+			filepath.Join(dir, "nope.yaml"): os.ErrNotExist,
+			write("bad.yaml", plan("bad")+"  include: [nope.yaml]\n"): os.ErrNotExist,
+		} {
+			if _, err := ReadTestPlan(path); !errors.Is(err, exp) {
+				t.Errorf("%s: expecting error %v, got %v", path, exp, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pf "github.com/grafana/nethax/pkg/probeflags"
+	"github.com/grafana/nethax/pkg/tracing"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/grafana/nethax/cmd/nethax")
+
+// tracingShutdownTimeout is how long to wait for the pending spans to
+// be exported before exiting.
+const tracingShutdownTimeout = 5 * time.Second
+
+// addTracingFlags adds the flags configuring tracing to cmd.
+func addTracingFlags(cmd *cobra.Command, endpoint *string) {
+	cmd.Flags().StringVar(endpoint, "otlp-endpoint", "", "OTLP HTTP endpoint to export traces to, e.g. http://otel-collector:4318. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable, tracing is disabled if neither is set.")
+}
+
+// setupTracing sets up tracing for cmd. The returned function must be
+// called before exiting, so the spans are exported.
+func setupTracing(cmd *cobra.Command, endpoint string) (func(), error) {
+	shutdown, err := tracing.Setup(cmd.Context(), endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("setting up tracing: %w", err)
+	}
+
+	return func() {
+		// export the spans even if the run was cancelled
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(cmd.Context()), tracingShutdownTimeout)
+		defer cancel()
+
+		if err := shutdown(ctx); err != nil {
+			cmd.Printf("Warning: exporting traces: %v\n", err)
+		}
+	}, nil
+}
+
+// traceProbe adds a span for the run of a probe, with a child span for
+// each of its phases, using the timings the probe printed in its logs.
+// As the timings are measured by the probe, they are subject to clock
+// skew between the node and the runner.
+func traceProbe(ctx context.Context, logs string) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return
+	}
+
+	t, ok := pf.ParseTimings(logs)
+	if !ok {
+		return
+	}
+
+	ctx, span := tracer.Start(ctx, "probe", trace.WithTimestamp(t.Start))
+	for _, p := range t.Phases {
+		_, ps := tracer.Start(ctx, p.Name,
+			trace.WithTimestamp(p.Start),
+			trace.WithAttributes(attribute.String("nethax.probe.address", p.Addr)),
+		)
+		ps.End(trace.WithTimestamp(p.End))
+	}
+	span.End(trace.WithTimestamp(t.End))
+}
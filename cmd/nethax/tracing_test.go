@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRunTests_Tracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { tp.Shutdown(context.Background()) }) //nolint:errcheck
+
+	tests := []Test{
+		{Name: "pass", Endpoint: "http://shop", Type: TestTypeHTTP},
+		{Name: "fail", Endpoint: "cart:80", Type: TestTypeTCP},
+		{Name: "error", Endpoint: "cart:80", Type: TestTypeTCP},
+	}
+
+	// the probes are told apart by the number of tests already traced
+	probe := func(ctx context.Context, probeImage string, command, args []string, timeout time.Duration) (int32, string, error) {
+		switch len(rec.Ended()) {
+		case 0:
+			return 0, "", nil
+		case 1:
+			return 1, "", nil
+		}
+		return -1, "", errors.New("probe failed")
+	}
+
+	ctx, parent := tracer.Start(context.Background(), "target")
+	runTests(ctx, TestResult{Target: "frontend", Node: "node-1"}, tests, probe)
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("expecting 4 spans, got %d", len(spans))
+	}
+
+	exp := []struct {
+		test     string
+		status   codes.Code
+		exitCode int64
+	}{
+		{"pass", codes.Unset, 0},
+		{"fail", codes.Error, 1},
+		{"error", codes.Error, -1},
+	}
+
+	for i, e := range exp {
+		s := spans[i]
+
+		t.Run(e.test, func(t *testing.T) {
+			if s.Name() != "test" {
+				t.Errorf("expecting span test, got %s", s.Name())
+			}
+			if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Error("expecting span to be a child of the target span")
+			}
+			if s.Status().Code != e.status {
+				t.Errorf("expecting status %v, got %v", e.status, s.Status().Code)
+			}
+
+			attrs := attribute.NewSet(s.Attributes()...)
+			if v, _ := attrs.Value("nethax.test"); v.AsString() != e.test {
+				t.Errorf("expecting test %s, got %s", e.test, v.AsString())
+			}
+			if v, _ := attrs.Value("nethax.source"); v.AsString() != "node/node-1" {
+				t.Errorf("expecting source node/node-1, got %s", v.AsString())
+			}
+
+			v, ok := attrs.Value("nethax.exit_code")
+			if e.exitCode < 0 {
+				if ok {
+					t.Errorf("expecting no exit code, got %d", v.AsInt64())
+				}
+				if len(s.Events()) != 1 || s.Events()[0].Name != "exception" {
+					t.Errorf("expecting error to be recorded, got %v", s.Events())
+				}
+			} else if v.AsInt64() != e.exitCode {
+				t.Errorf("expecting exit code %d, got %d", e.exitCode, v.AsInt64())
+			}
+		})
+	}
+}
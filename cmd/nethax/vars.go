@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// varsEnvPrefix prefixes the names of the environment variables
+// overriding the variables of plans, e.g. NETHAX_VAR_DOMAIN for domain.
+const varsEnvPrefix = "NETHAX_VAR_"
+
+var (
+	// varRef matches the references to variables in plans, ${name},
+	// and escaped dollar signs, $$.
+	varRef  = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+	varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+var (
+	errUndefinedVar   = errors.New("undefined variable")
+	errInvalidVarName = errors.New("invalid variable name")
+	errInvalidVarFlag = errors.New("expecting name=value")
+)
+
+// addVarsFlag adds the flag overriding the variables of plans to cmd.
+func addVarsFlag(cmd *cobra.Command, set *[]string) {
+	cmd.Flags().StringArrayVar(set, "set", nil, "Set a variable of the test plan, as name=value, overriding its default and environment variable. Can be repeated.")
+}
+
+// parseVarFlags returns the variables set with the given --set flags.
+func parseVarFlags(set []string) (map[string]string, error) {
+	vars := make(map[string]string, len(set))
+	for _, s := range set {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("--set %q: %w", s, errInvalidVarFlag)
+		}
+		if !varName.MatchString(name) {
+			return nil, fmt.Errorf("--set %q: %w", s, errInvalidVarName)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// expandVars replaces the references to variables in s with their
+// value: the one in overrides, or else in the environment, or else in
+// vars, the defaults of the plan.
+func expandVars(s string, vars, overrides map[string]string) (string, error) {
+	var err error
+	res := varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+
+		name := ref[2 : len(ref)-1]
+		if v, ok := overrides[name]; ok {
+			return v
+		}
+		if v, ok := os.LookupEnv(varsEnvPrefix + strings.ToUpper(name)); ok {
+			return v
+		}
+		if v, ok := vars[name]; ok {
+			return v
+		}
+
+		err = errors.Join(err, fmt.Errorf("%w %q", errUndefinedVar, name))
+		return ref
+	})
+
+	return res, err
+}
+
+// expandVars expands the references to variables in the endpoints,
+// namespaces and selectors of p, once decoded, so that values are never
+// parsed as YAML. Overrides take precedence over the environment and
+// the defaults of p.
+func (p *TestPlan) expandVars(overrides map[string]string) error {
+	for name := range p.Vars {
+		if !varName.MatchString(name) {
+			return fmt.Errorf("%w %q", errInvalidVarName, name)
+		}
+	}
+
+	var errs []error
+	expand := func(s *string) {
+		v, err := expandVars(*s, p.Vars, overrides)
+		*s = v
+		if err != nil && !slices.ContainsFunc(errs, func(e error) bool { return e.Error() == err.Error() }) {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.Defaults != nil {
+		expand(&p.Defaults.Namespace)
+	}
+
+	for i := range p.TestTargets {
+		t := &p.TestTargets[i]
+
+		expand(&t.Namespace)
+		expand(&t.NamespaceSelector)
+		expand(&t.PodSelector.Labels)
+		expand(&t.PodSelector.Fields)
+		if t.PodSelector.Workload != nil {
+			expand(&t.PodSelector.Workload.Name)
+		}
+		if t.NodeSelector != nil {
+			expand(&t.NodeSelector.Labels)
+		}
+		if t.Destination != nil {
+			expand(&t.Destination.Namespace)
+		}
+
+		for j := range t.Tests {
+			expand(&t.Tests[j].Endpoint)
+		}
+	}
+
+	return errors.Join(errs...)
+}
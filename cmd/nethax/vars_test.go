@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	t.Setenv("NETHAX_VAR_ENV", "from env")
+	t.Setenv("NETHAX_VAR_OVERRIDDEN", "from env")
+
+	vars := map[string]string{"domain": "svc.cluster.local", "env": "default", "overridden": "default"}
+	overrides := map[string]string{"overridden": "from flag", "extra": "from flag"}
+
+	tests := map[string]struct {
+		in, exp string
+	}{
+		"default":      {"http://cart.${domain}:8080", "http://cart.svc.cluster.local:8080"},
+		"environment":  {"${env}", "from env"},
+		"overrides":    {"${overridden}", "from flag"},
+		"not declared": {"${extra}", "from flag"},
+		"several":      {"${domain}/${domain}", "svc.cluster.local/svc.cluster.local"},
+		"escaped":      {"$${domain} costs $$5", "${domain} costs $5"},
+		"no reference": {"$domain $", "$domain $"},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			got, err := expandVars(tt.in, vars, overrides)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.exp != got {
+				t.Errorf("expecting %q, got %q", tt.exp, got)
+			}
+		})
+	}
+
+	t.Run("undefined", func(t *testing.T) {
+		_, err := expandVars("${nope} ${domain} ${}", vars, nil)
+		if !errors.Is(err, errUndefinedVar) || !strings.Contains(err.Error(), `"nope"`) {
+			t.Errorf("expecting error %v, got %v", errUndefinedVar, err)
+		}
+	})
+}
+
+func TestParseVarFlags(t *testing.T) {
+	got, err := parseVarFlags([]string{"domain=prod.example.com", "labels=app=cart,tier=web", "empty="})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := map[string]string{"domain": "prod.example.com", "labels": "app=cart,tier=web", "empty": ""}
+	if !maps.Equal(exp, got) {
+		t.Errorf("expecting %v, got %v", exp, got)
+	}
+
+	for set, err := range map[string]error{
+		"domain":     errInvalidVarFlag,
+		"my-var=foo": errInvalidVarName,
+		"=foo":       errInvalidVarName,
+	} {
+		if _, e := parseVarFlags([]string{set}); !errors.Is(e, err) {
+			t.Errorf("%q: expecting error %v, got %v", set, err, e)
+		}
+	}
+}
+
+func TestParseTestPlan_Vars(t *testing.T) {
+	const plan = `
+testPlan:
+  name: shop
+  vars:
+    env: dev
+    namespace: shop-dev
+    suffix: dev.example.com
+  testTargets:
+  - name: frontend
+    namespace: ${namespace}
+    podSelector:
+      mode: all
+      labels: "app=frontend,env=${env}"
+    tests:
+    - name: cart
+      endpoint: http://cart.${suffix}/health
+      timeout: 3s
+`
+
+	tp, err := parseTestPlan(strings.NewReader(plan), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target := tp.TestTargets[0]
+	if target.Namespace != "shop-dev" || target.PodSelector.Labels != "app=frontend,env=dev" || target.Tests[0].Endpoint != "http://cart.dev.example.com/health" {
+		t.Errorf("unexpected plan %+v", tp)
+	}
+
+	t.Setenv("NETHAX_VAR_NAMESPACE", "shop-prod")
+
+	tp, err = parseTestPlan(strings.NewReader(plan), map[string]string{"env": "prod", "suffix": "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target = tp.TestTargets[0]
+	if target.Namespace != "shop-prod" || target.PodSelector.Labels != "app=frontend,env=prod" || target.Tests[0].Endpoint != "http://cart.example.com/health" {
+		t.Errorf("unexpected plan %+v", tp)
+	}
+}
+
+func TestParseTestPlan_VarsValues(t *testing.T) {
+	const plan = `
+testPlan:
+  name: shop ${env} # not expanded
+  vars:
+    namespace: shop
+  setup:
+  - name: config
+    manifests: |
+      kind: ConfigMap
+      data:
+        script: echo ${HOME}
+  testTargets:
+  # ${commented} out
+  - name: frontend
+    namespace: ${namespace}
+    podSelector:
+      mode: all
+      labels: "app=frontend"
+    tests:
+    - name: cart
+      type: tcp
+      endpoint: ${endpoint}
+      timeout: 3s
+`
+
+	// values are never parsed as YAML, whatever they hold
+	endpoint := "cart:80\n      expectFail: true # \"quoted\""
+	tp, err := parseTestPlan(strings.NewReader(plan), map[string]string{"endpoint": endpoint})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	test := tp.TestTargets[0].Tests[0]
+	if test.Endpoint != endpoint || test.ExpectFail {
+		t.Errorf("expecting the endpoint to be the value, got %+v", test)
+	}
+	if tp.Name != "shop ${env}" || !strings.Contains(tp.Setup[0].Manifests, "${HOME}") {
+		t.Errorf("expecting the name and manifests to be kept, got %q and %q", tp.Name, tp.Setup[0].Manifests)
+	}
+
+	t.Run("invalid name", func(t *testing.T) {
+		const plan = "testPlan:\n  vars:\n    my-var: foo\n  testTargets: []\n"
+		if _, err := parseTestPlan(strings.NewReader(plan), nil); !errors.Is(err, errInvalidVarName) {
+			t.Errorf("expecting error %v, got %v", errInvalidVarName, err)
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		_, err := parseTestPlan(strings.NewReader(plan), nil)
+		if !errors.Is(err, errUndefinedVar) || !strings.Contains(err.Error(), `"endpoint"`) {
+			t.Errorf("expecting error %v, got %v", errUndefinedVar, err)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package kubernetes
+
+import "sync"
+
+// clusters holds the clients of the kubeconfig contexts used by a run,
+// so each is only created once.
+type clusters struct {
+	mu      sync.Mutex
+	clients map[string]*Kubernetes
+}
+
+func newClusters(k *Kubernetes) *clusters {
+	return &clusters{
+		clients: map[string]*Kubernetes{k.context: k},
+	}
+}
+
+// Context returns the kubeconfig context k is connected to, blank for
+// the in-cluster API or the current context.
+func (k *Kubernetes) Context() string {
+	return k.context
+}
+
+// Cluster returns the client of the given kubeconfig context, creating
+// it on first use. The clients it returns share the clusters of k, so
+// any of them can be used to reach the others. It returns k if name is
+// blank.
+func (k *Kubernetes) Cluster(name string) (*Kubernetes, error) {
+	if name == "" || name == k.context {
+		return k, nil
+	}
+
+	k.clusters.mu.Lock()
+	defer k.clusters.mu.Unlock()
+
+	if c, ok := k.clusters.clients[name]; ok {
+		return c, nil
+	}
+
+	c, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+	c.clusters = k.clusters
+	k.clusters.clients[name] = c
+
+	return c, nil
+}
+
+// AddCluster makes c the client of the kubeconfig context name for the
+// clients sharing the clusters of k, e.g. a fake client in tests.
+func (k *Kubernetes) AddCluster(name string, c *Kubernetes) {
+	k.clusters.mu.Lock()
+	defer k.clusters.mu.Unlock()
+
+	c.context = name
+	c.clusters = k.clusters
+	k.clusters.clients[name] = c
+}
@@ -0,0 +1,34 @@
+package kubernetes
+
+import (
+	"testing"
+
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCluster(t *testing.T) {
+	k := NewWithClient(testClient.NewClientset())
+	remote := NewWithClient(testClient.NewClientset())
+
+	if c, err := k.Cluster(""); err != nil || c != k {
+		t.Fatalf("expecting blank context to return the client, got %v, %v", c, err)
+	}
+
+	k.AddCluster("remote", remote)
+
+	c, err := k.Cluster("remote")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c != remote || c.Context() != "remote" {
+		t.Fatalf("expecting the client of the remote context, got %v", c)
+	}
+
+	// clients share their clusters
+	if c, err := remote.Cluster("remote"); err != nil || c != remote {
+		t.Errorf("expecting own context to return the client, got %v, %v", c, err)
+	}
+	if c, err := remote.Cluster(""); err != nil || c != remote {
+		t.Errorf("expecting blank context to return the client, got %v, %v", c, err)
+	}
+}
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EventSource is the component events are reported by.
+const EventSource = "nethax"
+
+// maxEventMessage is the longest message the API server accepts for an
+// event.
+const maxEventMessage = 1024
+
+// RecordPodEvent records an event about pod, so it is shown by kubectl
+// describe. The type of the event is either corev1.EventTypeNormal or
+// corev1.EventTypeWarning.
+func (k *Kubernetes) RecordPodEvent(ctx context.Context, pod *corev1.Pod, eventType, reason, message string) error {
+	message = truncateMessage(message)
+
+	now := metav1.Now()
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// same naming as client-go event recorders
+			Name:      fmt.Sprintf("%v.%x", pod.Name, time.Now().UnixNano()),
+			Namespace: pod.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Pod",
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Type:                eventType,
+		Reason:              reason,
+		Message:             message,
+		Source:              corev1.EventSource{Component: EventSource},
+		ReportingController: EventSource,
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+	}
+
+	_, err := k.client.CoreV1().Events(pod.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("recording event on pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return nil
+}
+
+// truncateMessage shortens message to maxEventMessage bytes, ending it
+// with "..." and without splitting a UTF-8 character.
+func truncateMessage(message string) string {
+	if len(message) <= maxEventMessage {
+		return message
+	}
+
+	n := maxEventMessage - len("...")
+	for n > 0 && !utf8.RuneStart(message[n]) {
+		n--
+	}
+
+	return message[:n] + "..."
+}
+
+// AnnotatePod sets the given annotations on pod, leaving the others
+// untouched. Annotations with a nil value are removed.
+func (k *Kubernetes) AnnotatePod(ctx context.Context, pod *corev1.Pod, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+	})
+	if err != nil {
+		return fmt.Errorf("creating annotations patch: %w", err)
+	}
+
+	_, err = k.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("annotating pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testClient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordPodEvent(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"}}
+
+	for n, tt := range map[string]struct {
+		message, expected string
+	}{
+		"short": {
+			"Passed: frontend to cart",
+			"Passed: frontend to cart",
+		},
+		"longest": {
+			strings.Repeat("a", maxEventMessage),
+			strings.Repeat("a", maxEventMessage),
+		},
+		"too long": {
+			strings.Repeat("a", maxEventMessage+1),
+			strings.Repeat("a", maxEventMessage-3) + "...",
+		},
+		"multi-byte character at the boundary": {
+			strings.Repeat("a", maxEventMessage-4) + "→ failed",
+			strings.Repeat("a", maxEventMessage-4) + "...",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			client := testClient.NewClientset()
+			k := NewWithClient(client)
+
+			if err := k.RecordPodEvent(t.Context(), pod, corev1.EventTypeNormal, "Passed", tt.message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			events, err := client.CoreV1().Events("shop").List(t.Context(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(events.Items) != 1 {
+				t.Fatalf("expecting 1 event, got %d", len(events.Items))
+			}
+
+			e := events.Items[0]
+			if e.InvolvedObject.Name != "cart" || e.Source.Component != EventSource {
+				t.Errorf("expecting event about cart from %s, got %+v", EventSource, e)
+			}
+			if e.Message != tt.expected {
+				t.Errorf("expecting message %q, got %q", tt.expected, e.Message)
+			}
+			if len(e.Message) > maxEventMessage || !utf8.ValidString(e.Message) {
+				t.Errorf("expecting a valid message of at most %d bytes, got %d bytes", maxEventMessage, len(e.Message))
+			}
+		})
+	}
+}
@@ -5,15 +5,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/grafana/nethax/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -35,15 +54,52 @@ func New(context string) (*Kubernetes, error) {
 		return nil, fmt.Errorf("creating Kubernetes client: %w", err)
 	}
 
-	return &Kubernetes{
-		client: client,
-	}, nil
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating Kubernetes dynamic client: %w", err)
+	}
+
+	// discovery is deferred and cached, and reset when a kind is not
+	// found, e.g. after a CRD is applied
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery()))
+
+	k := &Kubernetes{
+		client:  client,
+		dynamic: dyn,
+		mapper:  mapper,
+		context: context,
+	}
+	k.clusters = newClusters(k)
+
+	return k, nil
+}
+
+// NewWithClient returns a new Kubernetes object using the given
+// client, e.g. a fake clientset in tests.
+func NewWithClient(client kubernetes.Interface) *Kubernetes {
+	return NewWithClients(client, nil, nil)
+}
+
+// NewWithClients returns a new Kubernetes object using the given
+// clients, e.g. fake clients in tests. The dynamic client is used for
+// custom resources and for applying manifests, whose resources are
+// found with the mapper.
+func NewWithClients(client kubernetes.Interface, dyn dynamic.Interface, mapper meta.RESTMapper) *Kubernetes {
+	k := &Kubernetes{
+		client:  client,
+		dynamic: dyn,
+		mapper:  mapper,
+	}
+	k.clusters = newClusters(k)
+
+	return k
 }
 
 func getClusterConfig(kontext string) (*rest.Config, error) {
-	// attempt to use config from pod service account
+	// attempt to use config from pod service account, unless a
+	// context is requested, e.g. to reach other clusters
 	cfg, err := rest.InClusterConfig()
-	if err != nil {
+	if kontext != "" || err != nil {
 		// Can be overridden by KUBECONFIG variable
 		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 		configOverride := &clientcmd.ConfigOverrides{
@@ -62,8 +118,25 @@ func getClusterConfig(kontext string) (*rest.Config, error) {
 	return cfg, nil
 }
 
+var tracer = otel.Tracer("github.com/grafana/nethax/pkg/kubernetes")
+
+// podAttributes returns the tracing attributes identifying pod.
+func podAttributes(pod *corev1.Pod) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("k8s.namespace.name", pod.Namespace),
+		attribute.String("k8s.pod.name", pod.Name),
+	}
+}
+
 type Kubernetes struct {
-	client kubernetes.Interface
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+
+	// context is the kubeconfig context of the client, and clusters
+	// the clients of the other contexts of a run.
+	context  string
+	clusters *clusters
 }
 
 var (
@@ -86,6 +159,232 @@ func (k *Kubernetes) GetPods(ctx context.Context, namespace, labels, fields stri
 	return pods.Items, nil
 }
 
+var errNoNamespacesFound = errors.New("no namespaces found")
+
+// GetNamespaces returns the names of the namespaces matching the given
+// labels selector, sorted alphabetically. A blank selector matches all
+// namespaces.
+func (k *Kubernetes) GetNamespaces(ctx context.Context, labels string) ([]string, error) {
+	nss, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: labels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces for labels %q: %w", labels, err)
+	}
+
+	if len(nss.Items) == 0 {
+		return nil, fmt.Errorf("%w: labels %q", errNoNamespacesFound, labels)
+	}
+
+	names := make([]string, len(nss.Items))
+	for i, ns := range nss.Items {
+		names[i] = ns.Name
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// ListNamespaces returns all the namespaces in the cluster.
+func (k *Kubernetes) ListNamespaces(ctx context.Context) ([]corev1.Namespace, error) {
+	nss, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces: %w", err)
+	}
+
+	return nss.Items, nil
+}
+
+// ListPods returns all the pods in namespace, or in all namespaces if
+// blank.
+func (k *Kubernetes) ListPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods for namespace %s: %w", namespace, err)
+	}
+
+	return pods.Items, nil
+}
+
+// ListServices returns all the services in namespace, or in all
+// namespaces if blank.
+func (k *Kubernetes) ListServices(ctx context.Context, namespace string) ([]corev1.Service, error) {
+	svcs, err := k.client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing services for namespace %s: %w", namespace, err)
+	}
+
+	return svcs.Items, nil
+}
+
+// ListNetworkPolicies returns all the network policies in namespace,
+// or in all namespaces if blank.
+func (k *Kubernetes) ListNetworkPolicies(ctx context.Context, namespace string) ([]networkingv1.NetworkPolicy, error) {
+	nps, err := k.client.NetworkingV1().NetworkPolicies(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing network policies for namespace %s: %w", namespace, err)
+	}
+
+	return nps.Items, nil
+}
+
+// ListResources returns all the objects of the given resource in
+// namespace, or in all namespaces if blank. It returns a NotFound error
+// if the resource is not served by the cluster, e.g. a CRD that is not
+// installed, and no objects if there is no dynamic client.
+func (k *Kubernetes) ListResources(ctx context.Context, gvr schema.GroupVersionResource, namespace string) ([]unstructured.Unstructured, error) {
+	if k.dynamic == nil {
+		return nil, nil
+	}
+
+	list, err := k.dynamic.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing %s for namespace %s: %w", gvr.GroupResource(), namespace, err)
+	}
+
+	return list.Items, nil
+}
+
+// UpdateResourceStatus updates the status subresource of obj, an
+// object of the given resource, returning the updated object.
+func (k *Kubernetes) UpdateResourceStatus(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if k.dynamic == nil {
+		return nil, errNoDynamicClient
+	}
+
+	res, err := k.dynamic.Resource(gvr).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("updating status of %s %s/%s: %w", gvr.GroupResource(), obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	return res, nil
+}
+
+// WorkloadKind is the kind of a workload resource owning pods.
+type WorkloadKind string
+
+const (
+	WorkloadKindDeployment  WorkloadKind = "Deployment"
+	WorkloadKindStatefulSet WorkloadKind = "StatefulSet"
+	WorkloadKindDaemonSet   WorkloadKind = "DaemonSet"
+	WorkloadKindJob         WorkloadKind = "Job"
+)
+
+var (
+	errInvalidWorkloadKind = errors.New("invalid workload kind")
+)
+
+// ParseWorkloadKind returns the WorkloadKind matching s, ignoring
+// case.
+func ParseWorkloadKind(s string) (WorkloadKind, error) {
+	for _, k := range []WorkloadKind{WorkloadKindDeployment, WorkloadKindStatefulSet, WorkloadKindDaemonSet, WorkloadKindJob} {
+		if strings.EqualFold(s, string(k)) {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", errInvalidWorkloadKind, s)
+}
+
+// GetWorkloadPods returns the pods owned by the workload of the given
+// kind and name. Pods are first listed using the workload's own
+// selector, optionally narrowed by the extra labels and fields
+// selectors, and then filtered by their ownerReferences so that pods
+// that only happen to share labels with the workload are skipped.
+func (k *Kubernetes) GetWorkloadPods(ctx context.Context, namespace string, kind WorkloadKind, name, extraLabels, fields string) ([]corev1.Pod, error) {
+	selector, owners, err := k.workloadOwners(ctx, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s %s/%s selector: %w", kind, namespace, name, err)
+	}
+	if extraLabels != "" {
+		extra, err := labels.Parse(extraLabels)
+		if err != nil {
+			return nil, fmt.Errorf("parsing labels %q: %w", extraLabels, err)
+		}
+		reqs, _ := extra.Requirements()
+		sel = sel.Add(reqs...)
+	}
+
+	pods, err := k.GetPods(ctx, namespace, sel.String(), fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []corev1.Pod
+	for _, pod := range pods {
+		if ref := metav1.GetControllerOf(&pod); ref != nil && owners[ref.UID] {
+			owned = append(owned, pod)
+		}
+	}
+
+	if len(owned) == 0 {
+		return nil, fmt.Errorf("%w: owned by %s %s/%s", errNoPodsFound, kind, namespace, name)
+	}
+
+	return owned, nil
+}
+
+// workloadOwners returns the pod selector of the given workload, and
+// the set of UIDs that are valid controller owners for its pods.
+func (k *Kubernetes) workloadOwners(ctx context.Context, namespace string, kind WorkloadKind, name string) (*metav1.LabelSelector, map[types.UID]bool, error) {
+	apps := k.client.AppsV1()
+	opts := metav1.GetOptions{}
+
+	switch kind {
+	case WorkloadKindDeployment:
+		d, err := apps.Deployments(namespace).Get(ctx, name, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting deployment %s/%s: %w", namespace, name, err)
+		}
+
+		// Deployment pods are owned by ReplicaSets, which in turn are
+		// owned by the Deployment.
+		sel, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing deployment %s/%s selector: %w", namespace, name, err)
+		}
+		rss, err := apps.ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+		if err != nil {
+			return nil, nil, fmt.Errorf("listing replicasets for deployment %s/%s: %w", namespace, name, err)
+		}
+		owners := make(map[types.UID]bool)
+		for _, rs := range rss.Items {
+			if ref := metav1.GetControllerOf(&rs); ref != nil && ref.UID == d.UID {
+				owners[rs.UID] = true
+			}
+		}
+		return d.Spec.Selector, owners, nil
+
+	case WorkloadKindStatefulSet:
+		s, err := apps.StatefulSets(namespace).Get(ctx, name, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting statefulset %s/%s: %w", namespace, name, err)
+		}
+		return s.Spec.Selector, map[types.UID]bool{s.UID: true}, nil
+
+	case WorkloadKindDaemonSet:
+		d, err := apps.DaemonSets(namespace).Get(ctx, name, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting daemonset %s/%s: %w", namespace, name, err)
+		}
+		return d.Spec.Selector, map[types.UID]bool{d.UID: true}, nil
+
+	case WorkloadKindJob:
+		j, err := k.client.BatchV1().Jobs(namespace).Get(ctx, name, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting job %s/%s: %w", namespace, name, err)
+		}
+		return j.Spec.Selector, map[types.UID]bool{j.UID: true}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", errInvalidWorkloadKind, kind)
+	}
+}
+
 func GetProbeImage(probeImage string) string {
 	// Use the provided probe image, or default if empty
 	if probeImage == "" {
@@ -94,7 +393,13 @@ func GetProbeImage(probeImage string) string {
 	return probeImage
 }
 
-func (k *Kubernetes) LaunchEphemeralContainer(ctx context.Context, pod *corev1.Pod, probeImage string, command []string, args []string) (*corev1.Pod, string, error) {
+func (k *Kubernetes) LaunchEphemeralContainer(ctx context.Context, pod *corev1.Pod, probeImage string, command []string, args []string) (_ *corev1.Pod, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "LaunchEphemeralContainer", trace.WithAttributes(podAttributes(pod)...))
+	defer func() {
+		tracing.RecordError(span, err) //nolint:errcheck
+		span.End()
+	}()
+
 	podJS, err := json.Marshal(pod)
 	if err != nil {
 		return nil, "", fmt.Errorf("error creating JSON for pod: %v", err)
@@ -133,31 +438,522 @@ func (k *Kubernetes) LaunchEphemeralContainer(ctx context.Context, pod *corev1.P
 	return result, ephemeralName, nil
 }
 
-var errEphemeralContainerNotFound = errors.New("ephemeral container not found")
+// PollEphemeralContainerStatus waits up to timeout for the ephemeral
+// container of pod to terminate, returning its exit code.
+func (k *Kubernetes) PollEphemeralContainerStatus(ctx context.Context, pod *corev1.Pod, ephemeralContainerName string, timeout time.Duration) (int32, error) {
+	code, err := k.pollContainerStatus(ctx, pod, timeout, func(pod *corev1.Pod) corev1.ContainerState {
+		for _, s := range pod.Status.EphemeralContainerStatuses {
+			if s.Name == ephemeralContainerName {
+				return s.State
+			}
+		}
+		// the status of the container is only reported once the
+		// kubelet sees it, some time after it is added, so keep
+		// waiting
+		return corev1.ContainerState{}
+	})
+	if err != nil {
+		return -1, fmt.Errorf("polling ephemeral container terminated state: %w", err)
+	}
 
-func (k *Kubernetes) PollEphemeralContainerStatus(ctx context.Context, pod *corev1.Pod, ephemeralContainerName string) (int32, error) {
-	interval, timeout := time.Second, 30*time.Second // TODO(inkel) make these arguments
+	return code, nil
+}
 
-	var state corev1.ContainerState
+var (
+	errPodDeleted  = errors.New("pod deleted")
+	errWatchFailed = errors.New("watching pod failed")
+)
+
+// ErrPollTimeout is returned when a probe container doesn't terminate
+// in time.
+var ErrPollTimeout = errors.New("timed out waiting for the probe to terminate")
+
+// ErrContainerNotStarted is returned as soon as a probe container
+// waits for a reason it won't recover from by itself, e.g. its image
+// can't be pulled, instead of waiting for the timeout.
+var ErrContainerNotStarted = errors.New("probe container cannot start")
+
+// startFailureReasons are the waiting reasons of containers that need
+// an intervention to start, e.g. fixing the probe image.
+var startFailureReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"ErrImageNeverPull",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+}
 
-	err := wait.PollUntilContextTimeout(ctx, interval, timeout, false, func(ctx context.Context) (bool, error) {
+// pollInterval is how often pods are polled when they can't be watched.
+const pollInterval = time.Second
+
+// pollContainerStatus waits up to timeout for the state of the
+// container returned by the state function to be terminated, returning
+// its exit code. The pod is watched, and polled if the watch fails.
+func (k *Kubernetes) pollContainerStatus(ctx context.Context, pod *corev1.Pod, timeout time.Duration, state func(*corev1.Pod) corev1.ContainerState) (int32, error) {
+	ctx, span := tracer.Start(ctx, "PollContainerStatus", trace.WithAttributes(podAttributes(pod)...))
+	defer span.End()
+
+	var (
+		polls   int
+		waiting string
+	)
+
+	check := func(pod *corev1.Pod) (*corev1.ContainerStateTerminated, error) {
+		polls++
+
+		s := state(pod)
+
+		// e.g. the time spent pulling the image
+		if s.Waiting != nil && s.Waiting.Reason != waiting {
+			waiting = s.Waiting.Reason
+			span.AddEvent("container waiting", trace.WithAttributes(
+				attribute.String("k8s.container.waiting.reason", s.Waiting.Reason),
+				attribute.String("k8s.container.waiting.message", s.Waiting.Message),
+			))
+			if slices.Contains(startFailureReasons, s.Waiting.Reason) {
+				return nil, fmt.Errorf("%w: %s: %s", ErrContainerNotStarted, s.Waiting.Reason, s.Waiting.Message)
+			}
+		} else if s.Running != nil && waiting != "running" {
+			waiting = "running"
+			span.AddEvent("container running")
+		}
+
+		return s.Terminated, nil
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	terminated, err := k.watchContainerStatus(wctx, pod, check)
+	if errors.Is(err, errWatchFailed) {
+		span.AddEvent("polling pod", trace.WithAttributes(attribute.String("error", err.Error())))
+		terminated, err = k.pollPodContainerStatus(wctx, pod, check)
+	}
+	span.SetAttributes(attribute.Int("nethax.polls", polls))
+	if err != nil && wctx.Err() != nil && ctx.Err() == nil {
+		err = fmt.Errorf("%w after %s: %w", ErrPollTimeout, timeout, err)
+	}
+	if err != nil {
+		return -1, tracing.RecordError(span, err)
+	}
+
+	span.SetAttributes(attribute.Int("nethax.exit_code", int(terminated.ExitCode)))
+
+	return terminated.ExitCode, nil
+}
+
+// watchContainerStatus gets pod, and then watches it, until check
+// returns the terminated state of its container or an error. Errors
+// starting or keeping the watch wrap errWatchFailed.
+func (k *Kubernetes) watchContainerStatus(ctx context.Context, pod *corev1.Pod, check func(*corev1.Pod) (*corev1.ContainerStateTerminated, error)) (*corev1.ContainerStateTerminated, error) {
+	pods := k.client.CoreV1().Pods(pod.Namespace)
+
+	current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting pod: %w", err)
+	}
+	if terminated, err := check(current); terminated != nil || err != nil {
+		return terminated, err
+	}
+
+	w, err := pods.Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+		ResourceVersion: current.ResourceVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errWatchFailed, err)
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return nil, fmt.Errorf("%w: watch closed", errWatchFailed)
+			}
+
+			switch ev.Type {
+			case watch.Error:
+				return nil, fmt.Errorf("%w: %w", errWatchFailed, apierrors.FromObject(ev.Object))
+			case watch.Deleted:
+				return nil, errPodDeleted
+			}
+
+			p, ok := ev.Object.(*corev1.Pod)
+			if !ok || p.Name != pod.Name {
+				continue
+			}
+			if terminated, err := check(p); terminated != nil || err != nil {
+				return terminated, err
+			}
+		}
+	}
+}
+
+// pollPodContainerStatus polls pod until check returns the terminated
+// state of its container or an error.
+func (k *Kubernetes) pollPodContainerStatus(ctx context.Context, pod *corev1.Pod, check func(*corev1.Pod) (*corev1.ContainerStateTerminated, error)) (*corev1.ContainerStateTerminated, error) {
+	var terminated *corev1.ContainerStateTerminated
+
+	err := wait.PollUntilContextCancel(ctx, pollInterval, false, func(ctx context.Context) (bool, error) {
 		pod, err := k.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 		if err != nil {
 			return false, fmt.Errorf("getting pod: %w", err)
 		}
 
-		for _, s := range pod.Status.EphemeralContainerStatuses {
-			if s.Name == ephemeralContainerName {
-				state = s.State
-				return s.State.Terminated != nil, nil
+		terminated, err = check(pod)
+		return terminated != nil, err
+	})
+
+	return terminated, err
+}
+
+var errNoNodesFound = errors.New("no nodes found")
+
+// IsNoneFound returns whether err is due to a selector matching no
+// pods, namespaces or nodes.
+func IsNoneFound(err error) bool {
+	return errors.Is(err, errNoPodsFound) || errors.Is(err, errNoNamespacesFound) || errors.Is(err, errNoNodesFound)
+}
+
+// GetNodes returns the nodes matching the given labels selector.
+func (k *Kubernetes) GetNodes(ctx context.Context, labels string) ([]corev1.Node, error) {
+	nodes, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: labels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes for labels %q: %w", labels, err)
+	}
+
+	if len(nodes.Items) == 0 {
+		return nil, fmt.Errorf("%w: labels %q", errNoNodesFound, labels)
+	}
+
+	return nodes.Items, nil
+}
+
+const hostNetworkProbeContainer = "nethax-probe"
+
+// LaunchHostNetworkPod creates a short-lived pod in the given
+// namespace that runs the probe in the host network namespace of
+// node. The pod tolerates all taints so it can be scheduled on any
+// node, including control plane ones.
+func (k *Kubernetes) LaunchHostNetworkPod(ctx context.Context, node *corev1.Node, namespace, probeImage string, command []string, args []string) (*corev1.Pod, error) {
+	ctx, span := tracer.Start(ctx, "LaunchHostNetworkPod", trace.WithAttributes(
+		attribute.String("k8s.node.name", node.Name),
+		attribute.String("k8s.namespace.name", namespace),
+	))
+	defer span.End()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    namespace,
+			GenerateName: "nethax-probe-",
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "nethax-probe",
+				"app.kubernetes.io/managed-by": "nethax",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      node.Name,
+			HostNetwork:   true,
+			DNSPolicy:     corev1.DNSClusterFirstWithHostNet,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations: []corev1.Toleration{
+				{Operator: corev1.TolerationOpExists},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:    hostNetworkProbeContainer,
+					Image:   GetProbeImage(probeImage),
+					Command: command,
+					Args:    args,
+				},
+			},
+		},
+	}
+
+	result, err := k.client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, tracing.RecordError(span, fmt.Errorf("error creating host network probe pod on node %s: %v", node.Name, err))
+	}
+
+	return result, nil
+}
+
+// PollPodStatus waits up to timeout for the probe container of a pod
+// created with LaunchHostNetworkPod to terminate, returning its exit
+// code.
+func (k *Kubernetes) PollPodStatus(ctx context.Context, pod *corev1.Pod, timeout time.Duration) (int32, error) {
+	code, err := k.pollContainerStatus(ctx, pod, timeout, func(pod *corev1.Pod) corev1.ContainerState {
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.Name == hostNetworkProbeContainer {
+				return s.State
+			}
+		}
+		// container statuses are only reported once the pod has
+		// been scheduled and started, so keep waiting
+		return corev1.ContainerState{}
+	})
+	if err != nil {
+		return -1, fmt.Errorf("polling probe pod terminated state: %w", err)
+	}
+
+	return code, nil
+}
+
+// DeletePod deletes the given pod.
+func (k *Kubernetes) DeletePod(ctx context.Context, pod *corev1.Pod) error {
+	err := k.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("deleting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return nil
+}
+
+const (
+	echoServerContainer = "nethax-echo"
+
+	// EchoServerLabel holds the name of an echo server on its pod, and
+	// is used to select it.
+	EchoServerLabel = "nethax.grafana.com/echo-server"
+)
+
+// LaunchEchoServer creates a pod in namespace running the probe as an
+// echo server on the given TCP ports. The pod has the given labels, so
+// that network policies select it like the workload it stands for.
+func (k *Kubernetes) LaunchEchoServer(ctx context.Context, namespace string, labels map[string]string, ports []int32, probeImage string) (*corev1.Pod, error) {
+	ctx, span := tracer.Start(ctx, "LaunchEchoServer", trace.WithAttributes(attribute.String("k8s.namespace.name", namespace)))
+	defer span.End()
+
+	name := fmt.Sprintf("nethax-echo-%v", time.Now().UnixNano())
+
+	podLabels := map[string]string{
+		"app.kubernetes.io/managed-by": "nethax",
+	}
+	maps.Copy(podLabels, labels)
+	podLabels[EchoServerLabel] = name
+
+	listen := make([]string, len(ports))
+	containerPorts := make([]corev1.ContainerPort, len(ports))
+	for i, port := range ports {
+		listen[i] = fmt.Sprintf(":%d", port)
+		containerPorts[i] = corev1.ContainerPort{ContainerPort: port, Protocol: corev1.ProtocolTCP}
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    podLabels,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name:    echoServerContainer,
+					Image:   GetProbeImage(probeImage),
+					Command: []string{"/nethax-probe"},
+					Args:    []string{"--listen", strings.Join(listen, ",")},
+					Ports:   containerPorts,
+					ReadinessProbe: &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{
+							TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(ports[0])},
+						},
+						PeriodSeconds: 1,
+					},
+				},
+			},
+		},
+	}
+
+	result, err := k.client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, tracing.RecordError(span, fmt.Errorf("creating echo server pod in namespace %s: %w", namespace, err))
+	}
+
+	return result, nil
+}
+
+// CreateEchoService creates a Service of the given type exposing the
+// ports of an echo server pod created with LaunchEchoServer, with the
+// same name.
+func (k *Kubernetes) CreateEchoService(ctx context.Context, pod *corev1.Pod, serviceType corev1.ServiceType) (*corev1.Service, error) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "nethax",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: map[string]string{EchoServerLabel: pod.Labels[EchoServerLabel]},
+		},
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Name:       fmt.Sprintf("tcp-%d", p.ContainerPort),
+				Port:       p.ContainerPort,
+				TargetPort: intstr.FromInt32(p.ContainerPort),
+				Protocol:   p.Protocol,
+			})
+		}
+	}
+
+	result, err := k.client.CoreV1().Services(pod.Namespace).Create(ctx, svc, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating echo server service %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return result, nil
+}
+
+// WaitServiceLoadBalancer polls the given LoadBalancer service until
+// its load balancer is provisioned, returning it, or until timeout.
+func (k *Kubernetes) WaitServiceLoadBalancer(ctx context.Context, svc *corev1.Service, timeout time.Duration) (*corev1.Service, error) {
+	ctx, span := tracer.Start(ctx, "WaitServiceLoadBalancer", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", svc.Namespace),
+		attribute.String("k8s.service.name", svc.Name),
+	))
+	defer span.End()
+
+	var ready *corev1.Service
+
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		svc, err := k.client.CoreV1().Services(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting service: %w", err)
+		}
+
+		if len(svc.Status.LoadBalancer.Ingress) > 0 {
+			ready = svc
+			return true, nil
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, tracing.RecordError(span, fmt.Errorf("waiting for load balancer of service %s/%s: %w", svc.Namespace, svc.Name, err))
+	}
+
+	return ready, nil
+}
+
+// ServiceExportGVK is the kind of the multi-cluster services API
+// objects exporting a service to the other clusters of a clusterset,
+// e.g. with Submariner.
+var ServiceExportGVK = schema.GroupVersionKind{Group: "multicluster.x-k8s.io", Version: "v1alpha1", Kind: "ServiceExport"}
+
+// ExportService creates a ServiceExport for svc, making it reachable
+// from the other clusters of its clusterset as
+// <name>.<namespace>.svc.clusterset.local. The returned change reverts
+// it.
+func (k *Kubernetes) ExportService(ctx context.Context, svc *corev1.Service) (*Change, error) {
+	export := &unstructured.Unstructured{}
+	export.SetGroupVersionKind(ServiceExportGVK)
+	export.SetNamespace(svc.Namespace)
+	export.SetName(svc.Name)
+	export.SetLabels(map[string]string{"app.kubernetes.io/managed-by": "nethax"})
+
+	c, err := k.Apply(ctx, export)
+	if err != nil {
+		return nil, fmt.Errorf("exporting service %s/%s: %w", svc.Namespace, svc.Name, err)
+	}
+
+	return c, nil
+}
+
+// DeleteService deletes the given service.
+func (k *Kubernetes) DeleteService(ctx context.Context, svc *corev1.Service) error {
+	err := k.client.CoreV1().Services(svc.Namespace).Delete(ctx, svc.Name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("deleting service %s/%s: %w", svc.Namespace, svc.Name, err)
+	}
+
+	return nil
+}
+
+var errPodTerminated = errors.New("pod terminated")
+
+// WaitPodReady polls the given pod until it is ready, returning it, or
+// until timeout.
+func (k *Kubernetes) WaitPodReady(ctx context.Context, pod *corev1.Pod, timeout time.Duration) (*corev1.Pod, error) {
+	ctx, span := tracer.Start(ctx, "WaitPodReady", trace.WithAttributes(podAttributes(pod)...))
+	defer span.End()
+
+	var ready *corev1.Pod
+
+	err := wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := k.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting pod: %w", err)
+		}
+
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("%w: %s", errPodTerminated, pod.Status.Phase)
+		}
+
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+				ready = pod
+				return true, nil
 			}
 		}
 
-		return false, errEphemeralContainerNotFound
+		return false, nil
 	})
 	if err != nil {
-		return -1, fmt.Errorf("polling ephemeral container terminated state: %w", err)
+		return nil, tracing.RecordError(span, fmt.Errorf("waiting for pod %s/%s to be ready: %w", pod.Namespace, pod.Name, err))
+	}
+
+	return ready, nil
+}
+
+// GetPod returns the pod with the given namespace and name.
+func (k *Kubernetes) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	pod, err := k.client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting pod %s/%s: %w", namespace, name, err)
+	}
+
+	return pod, nil
+}
+
+// GetService returns the Service with the given namespace and name.
+func (k *Kubernetes) GetService(ctx context.Context, namespace, name string) (*corev1.Service, error) {
+	svc, err := k.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting Service %s/%s: %w", namespace, name, err)
+	}
+
+	return svc, nil
+}
+
+// GetConfigMap returns the ConfigMap with the given namespace and name.
+func (k *Kubernetes) GetConfigMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	cm, err := k.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting ConfigMap %s/%s: %w", namespace, name, err)
+	}
+
+	return cm, nil
+}
+
+// GetContainerLogs returns the logs of a container of pod, which can be
+// an ephemeral container.
+func (k *Kubernetes) GetContainerLogs(ctx context.Context, pod *corev1.Pod, container string) (string, error) {
+	logs, err := k.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container}).Do(ctx).Raw()
+	if err != nil {
+		return "", fmt.Errorf("getting logs of container %s of pod %s/%s: %w", container, pod.Namespace, pod.Name, err)
 	}
 
-	return state.Terminated.ExitCode, nil
+	return string(logs), nil
 }
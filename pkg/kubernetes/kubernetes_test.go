@@ -5,14 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
 	"testing/synctest"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	testClient "k8s.io/client-go/kubernetes/fake"
 	ktesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 )
 
 func setup() *Kubernetes {
@@ -200,7 +209,7 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 		ephemeralContainer = "quux"
 	)
 
-	t.Run("success", func(t *testing.T) {
+	t.Run("success polling", func(t *testing.T) {
 		exitCode := rand.Int32N(128)
 
 		pod := &corev1.Pod{
@@ -250,11 +259,15 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 
 			return true, pod, nil
 		})
+		// fall back to polling
+		c.PrependWatchReactor("pods", func(_ ktesting.Action) (bool, watch.Interface, error) {
+			return true, nil, errors.New("watch not supported")
+		})
 
 		k := &Kubernetes{client: c}
 
 		synctest.Test(t, func(t *testing.T) {
-			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer)
+			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer, 30*time.Second)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -265,25 +278,85 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 		})
 	})
 
-	t.Run("ephemeral container not found", func(t *testing.T) {
+	t.Run("success watching", func(t *testing.T) {
+		exitCode := rand.Int32N(128)
+
 		pod := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: ns,
-				Name:      podName,
-			},
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
 			Status: corev1.PodStatus{
-				EphemeralContainerStatuses: []corev1.ContainerStatus{},
+				EphemeralContainerStatuses: []corev1.ContainerStatus{
+					{Name: ephemeralContainer, State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+					}},
+				},
 			},
 		}
 
-		k := Kubernetes{
-			client: testClient.NewClientset(pod),
+		c := testClient.NewClientset(pod)
+		k := &Kubernetes{client: c}
+
+		synctest.Test(t, func(t *testing.T) {
+			go func() {
+				for _, state := range []corev1.ContainerState{
+					{Running: &corev1.ContainerStateRunning{}},
+					{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+				} {
+					time.Sleep(time.Minute)
+
+					pod := pod.DeepCopy()
+					pod.Status.EphemeralContainerStatuses[0].State = state
+					if _, err := c.CoreV1().Pods(ns).UpdateStatus(t.Context(), pod, metav1.UpdateOptions{}); err != nil {
+						t.Errorf("updating pod: %v", err)
+					}
+				}
+			}()
+
+			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer, 5*time.Minute)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code != exitCode {
+				t.Errorf("expecting exit code %d, got %d", exitCode, code)
+			}
+		})
+	})
+
+	t.Run("image pull failure", func(t *testing.T) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
+			Status: corev1.PodStatus{
+				EphemeralContainerStatuses: []corev1.ContainerStatus{
+					{Name: ephemeralContainer, State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+					}},
+				},
+			},
 		}
 
+		c := testClient.NewClientset(pod)
+		k := &Kubernetes{client: c}
+
 		synctest.Test(t, func(t *testing.T) {
-			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer)
-			if !errors.Is(err, errEphemeralContainerNotFound) {
-				t.Fatalf("expecting error %v, got %v", errEphemeralContainerNotFound, err)
+			go func() {
+				time.Sleep(10 * time.Second)
+
+				pod := pod.DeepCopy()
+				pod.Status.EphemeralContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{
+					Reason:  "ImagePullBackOff",
+					Message: `Back-off pulling image "grafana/nethax-probe:nope"`,
+				}
+				if _, err := c.CoreV1().Pods(ns).UpdateStatus(t.Context(), pod, metav1.UpdateOptions{}); err != nil {
+					t.Errorf("updating pod: %v", err)
+				}
+			}()
+
+			start := time.Now()
+			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer, 5*time.Minute)
+			if !errors.Is(err, ErrContainerNotStarted) || !strings.Contains(err.Error(), "ImagePullBackOff") {
+				t.Fatalf("expecting error %v, got %v", ErrContainerNotStarted, err)
+			}
+			if d := time.Since(start); d != 10*time.Second {
+				t.Errorf("expecting the error as soon as the image pull failed, got it after %s", d)
 			}
 			if code != -1 {
 				t.Errorf("expecting error code -1, got %d", code)
@@ -291,6 +364,49 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 		})
 	})
 
+	t.Run("status reported after the watch started", func(t *testing.T) {
+		exitCode := rand.Int32N(128)
+
+		// just patched: the kubelet didn't report the container yet
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
+		}
+
+		c := testClient.NewClientset(pod)
+		k := &Kubernetes{client: c}
+
+		synctest.Test(t, func(t *testing.T) {
+			go func() {
+				// wait for the pod to be watched
+				synctest.Wait()
+				if !slices.ContainsFunc(c.Actions(), func(a ktesting.Action) bool { return a.GetVerb() == "watch" }) {
+					t.Errorf("expecting the pod to be watched, got actions %v", c.Actions())
+				}
+
+				for _, state := range []corev1.ContainerState{
+					{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+					{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode}},
+				} {
+					time.Sleep(time.Second)
+
+					pod := pod.DeepCopy()
+					pod.Status.EphemeralContainerStatuses = []corev1.ContainerStatus{{Name: ephemeralContainer, State: state}}
+					if _, err := c.CoreV1().Pods(ns).UpdateStatus(t.Context(), pod, metav1.UpdateOptions{}); err != nil {
+						t.Errorf("updating pod: %v", err)
+					}
+				}
+			}()
+
+			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer, 30*time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code != exitCode {
+				t.Errorf("expecting exit code %d, got %d", exitCode, code)
+			}
+		})
+	})
+
 	t.Run("timeout", func(t *testing.T) {
 		pod := &corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
@@ -313,9 +429,9 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 		}
 
 		synctest.Test(t, func(t *testing.T) {
-			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer)
-			if !errors.Is(err, context.DeadlineExceeded) {
-				t.Fatalf("expecting error %v, got %v", context.DeadlineExceeded, err)
+			code, err := k.PollEphemeralContainerStatus(t.Context(), pod, ephemeralContainer, 30*time.Second)
+			if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrPollTimeout) {
+				t.Fatalf("expecting error %v, got %v", ErrPollTimeout, err)
 			}
 			if code != -1 {
 				t.Errorf("expecting error code -1, got %d", code)
@@ -323,3 +439,390 @@ func TestPollEphemeralContainerStatus(t *testing.T) {
 		})
 	})
 }
+
+func TestGetWorkloadPods(t *testing.T) {
+	const ns = "nethax"
+
+	owner := func(kind, name string, uid types.UID) []metav1.OwnerReference {
+		return []metav1.OwnerReference{
+			{Kind: kind, Name: name, UID: uid, Controller: ptr.To(true)},
+		}
+	}
+	pod := func(name string, labels map[string]string, owners []metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       ns,
+				Name:            name,
+				Labels:          labels,
+				OwnerReferences: owners,
+			},
+		}
+	}
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+
+	k := &Kubernetes{
+		client: testClient.NewClientset(
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "frontend", UID: "deploy-uid"},
+				Spec:       appsv1.DeploymentSpec{Selector: selector("frontend")},
+			},
+			&appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       ns,
+					Name:            "frontend-abc",
+					UID:             "rs-uid",
+					Labels:          map[string]string{"app": "frontend"},
+					OwnerReferences: owner("Deployment", "frontend", "deploy-uid"),
+				},
+			},
+			&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "db", UID: "sts-uid"},
+				Spec:       appsv1.StatefulSetSpec{Selector: selector("db")},
+			},
+			&appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "agent", UID: "ds-uid"},
+				Spec:       appsv1.DaemonSetSpec{Selector: selector("agent")},
+			},
+			&batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "migrate", UID: "job-uid"},
+				Spec:       batchv1.JobSpec{Selector: selector("migrate")},
+			},
+			pod("frontend-abc-1", map[string]string{"app": "frontend", "tier": "web"}, owner("ReplicaSet", "frontend-abc", "rs-uid")),
+			pod("frontend-abc-2", map[string]string{"app": "frontend"}, owner("ReplicaSet", "frontend-abc", "rs-uid")),
+			pod("frontend-impostor", map[string]string{"app": "frontend"}, nil),
+			pod("db-0", map[string]string{"app": "db"}, owner("StatefulSet", "db", "sts-uid")),
+			pod("agent-xyz", map[string]string{"app": "agent"}, owner("DaemonSet", "agent", "ds-uid")),
+			pod("migrate-xyz", map[string]string{"app": "migrate"}, owner("Job", "migrate", "job-uid")),
+			pod("orphan", map[string]string{"app": "migrate"}, owner("Job", "migrate", "other-job-uid")),
+		),
+	}
+
+	t.Run("found", func(t *testing.T) {
+		tests := []struct {
+			kind   WorkloadKind
+			name   string
+			labels string
+			exp    []string
+		}{
+			{WorkloadKindDeployment, "frontend", "", []string{"frontend-abc-1", "frontend-abc-2"}},
+			{WorkloadKindDeployment, "frontend", "tier=web", []string{"frontend-abc-1"}},
+			{WorkloadKindStatefulSet, "db", "", []string{"db-0"}},
+			{WorkloadKindDaemonSet, "agent", "", []string{"agent-xyz"}},
+			{WorkloadKindJob, "migrate", "", []string{"migrate-xyz"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%s,labels=%s", tt.kind, tt.name, tt.labels), func(t *testing.T) {
+				pods, err := k.GetWorkloadPods(t.Context(), ns, tt.kind, tt.name, tt.labels, "")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				var got []string
+				for _, p := range pods {
+					got = append(got, p.Name)
+				}
+				if !slices.Equal(tt.exp, got) {
+					t.Fatalf("expecting pods %v, got %v", tt.exp, got)
+				}
+			})
+		}
+	})
+
+	t.Run("no owned pods", func(t *testing.T) {
+		_, err := k.GetWorkloadPods(t.Context(), ns, WorkloadKindDeployment, "frontend", "tier=db", "")
+		if !errors.Is(err, errNoPodsFound) {
+			t.Fatalf("expecting error %v, got %v", errNoPodsFound, err)
+		}
+	})
+
+	t.Run("workload not found", func(t *testing.T) {
+		_, err := k.GetWorkloadPods(t.Context(), ns, WorkloadKindStatefulSet, "frontend", "", "")
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("expecting not found error, got %v", err)
+		}
+	})
+
+	t.Run("invalid kind", func(t *testing.T) {
+		_, err := k.GetWorkloadPods(t.Context(), ns, "CronJob", "frontend", "", "")
+		if !errors.Is(err, errInvalidWorkloadKind) {
+			t.Fatalf("expecting error %v, got %v", errInvalidWorkloadKind, err)
+		}
+	})
+}
+
+func TestParseWorkloadKind(t *testing.T) {
+	tests := map[string]struct {
+		exp WorkloadKind
+		err error
+	}{
+		"Deployment":  {WorkloadKindDeployment, nil},
+		"deployment":  {WorkloadKindDeployment, nil},
+		"StatefulSet": {WorkloadKindStatefulSet, nil},
+		"daemonset":   {WorkloadKindDaemonSet, nil},
+		"JOB":         {WorkloadKindJob, nil},
+		"":            {"", errInvalidWorkloadKind},
+		"ReplicaSet":  {"", errInvalidWorkloadKind},
+	}
+
+	for in, tt := range tests {
+		got, err := ParseWorkloadKind(in)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%q: expecting error %v, got %v", in, tt.err, err)
+		}
+		if tt.exp != got {
+			t.Fatalf("%q: expecting %q, got %q", in, tt.exp, got)
+		}
+	}
+}
+
+func TestGetNamespaces(t *testing.T) {
+	ns := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		}
+	}
+
+	k := &Kubernetes{
+		client: testClient.NewClientset(
+			ns("tenant-b", map[string]string{"tenant": "true"}),
+			ns("kube-system", nil),
+			ns("tenant-a", map[string]string{"tenant": "true"}),
+		),
+	}
+
+	tests := []struct {
+		labels string
+		exp    []string
+		err    error
+	}{
+		{"", []string{"kube-system", "tenant-a", "tenant-b"}, nil},
+		{"tenant=true", []string{"tenant-a", "tenant-b"}, nil},
+		{"tenant!=true", []string{"kube-system"}, nil},
+		{"tenant=false", nil, errNoNamespacesFound},
+	}
+
+	for _, tt := range tests {
+		t.Run("labels="+tt.labels, func(t *testing.T) {
+			got, err := k.GetNamespaces(t.Context(), tt.labels)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expecting error %v, got %v", tt.err, err)
+			}
+			if !slices.Equal(tt.exp, got) {
+				t.Fatalf("expecting namespaces %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestGetNodes(t *testing.T) {
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		}
+	}
+
+	k := &Kubernetes{
+		client: testClient.NewClientset(
+			node("control-plane", map[string]string{"node-role.kubernetes.io/control-plane": ""}),
+			node("worker-1", map[string]string{"pool": "default"}),
+			node("worker-2", map[string]string{"pool": "default"}),
+		),
+	}
+
+	tests := []struct {
+		labels string
+		exp    int
+		err    error
+	}{
+		{"", 3, nil},
+		{"pool=default", 2, nil},
+		{"node-role.kubernetes.io/control-plane", 1, nil},
+		{"pool=gpu", 0, errNoNodesFound},
+	}
+
+	for _, tt := range tests {
+		t.Run("labels="+tt.labels, func(t *testing.T) {
+			nodes, err := k.GetNodes(t.Context(), tt.labels)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expecting error %v, got %v", tt.err, err)
+			}
+			if got := len(nodes); tt.exp != got {
+				t.Fatalf("expecting %d nodes, got %d", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestLaunchHostNetworkPod(t *testing.T) {
+	k := setup()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+
+	pod, err := k.LaunchHostNetworkPod(t.Context(), node, "nethax", "", []string{"nyaa"}, []string{"rawr"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, g := "nethax", pod.Namespace; e != g {
+		t.Errorf("expecting namespace %q, got %q", e, g)
+	}
+	if e, g := node.Name, pod.Spec.NodeName; e != g {
+		t.Errorf("expecting node name %q, got %q", e, g)
+	}
+	if !pod.Spec.HostNetwork {
+		t.Error("expecting pod to use the host network")
+	}
+	if e, g := corev1.RestartPolicyNever, pod.Spec.RestartPolicy; e != g {
+		t.Errorf("expecting restart policy %q, got %q", e, g)
+	}
+	if len(pod.Spec.Containers) != 1 {
+		t.Fatalf("expecting 1 container, got %d", len(pod.Spec.Containers))
+	}
+	if e, g := DefaultProbeImage, pod.Spec.Containers[0].Image; e != g {
+		t.Errorf("expecting image %q, got %q", e, g)
+	}
+}
+
+func TestPollPodStatus(t *testing.T) {
+	const ns, podName = "foo", "bar"
+
+	t.Run("success", func(t *testing.T) {
+		exitCode := rand.Int32N(128)
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
+		}
+
+		var stage int
+
+		c := testClient.NewClientset()
+		c.PrependReactor("get", "pods", func(_ ktesting.Action) (bool, runtime.Object, error) {
+			pod := pod.DeepCopy()
+
+			switch stage {
+			case 0: // pending, no container statuses yet
+			case 1: // running
+				pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+					{Name: hostNetworkProbeContainer, State: corev1.ContainerState{
+						Running: &corev1.ContainerStateRunning{},
+					}},
+				}
+			default: // terminated
+				pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+					{Name: hostNetworkProbeContainer, State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+					}},
+				}
+			}
+
+			stage++
+
+			return true, pod, nil
+		})
+		c.PrependWatchReactor("pods", func(_ ktesting.Action) (bool, watch.Interface, error) {
+			return true, nil, errors.New("watch not supported")
+		})
+
+		k := &Kubernetes{client: c}
+
+		synctest.Test(t, func(t *testing.T) {
+			code, err := k.PollPodStatus(t.Context(), pod, 30*time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if code != exitCode {
+				t.Errorf("expecting exit code %d, got %d", exitCode, code)
+			}
+		})
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
+		}
+		k := &Kubernetes{client: testClient.NewClientset(pod)}
+
+		synctest.Test(t, func(t *testing.T) {
+			code, err := k.PollPodStatus(t.Context(), pod, 30*time.Second)
+			if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrPollTimeout) {
+				t.Fatalf("expecting error %v, got %v", ErrPollTimeout, err)
+			}
+			if code != -1 {
+				t.Errorf("expecting error code -1, got %d", code)
+			}
+		})
+	})
+}
+
+func TestLaunchEchoServer(t *testing.T) {
+	k := setup()
+
+	pod, err := k.LaunchEchoServer(t.Context(), "billing", map[string]string{"app": "billing"}, []int32{8080, 9090}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, g := "billing", pod.Labels["app"]; e != g {
+		t.Errorf("expecting label app=%s, got %q", e, g)
+	}
+	if e, g := pod.Name, pod.Labels[EchoServerLabel]; e != g {
+		t.Errorf("expecting label %s=%s, got %q", EchoServerLabel, e, g)
+	}
+	if e, g := []string{"--listen", ":8080,:9090"}, pod.Spec.Containers[0].Args; !slices.Equal(e, g) {
+		t.Errorf("expecting args %q, got %q", e, g)
+	}
+
+	svc, err := k.CreateEchoService(t.Context(), pod, corev1.ServiceTypeClusterIP)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if e, g := pod.Name, svc.Spec.Selector[EchoServerLabel]; e != g {
+		t.Errorf("expecting service to select %s=%s, got %v", EchoServerLabel, e, svc.Spec.Selector)
+	}
+	if e, g := 2, len(svc.Spec.Ports); e != g {
+		t.Errorf("expecting %d service ports, got %d", e, g)
+	}
+
+	if err := k.DeleteService(t.Context(), svc); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitPodReady(t *testing.T) {
+	const ns, podName = "foo", "bar"
+
+	pod := func(status corev1.PodStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: podName},
+			Status:     status,
+		}
+	}
+
+	tests := map[string]struct {
+		pod *corev1.Pod
+		err error
+	}{
+		"ready": {pod(corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}), nil},
+		"failed":  {pod(corev1.PodStatus{Phase: corev1.PodFailed}), errPodTerminated},
+		"pending": {pod(corev1.PodStatus{Phase: corev1.PodPending}), context.DeadlineExceeded},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			k := &Kubernetes{client: testClient.NewClientset(tt.pod)}
+
+			synctest.Test(t, func(t *testing.T) {
+				_, err := k.WaitPodReady(t.Context(), tt.pod, time.Minute)
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expecting error %v, got %v", tt.err, err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package kubernetes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+// DecodeManifests reads the objects of a stream of YAML or JSON
+// documents, replacing List objects by their items.
+func DecodeManifests(r io.Reader) ([]*unstructured.Unstructured, error) {
+	dec := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+
+	var objs []*unstructured.Unstructured
+	for {
+		var raw runtime.RawExtension
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return objs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding manifest: %w", err)
+		}
+
+		if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+			continue // empty document
+		}
+
+		obj := new(unstructured.Unstructured)
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, fmt.Errorf("decoding manifest: %w", err)
+		}
+
+		if !obj.IsList() {
+			objs = append(objs, obj)
+			continue
+		}
+
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objs = append(objs, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("decoding manifest: %w", err)
+		}
+	}
+}
+
+var errNoDynamicClient = errors.New("no dynamic client")
+
+// resource returns the client for the resource of obj, defaulting its
+// namespace if it is namespaced.
+func (k *Kubernetes) resource(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	if k.dynamic == nil || k.mapper == nil {
+		return nil, errNoDynamicClient
+	}
+
+	gvk := obj.GroupVersionKind()
+	mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("finding resource for %s: %w", gvk, err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return k.dynamic.Resource(mapping.Resource), nil
+	}
+
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(corev1.NamespaceDefault)
+	}
+
+	return k.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// Change is a change made to an object by Apply or Delete, which can
+// be undone with Revert.
+type Change struct {
+	// Object is the object as applied, or nil if it was deleted.
+	Object *unstructured.Unstructured
+	// Previous is the object before the change, or nil if it was
+	// created.
+	Previous *unstructured.Unstructured
+}
+
+func (c *Change) String() string {
+	switch {
+	case c.Previous == nil:
+		return "created " + objectName(c.Object)
+	case c.Object == nil:
+		return "deleted " + objectName(c.Previous)
+	default:
+		return "replaced " + objectName(c.Object)
+	}
+}
+
+// objectName returns the kind and the, possibly namespaced, name of
+// obj.
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + " " + obj.GetName()
+	}
+	return obj.GetKind() + " " + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// Apply creates obj, or replaces it if it already exists. Namespaced
+// objects without a namespace are created in the default namespace.
+func (k *Kubernetes) Apply(ctx context.Context, obj *unstructured.Unstructured) (*Change, error) {
+	res, err := k.resource(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := res.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		created, err := res.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("creating %s: %w", objectName(obj), err)
+		}
+		return &Change{Object: created}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", objectName(obj), err)
+	}
+
+	obj = obj.DeepCopy()
+	obj.SetResourceVersion(prev.GetResourceVersion())
+
+	updated, err := res.Update(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("replacing %s: %w", objectName(obj), err)
+	}
+
+	return &Change{Object: updated, Previous: prev}, nil
+}
+
+// Delete deletes the object identified by the kind, namespace and name
+// of obj. It returns a nil change if the object doesn't exist.
+func (k *Kubernetes) Delete(ctx context.Context, obj *unstructured.Unstructured) (*Change, error) {
+	res, err := k.resource(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := res.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", objectName(obj), err)
+	}
+
+	err = res.Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("deleting %s: %w", objectName(obj), err)
+	}
+
+	return &Change{Previous: prev}, nil
+}
+
+// Revert undoes a change: created objects are deleted, and deleted or
+// replaced objects are restored to their previous state.
+func (k *Kubernetes) Revert(ctx context.Context, c *Change) error {
+	if c.Previous == nil {
+		res, err := k.resource(c.Object)
+		if err != nil {
+			return err
+		}
+
+		err = res.Delete(ctx, c.Object.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting %s: %w", objectName(c.Object), err)
+		}
+		return nil
+	}
+
+	prev := c.Previous.DeepCopy()
+	res, err := k.resource(prev)
+	if err != nil {
+		return err
+	}
+
+	cur, err := res.Get(ctx, prev.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// the server sets these when the object is created
+		prev.SetResourceVersion("")
+		prev.SetUID("")
+		prev.SetCreationTimestamp(metav1.Time{})
+		prev.SetGeneration(0)
+		prev.SetManagedFields(nil)
+		unstructured.RemoveNestedField(prev.Object, "status")
+
+		if _, err := res.Create(ctx, prev, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("restoring %s: %w", objectName(prev), err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting %s: %w", objectName(prev), err)
+	}
+
+	prev.SetResourceVersion(cur.GetResourceVersion())
+	if _, err := res.Update(ctx, prev, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("restoring %s: %w", objectName(prev), err)
+	}
+
+	return nil
+}
+
+// WaitChange polls the object of c until the change has taken effect,
+// or until timeout: deleted objects are gone, and applied ones exist
+// and are ready.
+func (k *Kubernetes) WaitChange(ctx context.Context, c *Change, timeout time.Duration) error {
+	if c.Object == nil {
+		return k.waitDeleted(ctx, c.Previous, timeout)
+	}
+	return k.waitApplied(ctx, c.Object, timeout)
+}
+
+// WaitReverted polls the object of c until Revert has taken effect, or
+// until timeout: created objects are gone, and deleted or replaced ones
+// exist again and are ready.
+func (k *Kubernetes) WaitReverted(ctx context.Context, c *Change, timeout time.Duration) error {
+	if c.Previous == nil {
+		return k.waitDeleted(ctx, c.Object, timeout)
+	}
+	return k.waitApplied(ctx, c.Previous, timeout)
+}
+
+// waitDeleted polls obj until it no longer exists. An object with the
+// same name but another UID is a new one, so obj is gone.
+func (k *Kubernetes) waitDeleted(ctx context.Context, obj *unstructured.Unstructured, timeout time.Duration) error {
+	res, err := k.resource(obj)
+	if err != nil {
+		return err
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		cur, err := res.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("getting %s: %w", objectName(obj), err)
+		}
+
+		return obj.GetUID() != "" && cur.GetUID() != obj.GetUID(), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s to be deleted: %w", objectName(obj), err)
+	}
+
+	return nil
+}
+
+// waitApplied polls obj until it exists, isn't being deleted, and is
+// ready.
+func (k *Kubernetes) waitApplied(ctx context.Context, obj *unstructured.Unstructured, timeout time.Duration) error {
+	res, err := k.resource(obj)
+	if err != nil {
+		return err
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		cur, err := res.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("getting %s: %w", objectName(obj), err)
+		}
+
+		return cur.GetDeletionTimestamp() == nil && objectReady(cur), nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %s to be ready: %w", objectName(obj), err)
+	}
+
+	return nil
+}
+
+// readyConditions are the condition types telling that an object is
+// ready, e.g. for pods and deployments.
+var readyConditions = map[string]bool{"Ready": true, "Available": true}
+
+// objectReady tells whether obj is ready as far as its status tells:
+// its controller observed its latest generation, and its Ready or
+// Available conditions, if any, are true. Objects without a status,
+// like NetworkPolicies, are ready once they exist.
+func objectReady(obj *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if found && observed < obj.GetGeneration() {
+		return false
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		c, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if t, _ := c["type"].(string); readyConditions[t] {
+			if s, _ := c["status"].(string); s != string(metav1.ConditionTrue) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,254 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicClient "k8s.io/client-go/dynamic/fake"
+	testClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const testManifests = `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: deny-all
+  namespace: shop
+spec:
+  podSelector: {}
+  policyTypes: [Ingress]
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: networking.k8s.io/v1
+  kind: NetworkPolicy
+  metadata:
+    name: allow-frontend
+    namespace: shop
+  spec:
+    podSelector: {}
+    policyTypes: [Egress]
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: shop
+`
+
+func TestDecodeManifests(t *testing.T) {
+	objs, err := DecodeManifests(strings.NewReader(testManifests))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, obj := range objs {
+		names = append(names, objectName(obj))
+	}
+
+	exp := "NetworkPolicy shop/deny-all,NetworkPolicy shop/allow-frontend,Namespace shop"
+	if got := strings.Join(names, ","); exp != got {
+		t.Errorf("expecting %s, got %s", exp, got)
+	}
+
+	if _, err := DecodeManifests(strings.NewReader("kind: [")); err == nil {
+		t.Error("expecting error, got nil")
+	}
+}
+
+func TestApplyDeleteRevert(t *testing.T) {
+	ctx := context.Background()
+
+	existing := &networkingv1.NetworkPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "allow-frontend", Labels: map[string]string{"state": "before"}},
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"), meta.RESTScopeNamespace)
+
+	dyn := dynamicClient.NewSimpleDynamicClient(scheme.Scheme, existing)
+	k := NewWithClients(testClient.NewClientset(), dyn, mapper)
+
+	policies := dyn.Resource(schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}).Namespace("shop")
+
+	get := func(name string) (*unstructured.Unstructured, bool) {
+		t.Helper()
+		obj, err := policies.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, false
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return obj, true
+	}
+
+	objs, err := DecodeManifests(strings.NewReader(testManifests))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		c, err := k.Apply(ctx, objs[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e, g := "created NetworkPolicy shop/deny-all", c.String(); e != g {
+			t.Errorf("expecting %q, got %q", e, g)
+		}
+		if _, ok := get("deny-all"); !ok {
+			t.Fatal("expecting policy to be created")
+		}
+
+		if err := k.Revert(ctx, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := get("deny-all"); ok {
+			t.Fatal("expecting policy to be deleted")
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		obj := objs[1].DeepCopy()
+		obj.SetLabels(map[string]string{"state": "after"})
+
+		c, err := k.Apply(ctx, obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e, g := "replaced NetworkPolicy shop/allow-frontend", c.String(); e != g {
+			t.Errorf("expecting %q, got %q", e, g)
+		}
+		if p, _ := get("allow-frontend"); p.GetLabels()["state"] != "after" {
+			t.Fatalf("expecting policy to be replaced, got %v", p.GetLabels())
+		}
+
+		if err := k.Revert(ctx, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p, _ := get("allow-frontend"); p.GetLabels()["state"] != "before" {
+			t.Fatalf("expecting policy to be restored, got %v", p.GetLabels())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c, err := k.Delete(ctx, objs[1])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e, g := "deleted NetworkPolicy shop/allow-frontend", c.String(); e != g {
+			t.Errorf("expecting %q, got %q", e, g)
+		}
+		if _, ok := get("allow-frontend"); ok {
+			t.Fatal("expecting policy to be deleted")
+		}
+
+		if err := k.Revert(ctx, c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p, ok := get("allow-frontend"); !ok || p.GetLabels()["state"] != "before" {
+			t.Fatalf("expecting policy to be restored, got %v", p)
+		}
+
+		// deleting a missing object is not a change
+		if c, err := k.Delete(ctx, objs[0]); c != nil || err != nil {
+			t.Fatalf("expecting no change, got %v, %v", c, err)
+		}
+	})
+
+	t.Run("unknown kind", func(t *testing.T) {
+		if _, err := k.Apply(ctx, objs[2]); err == nil {
+			t.Fatal("expecting error, got nil")
+		}
+	})
+}
+
+func TestWaitChange(t *testing.T) {
+	pod := func(uid string, ready corev1.ConditionStatus) *unstructured.Unstructured {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "echo", UID: types.UID(uid)},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return &unstructured.Unstructured{Object: obj}
+	}
+
+	testCases := []struct {
+		name     string
+		existing *unstructured.Unstructured
+		change   *Change
+		err      error
+	}{
+		{
+			name:     "applied and ready",
+			existing: pod("a", corev1.ConditionTrue),
+			change:   &Change{Object: pod("a", corev1.ConditionTrue)},
+		},
+		{
+			name:     "applied but not ready",
+			existing: pod("a", corev1.ConditionFalse),
+			change:   &Change{Object: pod("a", corev1.ConditionFalse)},
+			err:      context.DeadlineExceeded,
+		},
+		{
+			name:   "applied but missing",
+			change: &Change{Object: pod("a", corev1.ConditionTrue)},
+			err:    context.DeadlineExceeded,
+		},
+		{
+			name:   "deleted",
+			change: &Change{Previous: pod("a", corev1.ConditionTrue)},
+		},
+		{
+			name:     "deleted and recreated",
+			existing: pod("b", corev1.ConditionTrue),
+			change:   &Change{Previous: pod("a", corev1.ConditionTrue)},
+		},
+		{
+			name:     "still terminating",
+			existing: pod("a", corev1.ConditionTrue),
+			change:   &Change{Previous: pod("a", corev1.ConditionTrue)},
+			err:      context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				mapper := meta.NewDefaultRESTMapper(nil)
+				mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+
+				dyn := dynamicClient.NewSimpleDynamicClient(scheme.Scheme)
+				if tc.existing != nil {
+					_, err := dyn.Resource(corev1.SchemeGroupVersion.WithResource("pods")).Namespace("shop").Create(t.Context(), tc.existing, metav1.CreateOptions{})
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+				k := NewWithClients(testClient.NewClientset(), dyn, mapper)
+
+				err := k.WaitChange(t.Context(), tc.change, time.Minute)
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("expecting error %v, got %v", tc.err, err)
+				}
+			})
+		})
+	}
+}
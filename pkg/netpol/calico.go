@@ -0,0 +1,636 @@
+package netpol
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// Calico API groups: the one served by the Calico API server, and the
+// one of the CRDs backing it.
+const (
+	CalicoGroup    = "projectcalico.org"
+	CalicoCRDGroup = "crd.projectcalico.org"
+)
+
+// Labels Calico sets on namespaces and endpoints with the name of the
+// namespace.
+const (
+	calicoNameLabel      = "projectcalico.org/name"
+	calicoNamespaceLabel = "projectcalico.org/namespace"
+)
+
+type calicoPolicy struct {
+	Kind     string            `json:"kind"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Tier                   string       `json:"tier"`
+		Order                  *float64     `json:"order"`
+		Selector               string       `json:"selector"`
+		NamespaceSelector      string       `json:"namespaceSelector"`
+		ServiceAccountSelector string       `json:"serviceAccountSelector"`
+		Types                  []string     `json:"types"`
+		Ingress                []calicoRule `json:"ingress"`
+		Egress                 []calicoRule `json:"egress"`
+		DoNotTrack             bool         `json:"doNotTrack"`
+		PreDNAT                bool         `json:"preDNAT"`
+		ApplyOnForward         bool         `json:"applyOnForward"`
+	} `json:"spec"`
+}
+
+type calicoRule struct {
+	Action      string              `json:"action"`
+	Protocol    *intstr.IntOrString `json:"protocol"`
+	NotProtocol *intstr.IntOrString `json:"notProtocol"`
+	Source      calicoEntityRule    `json:"source"`
+	Destination calicoEntityRule    `json:"destination"`
+	HTTP        *struct {
+		Methods []string `json:"methods"`
+		Paths   []struct {
+			Exact  string `json:"exact"`
+			Prefix string `json:"prefix"`
+		} `json:"paths"`
+	} `json:"http"`
+}
+
+type calicoEntityRule struct {
+	Nets              []string             `json:"nets"`
+	NotNets           []string             `json:"notNets"`
+	Selector          string               `json:"selector"`
+	NotSelector       string               `json:"notSelector"`
+	NamespaceSelector string               `json:"namespaceSelector"`
+	Ports             []intstr.IntOrString `json:"ports"`
+	NotPorts          []intstr.IntOrString `json:"notPorts"`
+	ServiceAccounts   json.RawMessage      `json:"serviceAccounts"`
+	Services          json.RawMessage      `json:"services"`
+}
+
+// empty returns whether the entity rule matches everything.
+func (e calicoEntityRule) empty() bool {
+	return len(e.Nets) == 0 && len(e.NotNets) == 0 && e.Selector == "" && e.NotSelector == "" &&
+		e.NamespaceSelector == "" && len(e.Ports) == 0 && len(e.NotPorts) == 0 &&
+		len(e.ServiceAccounts) == 0 && len(e.Services) == 0
+}
+
+// FromCalicoPolicy converts a Calico NetworkPolicy or
+// GlobalNetworkPolicy, from either the projectcalico.org or the
+// crd.projectcalico.org API groups. Tiers are not modeled, so all the
+// policies are ordered as if they were in the same tier.
+//
+// Parts of the policy that cannot be modeled are skipped, and reported
+// in the returned warnings.
+func FromCalicoPolicy(obj *unstructured.Unstructured) (Policy, []string, error) {
+	raw, err := obj.MarshalJSON()
+	if err != nil {
+		return Policy{}, nil, fmt.Errorf("encoding %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	var cp calicoPolicy
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return Policy{}, nil, fmt.Errorf("decoding %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	spec := cp.Spec
+
+	p := Policy{
+		Kind:  "Calico" + cp.Kind,
+		Name:  cp.Metadata.Name,
+		Order: spec.Order,
+	}
+	if cp.Kind == "NetworkPolicy" {
+		p.Namespace = cmp.Or(cp.Metadata.Namespace, corev1.NamespaceDefault)
+	}
+	if p.Order == nil {
+		// policies without order are applied last
+		p.Order = ptr.To(math.Inf(1))
+	}
+
+	var warnings []string
+	warnf := func(format string, a ...any) {
+		warnings = append(warnings, fmt.Sprintf("%s: ", p.String())+fmt.Sprintf(format, a...))
+	}
+
+	if spec.DoNotTrack || spec.PreDNAT || spec.ApplyOnForward {
+		return Policy{}, warnings, fmt.Errorf("%w: %s applies to host endpoints", ErrUnsupportedPolicy, p.String())
+	}
+	if spec.Tier != "" && spec.Tier != "default" {
+		warnf("tier %s is evaluated as the default tier", spec.Tier)
+	}
+	if spec.ServiceAccountSelector != "" {
+		warnf("serviceAccountSelector is not supported, ignoring it")
+	}
+
+	p.PodSelector, p.NamespaceSelector, err = parseCalicoEndpointSelector(spec.Selector)
+	if err != nil {
+		return Policy{}, warnings, fmt.Errorf("%w: %s: selector: %w", ErrUnsupportedPolicy, p.String(), err)
+	}
+	if p.Namespace != "" {
+		p.NamespaceSelector = nil
+	} else if spec.NamespaceSelector != "" {
+		ns, err := parseCalicoNamespaceSelector(spec.NamespaceSelector)
+		if err != nil {
+			return Policy{}, warnings, fmt.Errorf("%w: %s: namespaceSelector: %w", ErrUnsupportedPolicy, p.String(), err)
+		}
+		p.NamespaceSelector = mergeSelectors(p.NamespaceSelector, ns)
+	}
+
+	types := spec.Types
+	if len(types) == 0 {
+		// as documented by Calico
+		switch {
+		case len(spec.Egress) == 0:
+			types = []string{"Ingress"}
+		case len(spec.Ingress) == 0:
+			types = []string{"Egress"}
+		default:
+			types = []string{"Ingress", "Egress"}
+		}
+	}
+	for _, t := range types {
+		switch t {
+		case "Ingress":
+			p.IsolatesIngress = true
+		case "Egress":
+			p.IsolatesEgress = true
+		}
+	}
+
+	for i, r := range spec.Ingress {
+		rule, ok, err := convertCalicoRule(Ingress, r, func(format string, a ...any) {
+			warnf("ingress rule %d: "+format, append([]any{i}, a...)...)
+		})
+		switch {
+		case errors.Is(err, errUnsupportedSelector):
+			warnf("ingress rule %d: %v, skipping the rule", i, err)
+		case err != nil:
+			return Policy{}, warnings, fmt.Errorf("%s: ingress rule %d: %w", p.String(), i, err)
+		case ok:
+			p.Ingress = append(p.Ingress, rule)
+		}
+	}
+	for i, r := range spec.Egress {
+		rule, ok, err := convertCalicoRule(Egress, r, func(format string, a ...any) {
+			warnf("egress rule %d: "+format, append([]any{i}, a...)...)
+		})
+		switch {
+		case errors.Is(err, errUnsupportedSelector):
+			warnf("egress rule %d: %v, skipping the rule", i, err)
+		case err != nil:
+			return Policy{}, warnings, fmt.Errorf("%s: egress rule %d: %w", p.String(), i, err)
+		case ok:
+			p.Egress = append(p.Egress, rule)
+		}
+	}
+
+	return p, warnings, validateSelectors(p)
+}
+
+// convertCalicoRule converts a rule, returning false if it must be
+// skipped.
+func convertCalicoRule(dir Direction, r calicoRule, warnf func(string, ...any)) (Rule, bool, error) {
+	var rule Rule
+
+	switch r.Action {
+	case "Allow":
+		rule.Action = ActionAllow
+	case "Deny":
+		rule.Action = ActionDeny
+	case "Pass":
+		rule.Action = ActionPass
+	case "Log":
+		return Rule{}, false, nil // doesn't affect the traffic
+	default:
+		return Rule{}, false, fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.NotProtocol != nil {
+		warnf("notProtocol is not supported, skipping the rule")
+		return Rule{}, false, nil
+	}
+
+	// the peer is the source of ingress traffic, and the destination
+	// of egress traffic, while the other side is the policy pods
+	peer, local := r.Source, r.Destination
+	if dir == Egress {
+		peer, local = r.Destination, r.Source
+	}
+
+	local.Ports = nil // destination ports are handled below
+	if !local.empty() {
+		warnf("matching the policy pods in rules is not supported, skipping the rule")
+		return Rule{}, false, nil
+	}
+	if len(r.Source.Ports) > 0 || len(r.Source.NotPorts) > 0 {
+		warnf("source ports are not supported, skipping the rule")
+		return Rule{}, false, nil
+	}
+
+	switch {
+	case len(peer.ServiceAccounts) > 0, len(peer.Services) > 0:
+		warnf("serviceAccounts and services are not supported, skipping the rule")
+		return Rule{}, false, nil
+	case peer.NotSelector != "", len(r.Destination.NotPorts) > 0:
+		warnf("notSelector and notPorts are not supported, skipping the rule")
+		return Rule{}, false, nil
+	case len(peer.NotNets) > 0 && len(peer.Nets) == 0:
+		warnf("notNets without nets is not supported, skipping the rule")
+		return Rule{}, false, nil
+	}
+
+	if len(peer.Nets) > 0 {
+		if peer.Selector != "" || peer.NamespaceSelector != "" {
+			warnf("nets combined with selectors are not supported, only using the selectors")
+		} else {
+			for _, n := range peer.Nets {
+				b, err := parseIPBlock(n, peer.NotNets)
+				if err != nil {
+					return Rule{}, false, err
+				}
+				rule.Peers = append(rule.Peers, Peer{IPBlock: b})
+			}
+		}
+	}
+
+	if peer.Selector != "" || peer.NamespaceSelector != "" {
+		pods, ns, err := parseCalicoEndpointSelector(peer.Selector)
+		if err != nil {
+			return Rule{}, false, fmt.Errorf("selector: %w", err)
+		}
+		if peer.NamespaceSelector != "" {
+			nsSel, err := parseCalicoNamespaceSelector(peer.NamespaceSelector)
+			if err != nil {
+				return Rule{}, false, fmt.Errorf("namespaceSelector: %w", err)
+			}
+			ns = mergeSelectors(ns, nsSel)
+		}
+		rule.Peers = append(rule.Peers, Peer{PodSelector: pods, NamespaceSelector: ns})
+	}
+
+	protocol, err := calicoProtocol(r.Protocol)
+	if err != nil {
+		return Rule{}, false, err
+	}
+
+	for _, port := range r.Destination.Ports {
+		p := Port{Protocol: protocol}
+		if protocol == "" {
+			return Rule{}, false, errors.New("ports require a protocol")
+		}
+		if port.Type == intstr.String {
+			if lo, hi, ok := strings.Cut(port.StrVal, ":"); ok {
+				start, end := intstr.Parse(lo), intstr.Parse(hi)
+				if start.Type != intstr.Int || end.Type != intstr.Int {
+					return Rule{}, false, fmt.Errorf("invalid port range %q", port.StrVal)
+				}
+				p.Port, p.EndPort = ptr.To(start), end.IntVal
+				rule.Ports = append(rule.Ports, p)
+				continue
+			}
+			port = intstr.Parse(port.StrVal)
+		}
+		p.Port = ptr.To(port)
+		rule.Ports = append(rule.Ports, p)
+	}
+	if len(rule.Ports) == 0 && protocol != "" {
+		rule.Ports = append(rule.Ports, Port{Protocol: protocol})
+	}
+
+	if r.HTTP != nil {
+		methods := r.HTTP.Methods
+		if len(methods) == 0 {
+			methods = []string{""} // all methods
+		}
+
+		var http []HTTPRule
+		for _, m := range methods {
+			method := regexp.QuoteMeta(m)
+			if len(r.HTTP.Paths) == 0 {
+				http = append(http, HTTPRule{Method: method})
+			}
+			for _, path := range r.HTTP.Paths {
+				h := HTTPRule{Method: method, Path: regexp.QuoteMeta(path.Exact)}
+				if path.Prefix != "" {
+					h.Path = regexp.QuoteMeta(path.Prefix) + ".*"
+				}
+				http = append(http, h)
+			}
+		}
+
+		if len(rule.Ports) == 0 {
+			rule.Ports = append(rule.Ports, Port{Protocol: corev1.ProtocolTCP})
+		}
+		for i := range rule.Ports {
+			rule.Ports[i].HTTP = http
+		}
+	}
+
+	return rule, true, nil
+}
+
+// calicoProtocol converts a Calico protocol, given by name or number.
+// A nil protocol returns a blank one, meaning all protocols.
+func calicoProtocol(p *intstr.IntOrString) (corev1.Protocol, error) {
+	if p == nil {
+		return "", nil
+	}
+
+	if p.Type == intstr.Int {
+		switch p.IntVal {
+		case 6:
+			return corev1.ProtocolTCP, nil
+		case 17:
+			return corev1.ProtocolUDP, nil
+		case 132:
+			return corev1.ProtocolSCTP, nil
+		default:
+			return corev1.Protocol(fmt.Sprint(p.IntVal)), nil
+		}
+	}
+
+	return corev1.Protocol(strings.ToUpper(p.StrVal)), nil
+}
+
+// parseCalicoEndpointSelector parses a Calico selector of endpoints,
+// returning the selectors of the pods and of their namespaces, which
+// Calico matches with the projectcalico.org/namespace label. A blank
+// selector matches all pods.
+func parseCalicoEndpointSelector(s string) (pods, namespaces *metav1.LabelSelector, err error) {
+	sel, err := ParseCalicoSelector(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pods = &metav1.LabelSelector{}
+	for k, v := range sel.MatchLabels {
+		if k == calicoNamespaceLabel {
+			namespaces = mergeSelectors(namespaces, &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: v}})
+			continue
+		}
+		if pods.MatchLabels == nil {
+			pods.MatchLabels = make(map[string]string)
+		}
+		pods.MatchLabels[k] = v
+	}
+	for _, e := range sel.MatchExpressions {
+		if e.Key == calicoNamespaceLabel {
+			e.Key = corev1.LabelMetadataName
+			namespaces = mergeSelectors(namespaces, &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{e}})
+			continue
+		}
+		pods.MatchExpressions = append(pods.MatchExpressions, e)
+	}
+
+	return pods, namespaces, nil
+}
+
+// parseCalicoNamespaceSelector parses a Calico selector of namespaces,
+// where the projectcalico.org/name label is the namespace name.
+func parseCalicoNamespaceSelector(s string) (*metav1.LabelSelector, error) {
+	sel, err := ParseCalicoSelector(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := sel.MatchLabels[calicoNameLabel]; ok {
+		delete(sel.MatchLabels, calicoNameLabel)
+		sel.MatchLabels[corev1.LabelMetadataName] = v
+	}
+	for i, e := range sel.MatchExpressions {
+		if e.Key == calicoNameLabel {
+			sel.MatchExpressions[i].Key = corev1.LabelMetadataName
+		}
+	}
+
+	return sel, nil
+}
+
+// mergeSelectors returns a selector matching what both a and b match.
+// A nil selector is ignored.
+func mergeSelectors(a, b *metav1.LabelSelector) *metav1.LabelSelector {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+
+	m := a.DeepCopy()
+	for k, v := range b.MatchLabels {
+		if m.MatchLabels == nil {
+			m.MatchLabels = make(map[string]string)
+		}
+		if old, ok := m.MatchLabels[k]; ok && old != v {
+			// both values can't match, so match nothing
+			m.MatchExpressions = append(m.MatchExpressions, metav1.LabelSelectorRequirement{Key: k, Operator: metav1.LabelSelectorOpIn, Values: []string{v}})
+			continue
+		}
+		m.MatchLabels[k] = v
+	}
+	m.MatchExpressions = append(m.MatchExpressions, b.MatchExpressions...)
+
+	return m
+}
+
+var errUnsupportedSelector = errors.New("unsupported Calico selector")
+
+// ParseCalicoSelector parses a Calico selector expression into a label
+// selector. Only conjunctions of the following terms are supported:
+//
+//	all()
+//	has(key), !has(key)
+//	key == 'value', key != 'value'
+//	key in {'a', 'b'}, key not in {'a', 'b'}
+//
+// A blank selector is the same as all().
+func ParseCalicoSelector(s string) (*metav1.LabelSelector, error) {
+	p := &calicoSelectorParser{tokens: tokenizeCalicoSelector(s)}
+
+	sel := &metav1.LabelSelector{}
+	if len(p.tokens) == 0 {
+		return sel, nil
+	}
+
+	for {
+		if err := p.term(sel); err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errUnsupportedSelector, s, err)
+		}
+		if p.done() {
+			break
+		}
+		if tok := p.next(); tok != "&&" {
+			return nil, fmt.Errorf("%w %q: unexpected %q", errUnsupportedSelector, s, tok)
+		}
+	}
+
+	return sel, nil
+}
+
+type calicoSelectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *calicoSelectorParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *calicoSelectorParser) next() string {
+	if p.done() {
+		return ""
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *calicoSelectorParser) expect(tokens ...string) error {
+	for _, want := range tokens {
+		if tok := p.next(); tok != want {
+			return fmt.Errorf("expecting %q, got %q", want, tok)
+		}
+	}
+	return nil
+}
+
+func (p *calicoSelectorParser) term(sel *metav1.LabelSelector) error {
+	tok := p.next()
+
+	switch tok {
+	case "all":
+		return p.expect("(", ")")
+
+	case "has", "!":
+		op := metav1.LabelSelectorOpExists
+		if tok == "!" {
+			op = metav1.LabelSelectorOpDoesNotExist
+			if err := p.expect("has"); err != nil {
+				return err
+			}
+		}
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		key := p.next()
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		sel.MatchExpressions = append(sel.MatchExpressions, metav1.LabelSelectorRequirement{Key: key, Operator: op})
+		return nil
+
+	case "", "(", ")", "&&", "||", "{", "}", ",", "==", "!=":
+		return fmt.Errorf("unexpected %q", tok)
+	}
+
+	key := tok
+	switch op := p.next(); op {
+	case "==", "!=":
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		if op == "==" {
+			if sel.MatchLabels == nil {
+				sel.MatchLabels = make(map[string]string)
+			}
+			if old, ok := sel.MatchLabels[key]; ok && old != v {
+				sel.MatchExpressions = append(sel.MatchExpressions, metav1.LabelSelectorRequirement{Key: key, Operator: metav1.LabelSelectorOpIn, Values: []string{v}})
+				return nil
+			}
+			sel.MatchLabels[key] = v
+		} else {
+			sel.MatchExpressions = append(sel.MatchExpressions, metav1.LabelSelectorRequirement{Key: key, Operator: metav1.LabelSelectorOpNotIn, Values: []string{v}})
+		}
+
+	case "in", "not":
+		opr := metav1.LabelSelectorOpIn
+		if op == "not" {
+			opr = metav1.LabelSelectorOpNotIn
+			if err := p.expect("in"); err != nil {
+				return err
+			}
+		}
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		var values []string
+		for {
+			v, err := p.value()
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+			if tok := p.next(); tok == "}" {
+				break
+			} else if tok != "," {
+				return fmt.Errorf("expecting \",\" or \"}\", got %q", tok)
+			}
+		}
+		sel.MatchExpressions = append(sel.MatchExpressions, metav1.LabelSelectorRequirement{Key: key, Operator: opr, Values: values})
+
+	default:
+		return fmt.Errorf("unsupported operator %q", op)
+	}
+
+	return nil
+}
+
+func (p *calicoSelectorParser) value() (string, error) {
+	tok := p.next()
+	if len(tok) < 2 || (tok[0] != '\'' && tok[0] != '"') || tok[len(tok)-1] != tok[0] {
+		return "", fmt.Errorf("expecting a quoted value, got %q", tok)
+	}
+	return tok[1 : len(tok)-1], nil
+}
+
+// tokenizeCalicoSelector splits a selector into identifiers, quoted
+// strings and operators.
+func tokenizeCalicoSelector(s string) []string {
+	var tokens []string
+
+	isIdent := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_./-", r)
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				tokens = append(tokens, s[i:])
+				return tokens
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case isIdent(rune(c)):
+			j := i
+			for j < len(s) && isIdent(rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			tokens = append(tokens, s[i:i+1])
+			i++
+		}
+	}
+
+	return tokens
+}
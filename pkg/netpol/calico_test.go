@@ -0,0 +1,189 @@
+package netpol
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseCalicoSelector(t *testing.T) {
+	tests := map[string]struct {
+		selector string
+		exp      *metav1.LabelSelector
+		err      bool
+	}{
+		"blank": {"", &metav1.LabelSelector{}, false},
+		"all":   {"all()", &metav1.LabelSelector{}, false},
+		"equal": {"app == 'cart'", &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cart"}}, false},
+		"conjunction": {`app == "cart" && has(tier) && !has(canary) && env != 'dev'`, &metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "cart"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpExists},
+				{Key: "canary", Operator: metav1.LabelSelectorOpDoesNotExist},
+				{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+			},
+		}, false},
+		"sets": {"app in {'cart', 'redis'} && team not in {'x'}", &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"cart", "redis"}},
+				{Key: "team", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"x"}},
+			},
+		}, false},
+		"prefixed key":  {"projectcalico.org/name == 'shop'", &metav1.LabelSelector{MatchLabels: map[string]string{"projectcalico.org/name": "shop"}}, false},
+		"disjunction":   {"app == 'a' || app == 'b'", nil, true},
+		"global":        {"global()", nil, true},
+		"unquoted":      {"app == cart", nil, true},
+		"unterminated":  {"app in {'a'", nil, true},
+		"starts with":   {"app starts with 'a'", nil, true},
+		"trailing conj": {"app == 'a' &&", nil, true},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			sel, err := ParseCalicoSelector(tt.selector)
+			if tt.err {
+				if !errors.Is(err, errUnsupportedSelector) {
+					t.Fatalf("expecting unsupported selector error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tt.exp, sel) {
+				t.Fatalf("expecting %+v, got %+v", tt.exp, sel)
+			}
+		})
+	}
+}
+
+func TestFromCalicoPolicy(t *testing.T) {
+	const manifests = `
+apiVersion: projectcalico.org/v3
+kind: NetworkPolicy
+metadata:
+  name: cart
+  namespace: shop
+spec:
+  order: 100
+  selector: app == 'cart'
+  ingress:
+  - action: Deny
+    source:
+      selector: app == 'frontend' && canary == 'true'
+  - action: Allow
+    protocol: TCP
+    source:
+      selector: app == 'frontend'
+    destination:
+      ports: [7070, "8000:8080"]
+    http:
+      methods: [GET]
+      paths:
+      - prefix: /api/
+  - action: Log
+  - action: Allow
+    source:
+      selector: app == 'a' || app == 'b'
+---
+apiVersion: crd.projectcalico.org/v1
+kind: GlobalNetworkPolicy
+metadata:
+  name: deny-sandbox-egress
+spec:
+  namespaceSelector: projectcalico.org/name == 'sandbox'
+  types: [Egress]
+  egress:
+  - action: Pass
+    destination:
+      nets: [10.0.0.0/8]
+      notNets: [10.1.0.0/16]
+  - action: Deny
+---
+apiVersion: projectcalico.org/v3
+kind: GlobalNetworkPolicy
+metadata:
+  name: host
+spec:
+  preDNAT: true
+  selector: has(host)
+`
+
+	var inv Inventory
+	if err := inv.ReadManifests(strings.NewReader(manifests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(inv.Policies) != 2 {
+		t.Fatalf("expecting 2 policies, got %d: %+v", len(inv.Policies), inv.Policies)
+	}
+	if len(inv.Warnings) != 2 || !strings.Contains(inv.Warnings[0], "ingress rule 3") || !strings.Contains(inv.Warnings[1], "host endpoints") {
+		t.Errorf("unexpected warnings: %q", inv.Warnings)
+	}
+
+	cart := inv.Policies[0]
+	if cart.Kind != "CalicoNetworkPolicy" || cart.Namespace != "shop" || *cart.Order != 100 || !cart.IsolatesIngress || cart.IsolatesEgress {
+		t.Errorf("unexpected policy %+v", cart)
+	}
+	if len(cart.Ingress) != 2 || cart.Ingress[0].Action != ActionDeny || cart.Ingress[1].Action != ActionAllow {
+		t.Fatalf("unexpected ingress rules %+v", cart.Ingress)
+	}
+	allow := cart.Ingress[1]
+	if len(allow.Ports) != 2 || allow.Ports[1].Port.IntVal != 8000 || allow.Ports[1].EndPort != 8080 {
+		t.Errorf("unexpected ports %+v", allow.Ports)
+	}
+	if e, g := []HTTPRule{{Method: "GET", Path: "/api/.*"}}, allow.Ports[0].HTTP; !reflect.DeepEqual(e, g) {
+		t.Errorf("expecting HTTP rules %v, got %v", e, g)
+	}
+
+	global := inv.Policies[1]
+	if global.Namespace != "" || SelectorString(global.NamespaceSelector) != corev1.LabelMetadataName+"=sandbox" || !global.IsolatesEgress || global.IsolatesIngress {
+		t.Errorf("unexpected policy %+v", global)
+	}
+
+	sandbox := Endpoint{
+		Namespace:       "sandbox",
+		NamespaceLabels: map[string]string{corev1.LabelMetadataName: "sandbox"},
+		Labels:          map[string]string{"app": "test"},
+	}
+	frontend := pod("shop", map[string]string{"app": "frontend"})
+	canary := pod("shop", map[string]string{"app": "frontend", "canary": "true"})
+	cartEP := pod("shop", map[string]string{"app": "cart"})
+
+	tests := map[string]struct {
+		dir           Direction
+		subject, peer Endpoint
+		port          int32
+		req           *HTTPRequest
+		allowed       bool
+	}{
+		"allowed":             {Ingress, cartEP, frontend, 7070, nil, true},
+		"allowed range":       {Ingress, cartEP, frontend, 8080, nil, true},
+		"denied first":        {Ingress, cartEP, canary, 7070, nil, false},
+		"not matching":        {Ingress, cartEP, frontend, 9090, nil, false},
+		"http allowed":        {Ingress, cartEP, frontend, 7070, &HTTPRequest{Method: "GET", Path: "/api/carts"}, true},
+		"http denied":         {Ingress, cartEP, frontend, 7070, &HTTPRequest{Method: "POST", Path: "/api/carts"}, false},
+		"passed":              {Egress, sandbox, Endpoint{IP: mustAddr("10.0.0.1")}, 443, nil, true},
+		"not passed":          {Egress, sandbox, Endpoint{IP: mustAddr("10.1.0.1")}, 443, nil, false},
+		"other namespace":     {Egress, frontend, Endpoint{IP: mustAddr("10.1.0.1")}, 443, nil, true},
+		"global denied to ns": {Egress, sandbox, cartEP, 7070, nil, false},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			d := EvaluateHTTP(inv.Policies, tt.dir, tt.subject, tt.peer, tt.port, corev1.ProtocolTCP, tt.req)
+			if tt.allowed != d.Allowed() {
+				t.Fatalf("expecting allowed %v, got %+v", tt.allowed, d)
+			}
+		})
+	}
+}
+
+func mustAddr(s string) netip.Addr {
+	return netip.MustParseAddr(s)
+}
@@ -0,0 +1,410 @@
+package netpol
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// CiliumGroup is the API group of the Cilium policies.
+const CiliumGroup = "cilium.io"
+
+// Label keys Cilium uses in endpoint selectors for the namespace of
+// pods, and the labels of their namespace.
+const (
+	ciliumNamespaceLabel       = "io.kubernetes.pod.namespace"
+	ciliumNamespaceLabelPrefix = "io.cilium.k8s.namespace.labels."
+	ciliumPolicyLabelPrefix    = "io.cilium.k8s.policy."
+)
+
+type ciliumPolicy struct {
+	Kind     string            `json:"kind"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     *ciliumRule       `json:"spec"`
+	Specs    []ciliumRule      `json:"specs"`
+}
+
+type ciliumRule struct {
+	EndpointSelector  *metav1.LabelSelector `json:"endpointSelector"`
+	NodeSelector      *metav1.LabelSelector `json:"nodeSelector"`
+	Ingress           []ciliumPeerRule      `json:"ingress"`
+	IngressDeny       []ciliumPeerRule      `json:"ingressDeny"`
+	Egress            []ciliumPeerRule      `json:"egress"`
+	EgressDeny        []ciliumPeerRule      `json:"egressDeny"`
+	EnableDefaultDeny struct {
+		Ingress *bool `json:"ingress"`
+		Egress  *bool `json:"egress"`
+	} `json:"enableDefaultDeny"`
+}
+
+// ciliumPeerRule holds the fields of both ingress and egress rules.
+type ciliumPeerRule struct {
+	FromEndpoints []metav1.LabelSelector `json:"fromEndpoints"`
+	ToEndpoints   []metav1.LabelSelector `json:"toEndpoints"`
+	FromCIDR      []string               `json:"fromCIDR"`
+	ToCIDR        []string               `json:"toCIDR"`
+	FromCIDRSet   []ciliumCIDRRule       `json:"fromCIDRSet"`
+	ToCIDRSet     []ciliumCIDRRule       `json:"toCIDRSet"`
+	FromEntities  []string               `json:"fromEntities"`
+	ToEntities    []string               `json:"toEntities"`
+	ToFQDNs       []struct {
+		MatchName    string `json:"matchName"`
+		MatchPattern string `json:"matchPattern"`
+	} `json:"toFQDNs"`
+	ToPorts []ciliumPortRule `json:"toPorts"`
+
+	// unsupported peers
+	FromRequires json.RawMessage `json:"fromRequires"`
+	ToRequires   json.RawMessage `json:"toRequires"`
+	FromGroups   json.RawMessage `json:"fromGroups"`
+	ToGroups     json.RawMessage `json:"toGroups"`
+	FromNodes    json.RawMessage `json:"fromNodes"`
+	ToNodes      json.RawMessage `json:"toNodes"`
+	ToServices   json.RawMessage `json:"toServices"`
+	ICMPs        json.RawMessage `json:"icmps"`
+}
+
+type ciliumPort struct {
+	Port     string `json:"port"`
+	EndPort  int32  `json:"endPort"`
+	Protocol string `json:"protocol"`
+}
+
+type ciliumCIDRRule struct {
+	CIDR         string          `json:"cidr"`
+	Except       []string        `json:"except"`
+	CIDRGroupRef json.RawMessage `json:"cidrGroupRef"`
+}
+
+type ciliumPortRule struct {
+	Ports []ciliumPort `json:"ports"`
+	Rules *struct {
+		HTTP []struct {
+			Method  string          `json:"method"`
+			Path    string          `json:"path"`
+			Host    string          `json:"host"`
+			Headers json.RawMessage `json:"headers"`
+		} `json:"http"`
+		DNS   json.RawMessage `json:"dns"`
+		Kafka json.RawMessage `json:"kafka"`
+	} `json:"rules"`
+}
+
+// FromCiliumPolicy converts a CiliumNetworkPolicy or
+// CiliumClusterwideNetworkPolicy. Each of the rules in spec and specs is
+// converted to its own policy, named after the object and the index of
+// the rule when there are many.
+//
+// Parts of the policy that cannot be modeled are skipped, and reported
+// in the returned warnings.
+func FromCiliumPolicy(obj *unstructured.Unstructured) ([]Policy, []string, error) {
+	raw, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	var cp ciliumPolicy
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return nil, nil, fmt.Errorf("decoding %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	rules := cp.Specs
+	if cp.Spec != nil {
+		rules = append([]ciliumRule{*cp.Spec}, rules...)
+	}
+
+	var (
+		policies []Policy
+		warnings []string
+	)
+
+	for i, r := range rules {
+		p := Policy{
+			Kind: cp.Kind,
+			Name: cp.Metadata.Name,
+		}
+		if cp.Kind == "CiliumNetworkPolicy" {
+			p.Namespace = cmp.Or(cp.Metadata.Namespace, corev1.NamespaceDefault)
+		}
+		if len(rules) > 1 {
+			p.Name = fmt.Sprintf("%s[%d]", p.Name, i)
+		}
+
+		warnf := func(format string, a ...any) {
+			warnings = append(warnings, fmt.Sprintf("%s: ", p.String())+fmt.Sprintf(format, a...))
+		}
+
+		if r.EndpointSelector == nil {
+			warnf("host policies are not supported, skipping")
+			continue
+		}
+
+		var ns *metav1.LabelSelector
+		p.PodSelector, ns = convertCiliumSelector(*r.EndpointSelector, warnf)
+		if p.Namespace == "" {
+			p.NamespaceSelector = ns
+		}
+
+		p.IsolatesIngress = (r.Ingress != nil || r.IngressDeny != nil) && ptr.Deref(r.EnableDefaultDeny.Ingress, true)
+		p.IsolatesEgress = (r.Egress != nil || r.EgressDeny != nil) && ptr.Deref(r.EnableDefaultDeny.Egress, true)
+
+		for _, rs := range []struct {
+			dir    Direction
+			action Action
+			rules  []ciliumPeerRule
+		}{
+			{Ingress, ActionAllow, r.Ingress},
+			{Ingress, ActionDeny, r.IngressDeny},
+			{Egress, ActionAllow, r.Egress},
+			{Egress, ActionDeny, r.EgressDeny},
+		} {
+			for j, cr := range rs.rules {
+				rule, ok, err := convertCiliumRule(rs.dir, cr, func(format string, a ...any) {
+					warnf("%s rule %d: "+format, append([]any{rs.dir, j}, a...)...)
+				})
+				if err != nil {
+					return nil, warnings, fmt.Errorf("%s: %s rule %d: %w", p.String(), rs.dir, j, err)
+				}
+				if !ok {
+					continue
+				}
+
+				rule.Action = rs.action
+				if rs.dir == Ingress {
+					p.Ingress = append(p.Ingress, rule)
+				} else {
+					p.Egress = append(p.Egress, rule)
+				}
+			}
+		}
+
+		if err := validateSelectors(p); err != nil {
+			return nil, warnings, err
+		}
+
+		policies = append(policies, p)
+	}
+
+	return policies, warnings, nil
+}
+
+// convertCiliumRule converts an ingress or egress rule, returning false
+// if it must be skipped.
+func convertCiliumRule(dir Direction, r ciliumPeerRule, warnf func(string, ...any)) (Rule, bool, error) {
+	endpoints, cidrs, cidrSets, entities := r.FromEndpoints, r.FromCIDR, r.FromCIDRSet, r.FromEntities
+	requires, unsupported := r.FromRequires, map[string]json.RawMessage{
+		"fromGroups": r.FromGroups,
+		"fromNodes":  r.FromNodes,
+	}
+	if dir == Egress {
+		endpoints, cidrs, cidrSets, entities = r.ToEndpoints, r.ToCIDR, r.ToCIDRSet, r.ToEntities
+		requires, unsupported = r.ToRequires, map[string]json.RawMessage{
+			"toGroups":   r.ToGroups,
+			"toNodes":    r.ToNodes,
+			"toServices": r.ToServices,
+		}
+	}
+
+	if len(requires) > 0 {
+		warnf("requirements are not supported, ignoring them")
+	}
+	if len(r.ICMPs) > 0 {
+		if len(r.ToPorts) == 0 {
+			// no ports would mean all ports
+			warnf("ICMP rules are not supported, skipping the rule")
+			return Rule{}, false, nil
+		}
+		warnf("ICMP rules are not supported, ignoring them")
+	}
+
+	var (
+		rule             Rule
+		hasPeers         bool // the rule has peers, even if unsupported
+		unsupportedPeers []string
+	)
+
+	for name, v := range unsupported {
+		if len(v) > 0 {
+			hasPeers = true
+			unsupportedPeers = append(unsupportedPeers, name)
+		}
+	}
+
+	for _, sel := range endpoints {
+		hasPeers = true
+		pods, ns := convertCiliumSelector(sel, warnf)
+		rule.Peers = append(rule.Peers, Peer{PodSelector: pods, NamespaceSelector: ns})
+	}
+
+	for _, c := range cidrs {
+		hasPeers = true
+		b, err := parseIPBlock(normalizeCIDR(c), nil)
+		if err != nil {
+			return Rule{}, false, err
+		}
+		rule.Peers = append(rule.Peers, Peer{IPBlock: b})
+	}
+	for _, c := range cidrSets {
+		hasPeers = true
+		if c.CIDR == "" {
+			unsupportedPeers = append(unsupportedPeers, "cidrGroupRef")
+			continue
+		}
+		except := make([]string, len(c.Except))
+		for i, e := range c.Except {
+			except[i] = normalizeCIDR(e)
+		}
+		b, err := parseIPBlock(normalizeCIDR(c.CIDR), except)
+		if err != nil {
+			return Rule{}, false, err
+		}
+		rule.Peers = append(rule.Peers, Peer{IPBlock: b})
+	}
+
+	for _, e := range entities {
+		hasPeers = true
+		switch entity := Entity(e); entity {
+		case EntityAll, EntityCluster, EntityWorld:
+			rule.Peers = append(rule.Peers, Peer{Entity: entity})
+		default:
+			unsupportedPeers = append(unsupportedPeers, "entity "+e)
+		}
+	}
+
+	if dir == Egress {
+		for _, f := range r.ToFQDNs {
+			hasPeers = true
+			rule.Peers = append(rule.Peers, Peer{FQDN: cmp.Or(f.MatchName, f.MatchPattern)})
+		}
+	}
+
+	if len(unsupportedPeers) > 0 {
+		slices.Sort(unsupportedPeers)
+		warnf("unsupported peers %s are ignored", strings.Join(unsupportedPeers, ", "))
+	}
+	if hasPeers && len(rule.Peers) == 0 {
+		// no peers would mean all peers
+		warnf("no supported peers, skipping the rule")
+		return Rule{}, false, nil
+	}
+
+	for _, pr := range r.ToPorts {
+		var http []HTTPRule
+		if pr.Rules != nil {
+			for _, h := range pr.Rules.HTTP {
+				if len(h.Headers) > 0 {
+					warnf("HTTP header rules are not supported, ignoring them")
+				}
+				http = append(http, HTTPRule{Method: h.Method, Path: h.Path, Host: h.Host})
+			}
+			if len(pr.Rules.Kafka) > 0 {
+				warnf("Kafka rules are not supported, ignoring them")
+			}
+		}
+
+		ports := pr.Ports
+		if len(ports) == 0 {
+			ports = []ciliumPort{{Port: "0"}} // all ports
+		}
+
+		for _, cp := range ports {
+			protocols := []corev1.Protocol{corev1.Protocol(strings.ToUpper(cp.Protocol))}
+			if protocols[0] == "" || protocols[0] == "ANY" {
+				protocols = []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP}
+			}
+
+			for _, protocol := range protocols {
+				p := Port{Protocol: protocol, HTTP: http}
+				switch n, err := strconv.ParseInt(cp.Port, 10, 32); {
+				case err != nil:
+					p.Port = ptr.To(intstr.FromString(cp.Port))
+				case n != 0:
+					p.Port = ptr.To(intstr.FromInt32(int32(n)))
+					p.EndPort = cp.EndPort
+				}
+				rule.Ports = append(rule.Ports, p)
+			}
+		}
+	}
+
+	return rule, true, nil
+}
+
+// convertCiliumSelector converts a Cilium endpoint selector into the
+// selectors of the pods and their namespaces. The namespace selector is
+// nil if the endpoint selector doesn't select namespaces.
+func convertCiliumSelector(sel metav1.LabelSelector, warnf func(string, ...any)) (pods, namespaces *metav1.LabelSelector) {
+	pods = &metav1.LabelSelector{}
+
+	// key returns the label key and whether it selects pods or their
+	// namespace, or false if it is not supported
+	key := func(k string) (string, bool, bool) {
+		// labels of Kubernetes pods have a source prefix
+		k = strings.TrimPrefix(strings.TrimPrefix(k, "k8s:"), "any:")
+
+		switch {
+		case k == ciliumNamespaceLabel:
+			return corev1.LabelMetadataName, true, true
+		case strings.HasPrefix(k, ciliumNamespaceLabelPrefix):
+			return strings.TrimPrefix(k, ciliumNamespaceLabelPrefix), true, true
+		case strings.HasPrefix(k, ciliumPolicyLabelPrefix), strings.Contains(k, ":"):
+			warnf("label %s is not supported, ignoring it", k)
+			return "", false, false
+		default:
+			return k, false, true
+		}
+	}
+
+	addNamespace := func(ns *metav1.LabelSelector) {
+		namespaces = mergeSelectors(namespaces, ns)
+	}
+
+	for k, v := range sel.MatchLabels {
+		k, isNamespace, ok := key(k)
+		switch {
+		case !ok:
+		case isNamespace:
+			addNamespace(&metav1.LabelSelector{MatchLabels: map[string]string{k: v}})
+		default:
+			if pods.MatchLabels == nil {
+				pods.MatchLabels = make(map[string]string)
+			}
+			pods.MatchLabels[k] = v
+		}
+	}
+
+	for _, e := range sel.MatchExpressions {
+		k, isNamespace, ok := key(e.Key)
+		e.Key = k
+		switch {
+		case !ok:
+		case isNamespace:
+			addNamespace(&metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{e}})
+		default:
+			pods.MatchExpressions = append(pods.MatchExpressions, e)
+		}
+	}
+
+	return pods, namespaces
+}
+
+// normalizeCIDR turns a single address into a CIDR, as Cilium accepts
+// both.
+func normalizeCIDR(s string) string {
+	if strings.Contains(s, "/") {
+		return s
+	}
+	if strings.Contains(s, ":") {
+		return s + "/128"
+	}
+	return s + "/32"
+}
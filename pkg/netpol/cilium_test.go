@@ -0,0 +1,198 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFromCiliumPolicy(t *testing.T) {
+	const manifests = `
+apiVersion: cilium.io/v2
+kind: CiliumNetworkPolicy
+metadata:
+  name: frontend
+  namespace: shop
+spec:
+  endpointSelector:
+    matchLabels:
+      app: frontend
+  egress:
+  - toEndpoints:
+    - matchLabels:
+        k8s:app: cart
+    toPorts:
+    - ports:
+      - port: "7070"
+        protocol: TCP
+      rules:
+        http:
+        - method: GET
+          path: /api/.*
+  - toEndpoints:
+    - matchLabels:
+        k8s:io.kubernetes.pod.namespace: kube-system
+        k8s-app: kube-dns
+    toPorts:
+    - ports:
+      - port: "53"
+        protocol: ANY
+  - toFQDNs:
+    - matchName: grafana.com
+    - matchPattern: "*.grafana.net"
+    toPorts:
+    - ports:
+      - port: "443"
+  - toServices:
+    - k8sService:
+        serviceName: redis
+        namespace: shop
+  egressDeny:
+  - toCIDRSet:
+    - cidr: 169.254.169.254/32
+---
+apiVersion: cilium.io/v2
+kind: CiliumClusterwideNetworkPolicy
+metadata:
+  name: monitoring
+specs:
+- endpointSelector:
+    matchLabels:
+      io.cilium.k8s.namespace.labels.team: shop
+  ingress:
+  - fromEntities: [cluster]
+    toPorts:
+    - ports:
+      - port: metrics
+- nodeSelector: {}
+  ingress:
+  - fromEntities: [world]
+`
+
+	var inv Inventory
+	if err := inv.ReadManifests(strings.NewReader(manifests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(inv.Policies) != 2 {
+		t.Fatalf("expecting 2 policies, got %d: %+v", len(inv.Policies), inv.Policies)
+	}
+	if len(inv.Warnings) != 3 {
+		t.Errorf("expecting 3 warnings, got %q", inv.Warnings)
+	}
+
+	frontend := inv.Policies[0]
+	if frontend.Namespace != "shop" || frontend.IsolatesIngress || !frontend.IsolatesEgress || frontend.Order != nil {
+		t.Errorf("unexpected policy %+v", frontend)
+	}
+	// the toServices rule is skipped
+	if len(frontend.Egress) != 4 || frontend.Egress[3].Action != ActionDeny {
+		t.Fatalf("unexpected egress rules %+v", frontend.Egress)
+	}
+	if e, g := "pods k8s-app=kube-dns in namespaces "+corev1.LabelMetadataName+"=kube-system", frontend.Egress[1].Peers[0].String(); e != g {
+		t.Errorf("expecting peer %q, got %q", e, g)
+	}
+	if n := len(frontend.Egress[1].Ports); n != 3 {
+		t.Errorf("expecting ANY to be converted to 3 protocols, got %d", n)
+	}
+
+	monitoring := inv.Policies[1]
+	if monitoring.Name != "monitoring[0]" || monitoring.Namespace != "" || !monitoring.IsolatesIngress {
+		t.Errorf("unexpected policy %+v", monitoring)
+	}
+	if e, g := "team=shop", SelectorString(monitoring.NamespaceSelector); e != g {
+		t.Errorf("expecting namespace selector %q, got %q", e, g)
+	}
+
+	src := pod("shop", map[string]string{"app": "frontend"})
+	src.NamespaceLabels["team"] = "shop"
+	cart := pod("shop", map[string]string{"app": "cart"})
+	cart.NamespaceLabels["team"] = "shop"
+	cart.NamedPorts = map[string]int32{"metrics": 9090}
+	dns := pod("kube-system", map[string]string{"k8s-app": "kube-dns"})
+
+	tests := map[string]struct {
+		dir           Direction
+		subject, peer Endpoint
+		port          int32
+		protocol      corev1.Protocol
+		req           *HTTPRequest
+		allowed, http bool
+	}{
+		"tcp to cart":        {Egress, src, cart, 7070, corev1.ProtocolTCP, nil, true, false},
+		"http allowed":       {Egress, src, cart, 7070, corev1.ProtocolTCP, &HTTPRequest{Method: "GET", Path: "/api/carts"}, true, false},
+		"http denied":        {Egress, src, cart, 7070, corev1.ProtocolTCP, &HTTPRequest{Method: "GET", Path: "/admin"}, false, true},
+		"dns":                {Egress, src, dns, 53, corev1.ProtocolUDP, nil, true, false},
+		"fqdn":               {Egress, src, Endpoint{Name: "grafana.com"}, 443, corev1.ProtocolTCP, nil, true, false},
+		"fqdn pattern":       {Egress, src, Endpoint{Name: "prometheus.grafana.net"}, 443, corev1.ProtocolTCP, nil, true, false},
+		"fqdn mismatch":      {Egress, src, Endpoint{Name: "example.com"}, 443, corev1.ProtocolTCP, nil, false, false},
+		"deny":               {Egress, src, Endpoint{IP: mustAddr("169.254.169.254")}, 80, corev1.ProtocolTCP, nil, false, false},
+		"entity cluster":     {Ingress, cart, dns, 9090, corev1.ProtocolTCP, nil, true, false},
+		"entity cluster ext": {Ingress, cart, Endpoint{IP: mustAddr("192.0.2.1")}, 9090, corev1.ProtocolTCP, nil, false, false},
+		"other namespace":    {Ingress, dns, cart, 53, corev1.ProtocolUDP, nil, true, false},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			d := EvaluateHTTP(inv.Policies, tt.dir, tt.subject, tt.peer, tt.port, tt.protocol, tt.req)
+			if tt.allowed != d.Allowed() {
+				t.Fatalf("expecting allowed %v, got %+v", tt.allowed, d)
+			}
+			if tt.http != d.DeniesHTTP() {
+				t.Fatalf("expecting HTTP denied %v, got %+v", tt.http, d)
+			}
+		})
+	}
+}
+
+func TestMatchFQDN(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		exp           bool
+	}{
+		{"grafana.com", "grafana.com", true},
+		{"grafana.com", "Grafana.com.", true},
+		{"grafana.com", "www.grafana.com", false},
+		{"*.grafana.com", "www.grafana.com", true},
+		{"*.grafana.com", "grafana.com", false},
+		{"*.grafana.com", "a.b.grafana.com", false},
+		{"api-*.grafana.com", "api-eu.grafana.com", true},
+		{"*", "example.com", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchFQDN(tt.pattern, tt.name); tt.exp != got {
+			t.Errorf("%s %s: expecting %v, got %v", tt.pattern, tt.name, tt.exp, got)
+		}
+	}
+}
+
+func TestConvertCiliumSelector(t *testing.T) {
+	sel := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"any:app":                             "cart",
+			"k8s:io.kubernetes.pod.namespace":     "shop",
+			"io.cilium.k8s.policy.serviceaccount": "cart",
+		},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "io.cilium.k8s.namespace.labels.team", Operator: metav1.LabelSelectorOpIn, Values: []string{"shop"}},
+		},
+	}
+
+	var warnings []string
+	pods, ns := convertCiliumSelector(sel, func(format string, a ...any) {
+		warnings = append(warnings, format)
+	})
+
+	if e, g := "app=cart", SelectorString(pods); e != g {
+		t.Errorf("expecting pods selector %q, got %q", e, g)
+	}
+	if e, g := corev1.LabelMetadataName+"=shop,team in (shop)", SelectorString(ns); e != g {
+		t.Errorf("expecting namespace selector %q, got %q", e, g)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expecting a warning, got %q", warnings)
+	}
+}
@@ -0,0 +1,154 @@
+package netpol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CoverageItem is a part of a policy that connections can exercise:
+// a peer and port of one of its rules, or the isolation of the pods it
+// selects, exercised by connections it denies.
+type CoverageItem struct {
+	Policy    *Policy
+	Direction Direction
+	// Rule is the index of the rule, or -1 for the isolation of the
+	// policy pods.
+	Rule int
+	// Peer and Port are the indexes in the rule, or -1 if the rule has
+	// no peers or ports.
+	Peer int
+	Port int
+
+	// Hits is the number of connections exercising the item.
+	Hits int
+}
+
+// IsIsolation returns whether the item is the isolation of the policy
+// pods rather than a rule.
+func (c CoverageItem) IsIsolation() bool {
+	return c.Rule < 0
+}
+
+func (c CoverageItem) String() string {
+	if c.IsIsolation() {
+		return fmt.Sprintf("%s isolation (denied traffic)", c.Direction)
+	}
+
+	rule := c.Policy.Rules(c.Direction)[c.Rule]
+
+	peer := "any peer"
+	if c.Peer >= 0 {
+		peer = rule.Peers[c.Peer].String()
+	}
+	port := "any port"
+	if c.Port >= 0 {
+		port = "port " + rule.Ports[c.Port].String()
+	}
+
+	prep := "to"
+	if c.Direction == Ingress {
+		prep = "from"
+	}
+
+	return fmt.Sprintf("%s rule %d %s %s, %s", c.Direction, c.Rule, prep, strings.TrimSpace(peer), port)
+}
+
+type coverageKey struct {
+	policy     *Policy
+	dir        Direction
+	rule, peer int
+	port       int
+}
+
+// Coverage tracks which items of a set of policies are exercised by
+// evaluated connections.
+type Coverage struct {
+	Items []CoverageItem
+
+	index map[coverageKey]int
+}
+
+// NewCoverage returns a Coverage for the given policies, which must be
+// the ones verdicts are evaluated with.
+func NewCoverage(policies []Policy) *Coverage {
+	c := &Coverage{index: make(map[coverageKey]int)}
+
+	add := func(p *Policy, dir Direction, rule, peer, port int) {
+		c.index[coverageKey{p, dir, rule, peer, port}] = len(c.Items)
+		c.Items = append(c.Items, CoverageItem{Policy: p, Direction: dir, Rule: rule, Peer: peer, Port: port})
+	}
+
+	for i := range policies {
+		p := &policies[i]
+		for _, dir := range []Direction{Ingress, Egress} {
+			if p.Isolates(dir) {
+				add(p, dir, -1, -1, -1)
+			}
+			for j, r := range p.Rules(dir) {
+				for _, peer := range indexes(len(r.Peers)) {
+					for _, port := range indexes(len(r.Ports)) {
+						add(p, dir, j, peer, port)
+					}
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// indexes returns the indexes of a list of n items, or -1 for an empty
+// list.
+func indexes(n int) []int {
+	if n == 0 {
+		return []int{-1}
+	}
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// Record marks the items exercised by the evaluated connection as hit.
+func (c *Coverage) Record(v Verdict) {
+	hit := make(map[int]bool)
+
+	for _, r := range v.Rules {
+		for _, peer := range orAll(r.Peers) {
+			for _, port := range orAll(r.Ports) {
+				if i, ok := c.index[coverageKey{r.Policy, r.Direction, r.Index, peer, port}]; ok {
+					hit[i] = true
+				}
+			}
+		}
+	}
+	for _, d := range v.Denying {
+		if i, ok := c.index[coverageKey{d.Policy, d.Direction, -1, -1, -1}]; ok {
+			hit[i] = true
+		}
+	}
+
+	// count each item once per connection
+	for i := range hit {
+		c.Items[i].Hits++
+	}
+}
+
+func orAll(idx []int) []int {
+	if len(idx) == 0 {
+		return []int{-1}
+	}
+	return idx
+}
+
+// Covered returns the number of items exercised at least once, and the
+// total number of items.
+func (c *Coverage) Covered() (covered, total int) {
+	for _, item := range c.Items {
+		if item.Hits > 0 {
+			covered++
+		}
+	}
+	return covered, len(c.Items)
+}
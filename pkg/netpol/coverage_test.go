@@ -0,0 +1,56 @@
+package netpol
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCoverage(t *testing.T) {
+	inv := testInventory(t)
+	ev := NewEvaluator(inv)
+	cov := NewCoverage(inv.Policies)
+
+	frontend, prometheus := &inv.Pods[0], &inv.Pods[4]
+
+	for _, c := range []struct {
+		src  *corev1.Pod
+		host string
+		port int32
+	}{
+		{frontend, "cart.shop", 80},
+		{frontend, "10.0.0.2", 7070},
+		{prometheus, "cart.shop", 80},
+		{prometheus, "grafana.com", 443}, // not isolated
+	} {
+		cov.Record(ev.Evaluate(c.src, c.host, c.port, corev1.ProtocolTCP))
+	}
+
+	expected := []struct {
+		item string
+		hits int
+	}{
+		{"ingress isolation (denied traffic)", 1},
+		{"ingress rule 0 from pods app=frontend, port TCP/7070", 2},
+		{"egress isolation (denied traffic)", 0},
+		{"egress rule 0 to pods app=cart, any port", 2},
+		{"egress rule 1 to ipBlock 192.0.2.0/24, any port", 0},
+	}
+
+	if len(expected) != len(cov.Items) {
+		t.Fatalf("expecting %d items, got %d: %v", len(expected), len(cov.Items), cov.Items)
+	}
+	for i, e := range expected {
+		item := cov.Items[i]
+		if e.item != item.String() {
+			t.Errorf("item %d: expecting %q, got %q", i, e.item, item.String())
+		}
+		if e.hits != item.Hits {
+			t.Errorf("item %d (%s): expecting %d hits, got %d", i, item, e.hits, item.Hits)
+		}
+	}
+
+	if covered, total := cov.Covered(); covered != 3 || total != 5 {
+		t.Fatalf("expecting 3 of 5 items covered, got %d of %d", covered, total)
+	}
+}
@@ -0,0 +1,403 @@
+package netpol
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Endpoint is one side of a connection. Endpoints with a blank
+// Namespace are not pods, and can only be matched by IP blocks.
+type Endpoint struct {
+	Namespace       string
+	NamespaceLabels labels.Set
+	Labels          labels.Set
+	IP              netip.Addr
+	// NamedPorts maps container port names to numbers, and is used to
+	// match rules using named ports.
+	NamedPorts map[string]int32
+	// Name is the DNS name used to reach an endpoint outside the
+	// cluster, and is used to match FQDN peers.
+	Name string
+}
+
+// IsPod returns whether the endpoint is a pod.
+func (e Endpoint) IsPod() bool {
+	return e.Namespace != ""
+}
+
+// HTTPRequest is an HTTP request made over a connection, matched by
+// the HTTP rules of ports.
+type HTTPRequest struct {
+	Method string
+	Host   string
+	Path   string
+}
+
+// Selects returns whether the policy applies to the endpoint.
+func (p *Policy) Selects(e Endpoint) bool {
+	if !e.IsPod() {
+		return false
+	}
+
+	if p.Namespace != "" {
+		if e.Namespace != p.Namespace {
+			return false
+		}
+	} else if !matches(p.NamespaceSelector, e.NamespaceLabels) {
+		return false
+	}
+
+	return matches(p.PodSelector, e.Labels)
+}
+
+// Matches returns whether the peer, defined in a policy of the given
+// namespace, matches the endpoint. Peers of cluster wide policies
+// without namespace selector match pods in all namespaces.
+func (p Peer) Matches(policyNamespace string, e Endpoint) bool {
+	switch {
+	case p.IPBlock != nil:
+		return p.IPBlock.Contains(e.IP)
+	case p.Entity != "":
+		return p.Entity.Matches(e)
+	case p.FQDN != "":
+		return e.Name != "" && MatchFQDN(p.FQDN, e.Name)
+	}
+
+	if !e.IsPod() {
+		return false
+	}
+
+	if p.NamespaceSelector == nil {
+		if policyNamespace != "" && e.Namespace != policyNamespace {
+			return false
+		}
+	} else if !matches(p.NamespaceSelector, e.NamespaceLabels) {
+		return false
+	}
+
+	return matches(p.PodSelector, e.Labels)
+}
+
+// MatchesNamespace returns whether the peer, defined in a policy of
+// the given namespace, could match any pod in namespace ns. IP blocks
+// and FQDNs never match.
+func (p Peer) MatchesNamespace(policyNamespace, ns string, nsLabels labels.Set) bool {
+	switch {
+	case p.IPBlock != nil, p.FQDN != "":
+		return false
+	case p.Entity != "":
+		return p.Entity == EntityAll || p.Entity == EntityCluster
+	case p.NamespaceSelector == nil:
+		return policyNamespace == "" || ns == policyNamespace
+	}
+	return matches(p.NamespaceSelector, nsLabels)
+}
+
+// Matches returns whether the entity includes the endpoint.
+func (e Entity) Matches(ep Endpoint) bool {
+	switch e {
+	case EntityAll:
+		return true
+	case EntityCluster:
+		return ep.IsPod()
+	case EntityWorld:
+		return !ep.IsPod()
+	default:
+		return false
+	}
+}
+
+// MatchFQDN returns whether the DNS name matches pattern, where *
+// matches any sequence of characters valid in a DNS label, and a
+// single * matches all names. The comparison is case insensitive.
+func MatchFQDN(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if pattern == "*" {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return pattern == name
+	}
+
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, "[-a-z0-9_]*") + "$"
+	ok, _ := regexp.MatchString(re, name)
+	return ok
+}
+
+// AllowsHTTP returns whether the HTTP rules of the port allow req. A
+// nil request, i.e. a plain connection, is always allowed.
+func (p Port) AllowsHTTP(req *HTTPRequest) bool {
+	if req == nil || len(p.HTTP) == 0 {
+		return true
+	}
+	for _, r := range p.HTTP {
+		if r.Matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns whether the request matches the rule.
+func (r HTTPRule) Matches(req *HTTPRequest) bool {
+	for _, m := range []struct{ pattern, value string }{
+		{r.Method, req.Method},
+		{r.Path, req.Path},
+		{r.Host, req.Host},
+	} {
+		re, err := compileHTTPPattern(m.pattern)
+		if err != nil {
+			return false
+		}
+		if re != nil && !re.MatchString(m.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileHTTPPattern compiles a regular expression of an HTTP rule, so
+// it matches whole values. A blank pattern returns a nil expression.
+func compileHTTPPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Matches returns whether the port matches the given port number and
+// protocol. Named ports are resolved with namedPorts.
+func (p Port) Matches(port int32, protocol corev1.Protocol, namedPorts map[string]int32) bool {
+	if p.Protocol != protocol {
+		return false
+	}
+
+	switch {
+	case p.Port == nil:
+		return true
+	case p.Port.Type == intstr.String:
+		n, ok := namedPorts[p.Port.StrVal]
+		return ok && n == port
+	case p.EndPort != 0:
+		return p.Port.IntVal <= port && port <= p.EndPort
+	default:
+		return p.Port.IntVal == port
+	}
+}
+
+// Allows returns whether the rule, defined in a policy of the given
+// namespace, allows traffic with peer on the given port and protocol.
+func (r Rule) Allows(policyNamespace string, peer Endpoint, port int32, protocol corev1.Protocol, namedPorts map[string]int32) bool {
+	_, _, ok := r.match(policyNamespace, peer, port, protocol, namedPorts)
+	return ok
+}
+
+// match returns the indexes of the peers and ports of the rule matching
+// the traffic, and whether the rule allows it.
+func (r Rule) match(policyNamespace string, peer Endpoint, port int32, protocol corev1.Protocol, namedPorts map[string]int32) (peers, ports []int, ok bool) {
+	for i, p := range r.Peers {
+		if p.Matches(policyNamespace, peer) {
+			peers = append(peers, i)
+		}
+	}
+	for i, p := range r.Ports {
+		if p.Matches(port, protocol, namedPorts) {
+			ports = append(ports, i)
+		}
+	}
+
+	ok = (len(r.Peers) == 0 || len(peers) > 0) && (len(r.Ports) == 0 || len(ports) > 0)
+	return peers, ports, ok
+}
+
+// allowsHTTP returns whether any of the ports of the rule at the given
+// indexes allows req.
+func (r Rule) allowsHTTP(ports []int, req *HTTPRequest) bool {
+	if req == nil || len(r.Ports) == 0 {
+		return true
+	}
+	for _, i := range ports {
+		if r.Ports[i].AllowsHTTP(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesPort(port int32, protocol corev1.Protocol, namedPorts map[string]int32) bool {
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, p := range r.Ports {
+		if p.Matches(port, protocol, namedPorts) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleRef references a rule of a policy.
+type RuleRef struct {
+	Policy    *Policy
+	Direction Direction
+	Index     int
+
+	// Peers and Ports are the indexes of the peers and ports of the
+	// rule matching the evaluated traffic. They are empty if the rule
+	// has no peers or ports, i.e. matches all of them.
+	Peers []int
+	Ports []int
+}
+
+func (r RuleRef) String() string {
+	return fmt.Sprintf("%s %s rule %d", r.Policy, r.Direction, r.Index)
+}
+
+// Rule returns the referenced rule.
+func (r RuleRef) Rule() Rule {
+	return r.Policy.Rules(r.Direction)[r.Index]
+}
+
+// Decision is the result of evaluating the policies applying to one
+// side of a connection.
+type Decision struct {
+	// Isolated reports whether any policy isolates the subject in
+	// the evaluated direction. Traffic to non isolated pods is
+	// allowed unless a rule denies it.
+	Isolated bool
+	// Policies are the policies isolating the subject.
+	Policies []*Policy
+	// Rules are the rules allowing the traffic.
+	Rules []RuleRef
+	// Denials are the rules denying the traffic.
+	Denials []RuleRef
+	// Passes are the rules passing the traffic over the remaining
+	// ordered policies.
+	Passes []RuleRef
+	// HTTPDenials are the rules allowing the connection, but not the
+	// evaluated HTTP request.
+	HTTPDenials []RuleRef
+}
+
+// Allowed returns whether the traffic is allowed.
+func (d Decision) Allowed() bool {
+	return len(d.Denials) == 0 && (!d.Isolated || len(d.Rules) > 0)
+}
+
+// DeniesHTTP returns whether the connection is allowed but the HTTP
+// request is denied.
+func (d Decision) DeniesHTTP() bool {
+	return !d.Allowed() && len(d.Denials) == 0 && len(d.HTTPDenials) > 0
+}
+
+// Matched returns all the rules matching the traffic.
+func (d Decision) Matched() []RuleRef {
+	return slices.Concat(d.Rules, d.Denials, d.Passes, d.HTTPDenials)
+}
+
+// Evaluate evaluates the policies applying to subject for traffic in
+// the given direction with peer: for egress the subject is the source
+// and peer the destination, and for ingress the subject is the
+// destination and peer the source. The port is always the destination
+// port.
+func Evaluate(policies []Policy, dir Direction, subject, peer Endpoint, port int32, protocol corev1.Protocol) Decision {
+	return EvaluateHTTP(policies, dir, subject, peer, port, protocol, nil)
+}
+
+// EvaluateHTTP is like Evaluate, but also evaluates the HTTP rules of
+// the policies for req, if not nil.
+//
+// Ordered policies are evaluated first, by increasing order and name,
+// and the first of their rules matching the traffic decides, unless it
+// passes it. The rules of the other policies are then combined: the
+// traffic is allowed if any of them allows it and none denies it.
+func EvaluateHTTP(policies []Policy, dir Direction, subject, peer Endpoint, port int32, protocol corev1.Protocol, req *HTTPRequest) Decision {
+	var d Decision
+
+	if !subject.IsPod() {
+		return d
+	}
+
+	namedPorts := subject.NamedPorts
+	if dir == Egress {
+		namedPorts = peer.NamedPorts
+	}
+
+	var ordered, unordered []*Policy
+	for i := range policies {
+		p := &policies[i]
+		if !p.Isolates(dir) || !p.Selects(subject) {
+			continue
+		}
+		if p.Order != nil {
+			ordered = append(ordered, p)
+		} else {
+			unordered = append(unordered, p)
+		}
+	}
+	slices.SortStableFunc(ordered, func(a, b *Policy) int {
+		return cmp.Or(cmp.Compare(*a.Order, *b.Order), cmp.Compare(a.Name, b.Name))
+	})
+
+	passed := false
+
+first:
+	for _, p := range ordered {
+		for j, r := range p.Rules(dir) {
+			peers, ports, ok := r.match(p.Namespace, peer, port, protocol, namedPorts)
+			if !ok || !r.allowsHTTP(ports, req) {
+				continue
+			}
+
+			ref := RuleRef{Policy: p, Direction: dir, Index: j, Peers: peers, Ports: ports}
+			switch r.Action {
+			case ActionAllow:
+				d.Rules = append(d.Rules, ref)
+			case ActionDeny:
+				d.Denials = append(d.Denials, ref)
+			case ActionPass:
+				d.Passes = append(d.Passes, ref)
+				passed = true
+			}
+			break first
+		}
+	}
+	if !passed {
+		d.Policies = ordered
+	}
+
+	for _, p := range unordered {
+		d.Policies = append(d.Policies, p)
+
+		for j, r := range p.Rules(dir) {
+			peers, ports, ok := r.match(p.Namespace, peer, port, protocol, namedPorts)
+			if !ok {
+				continue
+			}
+
+			ref := RuleRef{Policy: p, Direction: dir, Index: j, Peers: peers, Ports: ports}
+			switch {
+			case r.Action == ActionDeny:
+				d.Denials = append(d.Denials, ref)
+			case !r.allowsHTTP(ports, req):
+				d.HTTPDenials = append(d.HTTPDenials, ref)
+			default:
+				d.Rules = append(d.Rules, ref)
+			}
+		}
+	}
+
+	d.Isolated = len(d.Policies) > 0
+
+	return d
+}
@@ -0,0 +1,141 @@
+package netpol
+
+import (
+	"net/netip"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func mustPolicy(t *testing.T, np *networkingv1.NetworkPolicy) Policy {
+	t.Helper()
+	p, err := FromNetworkPolicy(np)
+	if err != nil {
+		t.Fatalf("converting policy: %v", err)
+	}
+	return p
+}
+
+func pod(ns string, lbls labels.Set) Endpoint {
+	return Endpoint{
+		Namespace:       ns,
+		NamespaceLabels: labels.Set{corev1.LabelMetadataName: ns},
+		Labels:          lbls,
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	port := func(p intstr.IntOrString) networkingv1.NetworkPolicyPort {
+		return networkingv1.NetworkPolicyPort{Port: &p}
+	}
+
+	policies := []Policy{
+		mustPolicy(t, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "cart"}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{port(intstr.FromString("grpc"))},
+					},
+					{
+						From: []networkingv1.NetworkPolicyPeer{
+							{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "monitoring"}}},
+						},
+					},
+				},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.0.0.0/24"}}},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{
+							{Port: ptr.To(intstr.FromInt32(5000)), EndPort: ptr.To[int32](5100)},
+						},
+					},
+				},
+			},
+		}),
+	}
+
+	cart := pod("shop", labels.Set{"app": "cart"})
+	cart.NamedPorts = map[string]int32{"grpc": 7070}
+	frontend := pod("shop", labels.Set{"app": "frontend"})
+	prometheus := pod("monitoring", labels.Set{"app": "prometheus"})
+	external := func(ip string) Endpoint {
+		return Endpoint{IP: netip.MustParseAddr(ip)}
+	}
+
+	tests := map[string]struct {
+		dir           Direction
+		subject, peer Endpoint
+		port          int32
+		protocol      corev1.Protocol
+		isolated      bool
+		rules         int
+	}{
+		"ingress named port":           {Ingress, cart, frontend, 7070, corev1.ProtocolTCP, true, 1},
+		"ingress other port":           {Ingress, cart, frontend, 8080, corev1.ProtocolTCP, true, 0},
+		"ingress wrong protocol":       {Ingress, cart, frontend, 7070, corev1.ProtocolUDP, true, 0},
+		"ingress namespace selector":   {Ingress, cart, prometheus, 9999, corev1.ProtocolTCP, true, 1},
+		"ingress not allowed":          {Ingress, cart, pod("shop", labels.Set{"app": "redis"}), 7070, corev1.ProtocolTCP, true, 0},
+		"ingress to non isolated pod":  {Ingress, frontend, prometheus, 80, corev1.ProtocolTCP, false, 0},
+		"egress in range":              {Egress, cart, external("10.1.2.3"), 5050, corev1.ProtocolTCP, true, 1},
+		"egress out of range":          {Egress, cart, external("10.1.2.3"), 5101, corev1.ProtocolTCP, true, 0},
+		"egress to exception":          {Egress, cart, external("10.0.0.3"), 5050, corev1.ProtocolTCP, true, 0},
+		"egress to pod":                {Egress, cart, frontend, 5050, corev1.ProtocolTCP, true, 0},
+		"egress from non isolated pod": {Egress, frontend, cart, 7070, corev1.ProtocolTCP, false, 0},
+		"non pod subject":              {Egress, external("10.0.0.1"), cart, 7070, corev1.ProtocolTCP, false, 0},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			d := Evaluate(policies, tt.dir, tt.subject, tt.peer, tt.port, tt.protocol)
+			if tt.isolated != d.Isolated {
+				t.Errorf("expecting isolated %v, got %v", tt.isolated, d.Isolated)
+			}
+			if tt.rules != len(d.Rules) {
+				t.Errorf("expecting %d matching rules, got %v", tt.rules, d.Rules)
+			}
+			if exp := !tt.isolated || tt.rules > 0; exp != d.Allowed() {
+				t.Errorf("expecting allowed %v, got %v", exp, d.Allowed())
+			}
+			for _, r := range d.Rules {
+				if r.Direction != tt.dir || r.Policy != &policies[0] {
+					t.Errorf("unexpected rule reference %+v", r)
+				}
+				_ = r.Rule()
+			}
+		})
+	}
+}
+
+func TestPeer_MatchesNamespace(t *testing.T) {
+	tests := map[string]struct {
+		peer Peer
+		ns   string
+		exp  bool
+	}{
+		"policy namespace":     {Peer{}, "shop", true},
+		"other namespace":      {Peer{}, "sandbox", false},
+		"namespace selector":   {Peer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "sandbox"}}}, "sandbox", true},
+		"all namespaces":       {Peer{NamespaceSelector: &metav1.LabelSelector{}}, "sandbox", true},
+		"namespace mismatch":   {Peer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}}}, "sandbox", false},
+		"ip block never match": {Peer{IPBlock: &IPBlock{CIDR: netip.MustParsePrefix("0.0.0.0/0")}}, "shop", false},
+	}
+
+	for n, tt := range tests {
+		if got := tt.peer.MatchesNamespace("shop", tt.ns, labels.Set{corev1.LabelMetadataName: tt.ns}); tt.exp != got {
+			t.Errorf("%s: expecting %v, got %v", n, tt.exp, got)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package netpol
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Outcome of evaluating a connection.
+type Outcome int
+
+const (
+	// OutcomeUnknown means the policies alone cannot tell whether the
+	// connection is allowed, e.g. the destination IP is unknown.
+	OutcomeUnknown Outcome = iota
+	OutcomeAllowed
+	OutcomeDenied
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeAllowed:
+		return "allowed"
+	case OutcomeDenied:
+		return "denied"
+	default:
+		return "unknown"
+	}
+}
+
+// Verdict is the result of evaluating a connection.
+type Verdict struct {
+	Outcome Outcome
+	Reason  string
+	// HTTPDenied reports whether a denied outcome is for the HTTP
+	// request rather than the connection, which is then answered with
+	// an HTTP 403 status.
+	HTTPDenied bool
+	// Rules are the rules matching the connection, in both
+	// directions, whatever their action.
+	Rules []RuleRef
+	// Denying are the policies denying the connection, for at least
+	// one of the backends.
+	Denying []Denial
+}
+
+// Denial is a policy denying a connection by isolating one of its
+// sides.
+type Denial struct {
+	Policy    *Policy
+	Direction Direction
+}
+
+// Evaluator evaluates connections between the objects of an Inventory
+// according to the Kubernetes NetworkPolicy semantics: a connection is
+// allowed if the egress policies of the source and the ingress policies
+// of the destination allow it. Services are resolved to their backend
+// pods, as policies apply after the Service address is translated.
+type Evaluator struct {
+	inv  *Inventory
+	pods map[netip.Addr]*corev1.Pod
+}
+
+// NewEvaluator returns an Evaluator for the given inventory.
+func NewEvaluator(inv *Inventory) *Evaluator {
+	e := &Evaluator{
+		inv:  inv,
+		pods: make(map[netip.Addr]*corev1.Pod),
+	}
+
+	for i := range inv.Pods {
+		p := &inv.Pods[i]
+		if p.Spec.HostNetwork {
+			continue // shares the node address
+		}
+		for _, ip := range p.Status.PodIPs {
+			if addr, err := netip.ParseAddr(ip.IP); err == nil {
+				e.pods[addr] = p
+			}
+		}
+		if addr, err := netip.ParseAddr(p.Status.PodIP); err == nil {
+			e.pods[addr] = p
+		}
+	}
+
+	return e
+}
+
+// PodEndpoint returns the endpoint for a pod.
+func (e *Evaluator) PodEndpoint(pod *corev1.Pod) Endpoint {
+	ep := Endpoint{
+		Namespace:       pod.Namespace,
+		NamespaceLabels: e.inv.NamespaceLabels(pod.Namespace),
+		Labels:          labels.Set(pod.Labels),
+		NamedPorts:      make(map[string]int32),
+	}
+	if addr, err := netip.ParseAddr(pod.Status.PodIP); err == nil {
+		ep.IP = addr
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name != "" {
+				ep.NamedPorts[p.Name] = p.ContainerPort
+			}
+		}
+	}
+	return ep
+}
+
+// backend is a resolved destination of a connection.
+type backend struct {
+	endpoint Endpoint
+	port     int32
+	name     string
+}
+
+// Evaluate returns whether a connection from pod src to host on the
+// given port and protocol is allowed. host can be an IP address, the
+// DNS name of a Service, or an external name.
+func (e *Evaluator) Evaluate(src *corev1.Pod, host string, port int32, protocol corev1.Protocol) Verdict {
+	return e.EvaluateHTTP(src, host, port, protocol, nil)
+}
+
+// EvaluateHTTP is like Evaluate, but also evaluates the HTTP rules of
+// the policies for req, if not nil.
+func (e *Evaluator) EvaluateHTTP(src *corev1.Pod, host string, port int32, protocol corev1.Protocol, req *HTTPRequest) Verdict {
+	backends, reason := e.resolve(src.Namespace, host, port, protocol)
+	if backends == nil {
+		return Verdict{Outcome: OutcomeUnknown, Reason: reason}
+	}
+
+	srcEP := e.PodEndpoint(src)
+
+	var (
+		allowed, denied, httpDenied int
+		reasons                     []string
+		rules                       []RuleRef
+		denying                     []Denial
+		unknown                     bool
+	)
+
+	for _, b := range backends {
+		eg := EvaluateHTTP(e.inv.Policies, Egress, srcEP, b.endpoint, b.port, protocol, req)
+		in := EvaluateHTTP(e.inv.Policies, Ingress, b.endpoint, srcEP, b.port, protocol, req)
+
+		rules = append(rules, eg.Matched()...)
+		rules = append(rules, in.Matched()...)
+
+		switch {
+		case !eg.Allowed() && !b.endpoint.IsPod() && !b.endpoint.IP.IsValid() && hasIPBlockRule(eg, b.port, protocol):
+			// the destination IP is unknown, so IP blocks could
+			// still allow it
+			unknown = true
+			reasons = append(reasons, fmt.Sprintf("egress to %s depends on the resolved address", b.name))
+		case eg.DeniesHTTP():
+			httpDenied++
+			reasons = append(reasons, fmt.Sprintf("HTTP request to %s denied by %s", b.name, ruleNames(eg.HTTPDenials)))
+		case !eg.Allowed():
+			denied++
+			reasons = append(reasons, deniedReason("egress to "+b.name, Egress, eg, &denying))
+		case in.DeniesHTTP():
+			httpDenied++
+			reasons = append(reasons, fmt.Sprintf("HTTP request to %s denied by %s", b.name, ruleNames(in.HTTPDenials)))
+		case !in.Allowed():
+			denied++
+			reasons = append(reasons, deniedReason("ingress to "+b.name, Ingress, in, &denying))
+		default:
+			allowed++
+		}
+	}
+
+	v := Verdict{Rules: rules, Denying: denying}
+
+	switch {
+	case unknown:
+		v.Outcome = OutcomeUnknown
+	case denied == 0 && httpDenied == 0:
+		v.Outcome = OutcomeAllowed
+		v.Reason = allowedReason(rules)
+	case allowed == 0 && httpDenied == 0:
+		v.Outcome = OutcomeDenied
+	case allowed == 0 && denied == 0:
+		v.Outcome = OutcomeDenied
+		v.HTTPDenied = true
+	default:
+		v.Outcome = OutcomeUnknown
+		reasons = append([]string{fmt.Sprintf("allowed to %d of %d backends", allowed, len(backends))}, reasons...)
+	}
+	if v.Reason == "" {
+		v.Reason = strings.Join(reasons, "; ")
+	}
+
+	return v
+}
+
+// resolve returns the backends for a connection to host:port from a pod
+// in the given namespace. If the destination cannot be evaluated, it
+// returns nil and the reason.
+func (e *Evaluator) resolve(namespace, host string, port int32, protocol corev1.Protocol) ([]backend, string) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if pod, ok := e.pods[addr]; ok {
+			return []backend{{e.PodEndpoint(pod), port, "pod " + pod.Namespace + "/" + pod.Name}}, ""
+		}
+		if svc := e.serviceByIP(addr); svc != nil {
+			return e.serviceBackends(svc, port, protocol)
+		}
+		return []backend{{Endpoint{IP: addr}, port, addr.String()}}, ""
+	}
+
+	if svc := e.serviceByName(namespace, host); svc != nil {
+		return e.serviceBackends(svc, port, protocol)
+	}
+
+	if isClusterName(host) {
+		return nil, fmt.Sprintf("cannot resolve %s", host)
+	}
+
+	// an external name with an unknown address
+	return []backend{{Endpoint{Name: host}, port, host}}, ""
+}
+
+func (e *Evaluator) serviceByIP(addr netip.Addr) *corev1.Service {
+	for i := range e.inv.Services {
+		svc := &e.inv.Services[i]
+		for _, ip := range append([]string{svc.Spec.ClusterIP}, svc.Spec.ClusterIPs...) {
+			if a, err := netip.ParseAddr(ip); err == nil && a == addr {
+				return svc
+			}
+		}
+	}
+	return nil
+}
+
+// serviceByName returns the Service for names like svc, svc.ns,
+// svc.ns.svc and svc.ns.svc.cluster.local, relative to namespace.
+func (e *Evaluator) serviceByName(namespace, host string) *corev1.Service {
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+
+	name, ns := parts[0], namespace
+	switch {
+	case len(parts) == 1:
+	case len(parts) == 2, len(parts) >= 3 && parts[2] == "svc":
+		ns = parts[1]
+	default:
+		return nil
+	}
+
+	for i := range e.inv.Services {
+		svc := &e.inv.Services[i]
+		if svc.Name == name && svc.Namespace == ns {
+			return svc
+		}
+	}
+	return nil
+}
+
+// serviceBackends returns the pods behind a Service port, with the
+// port translated to the target port of each pod.
+func (e *Evaluator) serviceBackends(svc *corev1.Service, port int32, protocol corev1.Protocol) ([]backend, string) {
+	name := svc.Namespace + "/" + svc.Name
+
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return []backend{{Endpoint{Name: svc.Spec.ExternalName}, port, svc.Spec.ExternalName}}, ""
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Sprintf("service %s has no selector", name)
+	}
+
+	var sp *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if p.Port == port && (p.Protocol == protocol || p.Protocol == "" && protocol == corev1.ProtocolTCP) {
+			sp = p
+			break
+		}
+	}
+	if sp == nil {
+		return nil, fmt.Sprintf("service %s has no %s port %d", name, protocol, port)
+	}
+
+	sel := labels.SelectorFromSet(svc.Spec.Selector)
+
+	var backends []backend
+	for i := range e.inv.Pods {
+		pod := &e.inv.Pods[i]
+		if pod.Namespace != svc.Namespace || !sel.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		ep := e.PodEndpoint(pod)
+		target := sp.Port
+		switch {
+		case sp.TargetPort.Type == intstr.String:
+			n, ok := ep.NamedPorts[sp.TargetPort.StrVal]
+			if !ok {
+				continue
+			}
+			target = n
+		case sp.TargetPort.IntVal != 0:
+			target = sp.TargetPort.IntVal
+		}
+
+		backends = append(backends, backend{ep, target, "pod " + pod.Namespace + "/" + pod.Name})
+	}
+
+	if len(backends) == 0 {
+		return nil, fmt.Sprintf("service %s has no backend pods", name)
+	}
+
+	return backends, ""
+}
+
+// isClusterName returns whether host is a cluster local DNS name.
+func isClusterName(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	return strings.HasSuffix(host, ".svc") || strings.HasSuffix(host, ".cluster.local") || !strings.Contains(host, ".")
+}
+
+// hasIPBlockRule returns whether any isolating policy has an IP block
+// rule for the given port.
+func hasIPBlockRule(d Decision, port int32, protocol corev1.Protocol) bool {
+	for _, p := range d.Policies {
+		for _, r := range p.Egress {
+			if !r.matchesPort(port, protocol, nil) {
+				continue
+			}
+			for _, peer := range r.Peers {
+				if peer.IPBlock != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// deniedReason returns why the traffic is denied by d, recording the
+// policies denying it by isolation.
+func deniedReason(traffic string, dir Direction, d Decision, denying *[]Denial) string {
+	if len(d.Denials) > 0 {
+		return fmt.Sprintf("%s denied by %s", traffic, ruleNames(d.Denials))
+	}
+
+	*denying = appendDenials(*denying, dir, d.Policies)
+	return fmt.Sprintf("%s denied by %s", traffic, policyNames(d.Policies))
+}
+
+func appendDenials(denials []Denial, dir Direction, policies []*Policy) []Denial {
+	for _, p := range policies {
+		d := Denial{Policy: p, Direction: dir}
+		if !slices.Contains(denials, d) {
+			denials = append(denials, d)
+		}
+	}
+	return denials
+}
+
+func policyNames(policies []*Policy) string {
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = p.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func ruleNames(rules []RuleRef) string {
+	names := make([]string, len(rules))
+	for i, r := range rules {
+		names[i] = r.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func allowedReason(rules []RuleRef) string {
+	rules = slices.DeleteFunc(slices.Clone(rules), func(r RuleRef) bool {
+		return r.Rule().Action != ActionAllow
+	})
+	if len(rules) == 0 {
+		return "no policy isolates the source or destination"
+	}
+
+	seen := make(map[string]bool)
+	var rs []string
+	for _, r := range rules {
+		s := r.String()
+		if !seen[s] {
+			seen[s] = true
+			rs = append(rs, s)
+		}
+	}
+	return "allowed by " + strings.Join(rs, ", ")
+}
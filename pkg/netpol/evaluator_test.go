@@ -0,0 +1,158 @@
+package netpol
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func testInventory(t *testing.T) *Inventory {
+	t.Helper()
+
+	pod := func(ns, name, ip string, labels map[string]string, ports ...corev1.ContainerPort) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: labels},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "main", Ports: ports}},
+			},
+			Status: corev1.PodStatus{PodIP: ip},
+		}
+	}
+
+	inv := &Inventory{
+		Namespaces: []corev1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Labels: map[string]string{"team": "observability"}}},
+		},
+		Pods: []corev1.Pod{
+			pod("shop", "frontend", "10.0.0.1", map[string]string{"app": "frontend"}),
+			pod("shop", "cart-0", "10.0.0.2", map[string]string{"app": "cart"}, corev1.ContainerPort{Name: "grpc", ContainerPort: 7070}),
+			pod("shop", "cart-1", "10.0.0.3", map[string]string{"app": "cart"}, corev1.ContainerPort{Name: "grpc", ContainerPort: 7070}),
+			pod("shop", "redis", "10.0.0.4", map[string]string{"app": "redis"}),
+			pod("monitoring", "prometheus", "10.0.1.1", map[string]string{"app": "prometheus"}),
+		},
+		Services: []corev1.Service{
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.96.0.10",
+					Selector:  map[string]string{"app": "cart"},
+					Ports:     []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("grpc")}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "redis"},
+				Spec: corev1.ServiceSpec{
+					Selector: map[string]string{"app": "redis"},
+					Ports:    []corev1.ServicePort{{Port: 6379}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "empty"},
+				Spec: corev1.ServiceSpec{
+					Selector: map[string]string{"app": "nothing"},
+					Ports:    []corev1.ServicePort{{Port: 80}},
+				},
+			},
+		},
+	}
+
+	err := inv.AddNetworkPolicies(
+		networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "cart"}},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+					},
+					Ports: []networkingv1.NetworkPolicyPort{{Port: ptr.To(intstr.FromInt32(7070))}},
+				}},
+			},
+		},
+		networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "frontend-egress"},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cart"}}}}},
+					{To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "192.0.2.0/24"}}}},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return inv
+}
+
+func TestEvaluator(t *testing.T) {
+	inv := testInventory(t)
+	ev := NewEvaluator(inv)
+
+	frontend, prometheus := &inv.Pods[0], &inv.Pods[4]
+
+	tests := map[string]struct {
+		src     *corev1.Pod
+		host    string
+		port    int32
+		outcome Outcome
+		reason  string
+	}{
+		"service name":               {frontend, "cart", 80, OutcomeAllowed, "NetworkPolicy shop/cart ingress rule 0"},
+		"service fqdn":               {frontend, "cart.shop.svc.cluster.local", 80, OutcomeAllowed, "NetworkPolicy shop/frontend-egress egress rule 0"},
+		"service cluster ip":         {frontend, "10.96.0.10", 80, OutcomeAllowed, ""},
+		"pod ip":                     {frontend, "10.0.0.2", 7070, OutcomeAllowed, ""},
+		"pod ip other port":          {frontend, "10.0.0.2", 8080, OutcomeDenied, "ingress to pod shop/cart-0 denied by NetworkPolicy shop/cart"},
+		"ingress denied":             {prometheus, "cart.shop", 80, OutcomeDenied, "ingress to pod shop/cart-0 denied"},
+		"egress denied":              {frontend, "redis.shop.svc", 6379, OutcomeDenied, "egress to pod shop/redis denied by NetworkPolicy shop/frontend-egress"},
+		"not isolated":               {prometheus, "redis.shop", 6379, OutcomeAllowed, "no policy isolates"},
+		"external ip allowed":        {frontend, "192.0.2.10", 443, OutcomeAllowed, ""},
+		"external ip denied":         {frontend, "198.51.100.1", 443, OutcomeDenied, ""},
+		"external name":              {frontend, "grafana.com", 443, OutcomeUnknown, "depends on the resolved address"},
+		"external name not isolated": {prometheus, "grafana.com", 443, OutcomeAllowed, ""},
+		"unknown service":            {frontend, "foo.bar.svc.cluster.local", 80, OutcomeUnknown, "cannot resolve"},
+		"unknown service port":       {frontend, "cart.shop", 8080, OutcomeUnknown, "no TCP port 8080"},
+		"no backends":                {frontend, "empty.shop", 80, OutcomeUnknown, "no backend pods"},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			v := ev.Evaluate(tt.src, tt.host, tt.port, corev1.ProtocolTCP)
+			if tt.outcome != v.Outcome {
+				t.Fatalf("expecting outcome %s, got %s (%s)", tt.outcome, v.Outcome, v.Reason)
+			}
+			if !strings.Contains(v.Reason, tt.reason) {
+				t.Fatalf("expecting reason to contain %q, got %q", tt.reason, v.Reason)
+			}
+		})
+	}
+
+	t.Run("partially allowed", func(t *testing.T) {
+		// the canary cart pod is also selected by a policy allowing
+		// ingress from all namespaces
+		inv := testInventory(t)
+		inv.Pods[2].Labels = map[string]string{"app": "cart", "canary": "true"}
+		inv.Policies = append(inv.Policies, Policy{
+			Kind:            "NetworkPolicy",
+			Namespace:       "shop",
+			Name:            "canary",
+			PodSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"canary": "true"}},
+			IsolatesIngress: true,
+			Ingress:         []Rule{{Peers: []Peer{{NamespaceSelector: &metav1.LabelSelector{}}}}},
+		})
+
+		v := NewEvaluator(inv).Evaluate(&inv.Pods[4], "cart.shop", 80, corev1.ProtocolTCP)
+		if v.Outcome != OutcomeUnknown || !strings.Contains(v.Reason, "allowed to 1 of 2 backends") {
+			t.Fatalf("expecting partially allowed, got %s (%s)", v.Outcome, v.Reason)
+		}
+	})
+}
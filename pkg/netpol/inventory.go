@@ -0,0 +1,211 @@
+package netpol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Inventory holds the objects needed to reason about network
+// policies.
+type Inventory struct {
+	Policies   []Policy
+	Namespaces []corev1.Namespace
+	Services   []corev1.Service
+	Pods       []corev1.Pod
+
+	// Warnings are about the parts of policies that could not be
+	// modeled.
+	Warnings []string
+}
+
+// Custom resources holding policies.
+var (
+	CiliumNetworkPolicies            = schema.GroupVersionResource{Group: CiliumGroup, Version: "v2", Resource: "ciliumnetworkpolicies"}
+	CiliumClusterwideNetworkPolicies = schema.GroupVersionResource{Group: CiliumGroup, Version: "v2", Resource: "ciliumclusterwidenetworkpolicies"}
+	CalicoNetworkPolicies            = schema.GroupVersionResource{Group: CalicoGroup, Version: "v3", Resource: "networkpolicies"}
+	CalicoGlobalNetworkPolicies      = schema.GroupVersionResource{Group: CalicoGroup, Version: "v3", Resource: "globalnetworkpolicies"}
+	CalicoCRDNetworkPolicies         = schema.GroupVersionResource{Group: CalicoCRDGroup, Version: "v1", Resource: "networkpolicies"}
+	CalicoCRDGlobalNetworkPolicies   = schema.GroupVersionResource{Group: CalicoCRDGroup, Version: "v1", Resource: "globalnetworkpolicies"}
+)
+
+// AddNetworkPolicies converts and adds the given NetworkPolicy objects.
+func (inv *Inventory) AddNetworkPolicies(nps ...networkingv1.NetworkPolicy) error {
+	for i := range nps {
+		p, err := FromNetworkPolicy(&nps[i])
+		if err != nil {
+			return err
+		}
+		inv.Policies = append(inv.Policies, p)
+	}
+
+	return nil
+}
+
+// AddCustomPolicies converts and adds the given Cilium and Calico
+// policies. Other objects are ignored, as well as Calico policies that
+// cannot be modeled, which are reported in the warnings.
+func (inv *Inventory) AddCustomPolicies(objs ...unstructured.Unstructured) error {
+	for i := range objs {
+		obj := &objs[i]
+		gvk := obj.GroupVersionKind()
+
+		switch {
+		case gvk.Group == CiliumGroup && (gvk.Kind == "CiliumNetworkPolicy" || gvk.Kind == "CiliumClusterwideNetworkPolicy"):
+			ps, warnings, err := FromCiliumPolicy(obj)
+			inv.Warnings = append(inv.Warnings, warnings...)
+			if err != nil {
+				return err
+			}
+			inv.Policies = append(inv.Policies, ps...)
+
+		case (gvk.Group == CalicoGroup || gvk.Group == CalicoCRDGroup) && (gvk.Kind == "NetworkPolicy" || gvk.Kind == "GlobalNetworkPolicy"):
+			if strings.HasPrefix(obj.GetName(), "knp.default.") {
+				continue // a Kubernetes NetworkPolicy, as seen by Calico
+			}
+
+			p, warnings, err := FromCalicoPolicy(obj)
+			inv.Warnings = append(inv.Warnings, warnings...)
+			switch {
+			case errors.Is(err, ErrUnsupportedPolicy):
+				inv.Warnings = append(inv.Warnings, err.Error()+", skipping")
+			case err != nil:
+				return err
+			default:
+				inv.Policies = append(inv.Policies, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadManifests reads a stream of YAML or JSON Kubernetes manifests,
+// adding the NetworkPolicy, Cilium and Calico policy, Namespace,
+// Service and Pod objects found, as well as the items of List objects.
+// Other kinds are ignored.
+func (inv *Inventory) ReadManifests(r io.Reader) error {
+	dec := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	deserializer := scheme.Codecs.UniversalDeserializer()
+
+	for {
+		var raw runtime.RawExtension
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding manifest: %w", err)
+		}
+
+		if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+			continue // empty document
+		}
+
+		if err := inv.decode(deserializer, raw.Raw); err != nil {
+			return err
+		}
+	}
+}
+
+// decode decodes and adds an object.
+func (inv *Inventory) decode(deserializer runtime.Decoder, raw []byte) error {
+	obj, _, err := deserializer.Decode(raw, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		// a custom resource
+		var u unstructured.Unstructured
+		if err := u.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("decoding manifest: %w", err)
+		}
+		return inv.AddCustomPolicies(u)
+	} else if err != nil {
+		return fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	return inv.add(deserializer, obj)
+}
+
+func (inv *Inventory) add(deserializer runtime.Decoder, obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *networkingv1.NetworkPolicy:
+		if o.Namespace == "" {
+			o.Namespace = corev1.NamespaceDefault
+		}
+		return inv.AddNetworkPolicies(*o)
+	case *networkingv1.NetworkPolicyList:
+		return inv.AddNetworkPolicies(o.Items...)
+	case *corev1.Namespace:
+		inv.Namespaces = append(inv.Namespaces, *o)
+	case *corev1.Service:
+		if o.Namespace == "" {
+			o.Namespace = corev1.NamespaceDefault
+		}
+		inv.Services = append(inv.Services, *o)
+	case *corev1.Pod:
+		if o.Namespace == "" {
+			o.Namespace = corev1.NamespaceDefault
+		}
+		inv.Pods = append(inv.Pods, *o)
+	case *corev1.List:
+		for _, item := range o.Items {
+			if err := inv.decode(deserializer, item.Raw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// NamespaceNames returns the names of all the namespaces known to the
+// inventory, either declared or referenced by another object.
+func (inv *Inventory) NamespaceNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(ns string) {
+		if ns != "" && !seen[ns] {
+			seen[ns] = true
+			names = append(names, ns)
+		}
+	}
+
+	for _, ns := range inv.Namespaces {
+		add(ns.Name)
+	}
+	for _, p := range inv.Policies {
+		add(p.Namespace)
+	}
+	for _, s := range inv.Services {
+		add(s.Namespace)
+	}
+	for _, p := range inv.Pods {
+		add(p.Namespace)
+	}
+
+	return names
+}
+
+// NamespaceLabels returns the labels of the given namespace. Namespaces
+// not declared in the inventory only have the kubernetes.io/metadata.name
+// label, which the API server sets on every namespace.
+func (inv *Inventory) NamespaceLabels(namespace string) labels.Set {
+	set := labels.Set{corev1.LabelMetadataName: namespace}
+	for _, ns := range inv.Namespaces {
+		if ns.Name == namespace {
+			for k, v := range ns.Labels {
+				set[k] = v
+			}
+			break
+		}
+	}
+	return set
+}
@@ -0,0 +1,416 @@
+// Package netpol models network policies in a CNI agnostic way, and
+// evaluates whether connections between endpoints are allowed by
+// them.
+package netpol
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// Direction of the traffic a rule applies to.
+type Direction string
+
+const (
+	Ingress Direction = "ingress"
+	Egress  Direction = "egress"
+)
+
+// Policy is a network policy selecting pods in a namespace, and the
+// rules allowing traffic to and from them.
+type Policy struct {
+	Kind string // e.g. NetworkPolicy
+	// Namespace is blank for cluster wide policies.
+	Namespace string
+	Name      string
+
+	// PodSelector selects the pods the policy applies to; nil selects
+	// all pods in the namespace.
+	PodSelector *metav1.LabelSelector
+	// NamespaceSelector selects the namespaces of the pods cluster
+	// wide policies apply to; nil selects all namespaces.
+	NamespaceSelector *metav1.LabelSelector
+
+	// Order is set for policies applied in order, like the ones of
+	// Calico: the first matching rule of the policy with the lowest
+	// order decides. Policies without order are applied after the
+	// ordered ones, and their rules are combined.
+	Order *float64
+
+	// IsolatesIngress and IsolatesEgress report whether the selected
+	// pods are isolated in the given direction, meaning only traffic
+	// allowed by a rule is permitted.
+	IsolatesIngress bool
+	IsolatesEgress  bool
+
+	Ingress []Rule
+	Egress  []Rule
+}
+
+func (p *Policy) String() string {
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s", p.Kind, p.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", p.Kind, p.Namespace, p.Name)
+}
+
+// Rules returns the rules of the policy for the given direction.
+func (p *Policy) Rules(dir Direction) []Rule {
+	if dir == Ingress {
+		return p.Ingress
+	}
+	return p.Egress
+}
+
+// Isolates returns whether the policy isolates its pods for the given
+// direction.
+func (p *Policy) Isolates(dir Direction) bool {
+	if dir == Ingress {
+		return p.IsolatesIngress
+	}
+	return p.IsolatesEgress
+}
+
+// Action of a rule on the traffic it matches.
+type Action int
+
+const (
+	ActionAllow Action = iota
+	ActionDeny
+	// ActionPass skips the remaining ordered policies.
+	ActionPass
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionDeny:
+		return "deny"
+	case ActionPass:
+		return "pass"
+	default:
+		return "allow"
+	}
+}
+
+// Rule allows, or denies, traffic from (ingress) or to (egress) any of
+// its peers on any of its ports. No peers means all peers, and no ports
+// means all ports.
+type Rule struct {
+	Action Action
+	Peers  []Peer
+	Ports  []Port
+}
+
+// Entity is a class of endpoints, as used by Cilium.
+type Entity string
+
+const (
+	// EntityAll matches every endpoint.
+	EntityAll Entity = "all"
+	// EntityCluster matches every pod.
+	EntityCluster Entity = "cluster"
+	// EntityWorld matches every endpoint outside the cluster.
+	EntityWorld Entity = "world"
+)
+
+// Peer is the other side of a connection allowed by a rule.
+type Peer struct {
+	// PodSelector selects pods in the namespaces selected by
+	// NamespaceSelector; nil selects all pods.
+	PodSelector *metav1.LabelSelector
+	// NamespaceSelector selects namespaces by label; nil selects only
+	// the namespace of the policy.
+	NamespaceSelector *metav1.LabelSelector
+
+	// IPBlock, if set, makes this peer a range of IP addresses and
+	// the selectors are ignored.
+	IPBlock *IPBlock
+
+	// Entity, if set, makes this peer a class of endpoints and the
+	// selectors are ignored. Entities other than the Entity constants
+	// match nothing.
+	Entity Entity
+
+	// FQDN, if set, makes this peer the external names matching it,
+	// where * matches any sequence of DNS characters, and the
+	// selectors are ignored.
+	FQDN string
+}
+
+func (p Peer) String() string {
+	switch {
+	case p.IPBlock != nil:
+		return "ipBlock " + p.IPBlock.CIDR.String()
+	case p.Entity != "":
+		return "entity " + string(p.Entity)
+	case p.FQDN != "":
+		return "fqdn " + p.FQDN
+	}
+
+	s := "pods " + SelectorString(p.PodSelector)
+	if p.NamespaceSelector != nil {
+		s += " in namespaces " + SelectorString(p.NamespaceSelector)
+	}
+	return s
+}
+
+// IPBlock is a CIDR with optional exceptions.
+type IPBlock struct {
+	CIDR   netip.Prefix
+	Except []netip.Prefix
+}
+
+// Contains returns whether addr is in the block.
+func (b IPBlock) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() || !b.CIDR.Contains(addr) {
+		return false
+	}
+	for _, e := range b.Except {
+		if e.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectsPods returns whether the peer is defined by label selectors.
+func (p Peer) SelectsPods() bool {
+	return p.IPBlock == nil && p.Entity == "" && p.FQDN == ""
+}
+
+// Port is a port or range of ports for a protocol. A nil Port means
+// all ports of the protocol.
+type Port struct {
+	Protocol corev1.Protocol
+	Port     *intstr.IntOrString
+	EndPort  int32
+
+	// HTTP, if not empty, restricts the HTTP requests allowed on the
+	// port to the ones matching any of the rules.
+	HTTP []HTTPRule
+}
+
+func (p Port) String() string {
+	var s string
+	switch {
+	case p.Port == nil:
+		s = string(p.Protocol)
+	case p.EndPort != 0:
+		s = fmt.Sprintf("%s/%s-%d", p.Protocol, p.Port, p.EndPort)
+	default:
+		s = fmt.Sprintf("%s/%s", p.Protocol, p.Port)
+	}
+
+	if len(p.HTTP) > 0 {
+		s += fmt.Sprintf(" (%d HTTP rules)", len(p.HTTP))
+	}
+
+	return s
+}
+
+// HTTPRule matches HTTP requests. Its fields are regular expressions
+// matching the whole value; blank fields match everything.
+type HTTPRule struct {
+	Method string
+	Path   string
+	Host   string
+}
+
+func (r HTTPRule) String() string {
+	return fmt.Sprintf("%s %s%s", cmp.Or(r.Method, "*"), r.Host, cmp.Or(r.Path, "*"))
+}
+
+// ErrUnsupportedPolicy is returned when converting a policy that cannot
+// be modeled at all.
+var ErrUnsupportedPolicy = errors.New("unsupported policy")
+
+// FromNetworkPolicy converts a Kubernetes NetworkPolicy.
+func FromNetworkPolicy(np *networkingv1.NetworkPolicy) (Policy, error) {
+	p := Policy{
+		Kind:        "NetworkPolicy",
+		Namespace:   np.Namespace,
+		Name:        np.Name,
+		PodSelector: np.Spec.PodSelector.DeepCopy(),
+	}
+
+	if len(np.Spec.PolicyTypes) == 0 {
+		// as documented in the API, ingress is always set and egress
+		// only if there are egress rules
+		p.IsolatesIngress = true
+		p.IsolatesEgress = len(np.Spec.Egress) > 0
+	}
+	for _, t := range np.Spec.PolicyTypes {
+		switch t {
+		case networkingv1.PolicyTypeIngress:
+			p.IsolatesIngress = true
+		case networkingv1.PolicyTypeEgress:
+			p.IsolatesEgress = true
+		}
+	}
+
+	for _, r := range np.Spec.Ingress {
+		rule, err := convertRule(r.From, r.Ports)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: ingress: %w", p.String(), err)
+		}
+		p.Ingress = append(p.Ingress, rule)
+	}
+	for _, r := range np.Spec.Egress {
+		rule, err := convertRule(r.To, r.Ports)
+		if err != nil {
+			return Policy{}, fmt.Errorf("%s: egress: %w", p.String(), err)
+		}
+		p.Egress = append(p.Egress, rule)
+	}
+
+	return p, validateSelectors(p)
+}
+
+func convertRule(peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort) (Rule, error) {
+	var rule Rule
+
+	for _, peer := range peers {
+		if b := peer.IPBlock; b != nil {
+			block, err := parseIPBlock(b.CIDR, b.Except)
+			if err != nil {
+				return Rule{}, err
+			}
+			rule.Peers = append(rule.Peers, Peer{IPBlock: block})
+			continue
+		}
+
+		rule.Peers = append(rule.Peers, Peer{
+			PodSelector:       peer.PodSelector.DeepCopy(),
+			NamespaceSelector: peer.NamespaceSelector.DeepCopy(),
+		})
+	}
+
+	for _, port := range ports {
+		p := Port{Protocol: corev1.ProtocolTCP}
+		if port.Protocol != nil {
+			p.Protocol = *port.Protocol
+		}
+		if port.Port != nil {
+			p.Port = ptr.To(*port.Port)
+		}
+		if port.EndPort != nil {
+			p.EndPort = *port.EndPort
+		}
+		rule.Ports = append(rule.Ports, p)
+	}
+
+	return rule, nil
+}
+
+func parseIPBlock(cidr string, except []string) (*IPBlock, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ipBlock CIDR: %w", err)
+	}
+
+	b := &IPBlock{CIDR: prefix.Masked()}
+	for _, e := range except {
+		prefix, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ipBlock except: %w", err)
+		}
+		b.Except = append(b.Except, prefix.Masked())
+	}
+
+	return b, nil
+}
+
+// validateSelectors makes sure that all the selectors in the policy
+// are valid, so matching can later ignore conversion errors.
+func validateSelectors(p Policy) error {
+	sels := []*metav1.LabelSelector{p.PodSelector, p.NamespaceSelector}
+	for _, r := range append(p.Ingress[:len(p.Ingress):len(p.Ingress)], p.Egress...) {
+		for _, peer := range r.Peers {
+			sels = append(sels, peer.PodSelector, peer.NamespaceSelector)
+		}
+	}
+
+	for _, s := range sels {
+		if _, err := metav1.LabelSelectorAsSelector(s); err != nil {
+			return fmt.Errorf("%s: %w", p.String(), err)
+		}
+	}
+
+	for _, r := range append(p.Ingress[:len(p.Ingress):len(p.Ingress)], p.Egress...) {
+		for _, port := range r.Ports {
+			for _, h := range port.HTTP {
+				for _, re := range []string{h.Method, h.Path, h.Host} {
+					if _, err := compileHTTPPattern(re); err != nil {
+						return fmt.Errorf("%s: HTTP rule: %w", p.String(), err)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SelectorString returns sel in the format used by label selectors in
+// the Kubernetes API and in test plans. A nil or empty selector returns
+// an empty string.
+func SelectorString(sel *metav1.LabelSelector) string {
+	if sel == nil {
+		return ""
+	}
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	if err != nil {
+		return ""
+	}
+	return s.String()
+}
+
+// SampleLabels returns a set of labels matched by sel, if possible. It
+// is used to build representative endpoints for selectors.
+func SampleLabels(sel *metav1.LabelSelector) (labels.Set, bool) {
+	set := labels.Set{}
+	if sel == nil {
+		return set, true
+	}
+
+	for k, v := range sel.MatchLabels {
+		set[k] = v
+	}
+	for _, e := range sel.MatchExpressions {
+		switch e.Operator {
+		case metav1.LabelSelectorOpIn:
+			if len(e.Values) == 0 {
+				return nil, false
+			}
+			set[e.Key] = e.Values[0]
+		case metav1.LabelSelectorOpExists:
+			if _, ok := set[e.Key]; !ok {
+				set[e.Key] = "nethax"
+			}
+		}
+	}
+
+	return set, matches(sel, set)
+}
+
+// matches returns whether sel matches set. A nil selector matches
+// everything.
+func matches(sel *metav1.LabelSelector, set labels.Set) bool {
+	if sel == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	if err != nil {
+		return false
+	}
+	return s.Matches(set)
+}
@@ -0,0 +1,245 @@
+package netpol
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func TestFromNetworkPolicy(t *testing.T) {
+	t.Run("policy types", func(t *testing.T) {
+		tests := map[string]struct {
+			types           []networkingv1.PolicyType
+			rules           []networkingv1.NetworkPolicyEgressRule
+			ingress, egress bool
+		}{
+			"default":             {nil, nil, true, false},
+			"default with egress": {nil, []networkingv1.NetworkPolicyEgressRule{{}}, true, true},
+			"ingress":             {[]networkingv1.PolicyType{networkingv1.PolicyTypeIngress}, nil, true, false},
+			"egress":              {[]networkingv1.PolicyType{networkingv1.PolicyTypeEgress}, nil, false, true},
+			"ingress and egress":  {[]networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}, nil, true, true},
+		}
+
+		for n, tt := range tests {
+			t.Run(n, func(t *testing.T) {
+				p, err := FromNetworkPolicy(&networkingv1.NetworkPolicy{
+					Spec: networkingv1.NetworkPolicySpec{PolicyTypes: tt.types, Egress: tt.rules},
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.IsolatesIngress != tt.ingress || p.IsolatesEgress != tt.egress {
+					t.Fatalf("expecting isolation ingress=%v egress=%v, got ingress=%v egress=%v", tt.ingress, tt.egress, p.IsolatesIngress, p.IsolatesEgress)
+				}
+			})
+		}
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		udp := corev1.ProtocolUDP
+		p, err := FromNetworkPolicy(&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart"},
+			Spec: networkingv1.NetworkPolicySpec{
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{
+						To: []networkingv1.NetworkPolicyPeer{
+							{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.1/8", Except: []string{"10.1.0.0/16"}}},
+							{NamespaceSelector: &metav1.LabelSelector{}},
+						},
+						Ports: []networkingv1.NetworkPolicyPort{
+							{Port: ptr.To(intstr.FromInt32(53)), Protocol: &udp},
+							{Port: ptr.To(intstr.FromInt32(8000)), EndPort: ptr.To[int32](8080)},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if e, g := "NetworkPolicy shop/cart", p.String(); e != g {
+			t.Errorf("expecting %q, got %q", e, g)
+		}
+		if len(p.Egress) != 1 {
+			t.Fatalf("expecting 1 egress rule, got %d", len(p.Egress))
+		}
+
+		r := p.Egress[0]
+		if e, g := netip.MustParsePrefix("10.0.0.0/8"), r.Peers[0].IPBlock.CIDR; e != g {
+			t.Errorf("expecting masked CIDR %s, got %s", e, g)
+		}
+		if r.Peers[1].NamespaceSelector == nil || r.Peers[1].PodSelector != nil {
+			t.Errorf("expecting peer with only a namespace selector, got %+v", r.Peers[1])
+		}
+
+		var ports []string
+		for _, p := range r.Ports {
+			ports = append(ports, p.String())
+		}
+		if e := []string{"UDP/53", "TCP/8000-8080"}; !slices.Equal(e, ports) {
+			t.Errorf("expecting ports %v, got %v", e, ports)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := map[string]networkingv1.NetworkPolicySpec{
+			"cidr": {Egress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "foo"}}},
+			}}},
+			"selector": {PodSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: "Foo"},
+			}}},
+		}
+
+		for n, spec := range tests {
+			t.Run(n, func(t *testing.T) {
+				if _, err := FromNetworkPolicy(&networkingv1.NetworkPolicy{Spec: spec}); err == nil {
+					t.Fatal("expecting error, got nil")
+				}
+			})
+		}
+	})
+}
+
+func TestIPBlock_Contains(t *testing.T) {
+	b := IPBlock{
+		CIDR:   netip.MustParsePrefix("10.0.0.0/8"),
+		Except: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+	}
+
+	tests := map[string]bool{
+		"10.0.0.1":  true,
+		"10.2.3.4":  true,
+		"10.1.2.3":  false,
+		"192.0.2.1": false,
+	}
+
+	for in, exp := range tests {
+		if got := b.Contains(netip.MustParseAddr(in)); exp != got {
+			t.Errorf("%s: expecting %v, got %v", in, exp, got)
+		}
+	}
+
+	if b.Contains(netip.Addr{}) {
+		t.Error("invalid address should not be contained")
+	}
+}
+
+func TestSampleLabels(t *testing.T) {
+	tests := map[string]struct {
+		sel *metav1.LabelSelector
+		ok  bool
+	}{
+		"nil":   {nil, true},
+		"empty": {&metav1.LabelSelector{}, true},
+		"labels": {&metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "cart"},
+		}, true},
+		"expressions": {&metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}},
+				{Key: "team", Operator: metav1.LabelSelectorOpExists},
+				{Key: "canary", Operator: metav1.LabelSelectorOpDoesNotExist},
+			},
+		}, true},
+		"conflicting": {&metav1.LabelSelector{
+			MatchLabels: map[string]string{"app": "cart"},
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"cart"}},
+			},
+		}, false},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			set, ok := SampleLabels(tt.sel)
+			if tt.ok != ok {
+				t.Fatalf("expecting ok %v, got %v", tt.ok, ok)
+			}
+			if ok && !matches(tt.sel, set) {
+				t.Fatalf("sample labels %v don't match selector", set)
+			}
+		})
+	}
+}
+
+func TestInventory_ReadManifests(t *testing.T) {
+	const manifests = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+  labels:
+    team: shop
+---
+# empty documents are ignored
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: default-deny
+spec:
+  podSelector: {}
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: cart
+    namespace: shop
+  spec:
+    ports:
+    - port: 7070
+- apiVersion: example.com/v1
+  kind: Unknown
+  metadata:
+    name: ignored
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ignored
+  namespace: shop
+`
+
+	var inv Inventory
+	if err := inv.ReadManifests(strings.NewReader(manifests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(inv.Namespaces) != 1 || len(inv.Policies) != 1 || len(inv.Services) != 1 || len(inv.Pods) != 0 {
+		t.Fatalf("unexpected inventory: %+v", inv)
+	}
+	if e, g := corev1.NamespaceDefault, inv.Policies[0].Namespace; e != g {
+		t.Errorf("expecting policy namespace to default to %q, got %q", e, g)
+	}
+	if e, g := []string{"shop", "default"}, inv.NamespaceNames(); !slices.Equal(e, g) {
+		t.Errorf("expecting namespaces %v, got %v", e, g)
+	}
+
+	if e, g := (labels.Set{"team": "shop", corev1.LabelMetadataName: "shop"}), inv.NamespaceLabels("shop"); !labels.Equals(e, g) {
+		t.Errorf("expecting labels %v, got %v", e, g)
+	}
+	if e, g := (labels.Set{corev1.LabelMetadataName: "default"}), inv.NamespaceLabels("default"); !labels.Equals(e, g) {
+		t.Errorf("expecting labels %v, got %v", e, g)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		var inv Inventory
+		err := inv.ReadManifests(strings.NewReader("apiVersion: v1\nkind: Service\nspec: [foo"))
+		if err == nil {
+			t.Fatal("expecting error, got nil")
+		}
+	})
+}
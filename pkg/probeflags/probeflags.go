@@ -1,6 +1,11 @@
 package probeflags
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Probe arguments -- can be Flagified
 const (
@@ -9,6 +14,11 @@ const (
 	ArgExpectedStatus = "expected-status"
 	ArgExpectFail     = "expect-fail"
 	ArgType           = "type"
+	ArgListen         = "listen"
+	ArgRetries        = "retries"
+	ArgRetryInterval  = "retry-interval"
+	ArgRepeat         = "repeat"
+	ArgMinSuccessRate = "min-success-rate"
 )
 
 func Flagify(flag string) string {
@@ -20,3 +30,97 @@ const (
 	TestTypeHTTP = "http"
 	TestTypeDNS  = "dns"
 )
+
+// TimingsPrefix starts the line the probe prints its timings on, for
+// the runner to read them from the logs of its container.
+const TimingsPrefix = "nethax-timings: "
+
+// Timings are the timings of a probe run, as measured by the probe.
+type Timings struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Phases []Phase   `json:"phases,omitempty"`
+}
+
+// Phase is a step of a probe run, e.g. a DNS lookup or establishing a
+// TCP connection.
+type Phase struct {
+	Name string `json:"name"`
+	// Addr is the address or host name of the phase, if any.
+	Addr  string    `json:"addr,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// String returns the line the probe prints the timings on.
+func (t Timings) String() string {
+	return printLine(TimingsPrefix, t)
+}
+
+// ParseTimings returns the timings printed in the logs of a probe, and
+// whether there were any.
+func ParseTimings(logs string) (Timings, bool) {
+	var t Timings
+	if !parseLine(logs, TimingsPrefix, &t) {
+		return Timings{}, false
+	}
+	return t, true
+}
+
+// AttemptsPrefix starts the line the probe prints the outcome of its
+// attempts on, for the runner to tell flaky tests apart.
+const AttemptsPrefix = "nethax-attempts: "
+
+// Attempts is the outcome of the attempts of a probe run, which repeats
+// the probe and retries the repetitions that fail.
+type Attempts struct {
+	// Repetitions is the number of times the probe was repeated, and
+	// Passed how many of them passed, possibly after retries.
+	Repetitions int `json:"repetitions"`
+	Passed      int `json:"passed"`
+	// Attempts is the number of attempts made, including retries.
+	Attempts int `json:"attempts"`
+}
+
+// Flaky returns whether some attempts passed and others failed.
+func (a Attempts) Flaky() bool {
+	return a.Passed > 0 && a.Attempts > a.Passed
+}
+
+// String returns the line the probe prints the attempts on.
+func (a Attempts) String() string {
+	return printLine(AttemptsPrefix, a)
+}
+
+// ParseAttempts returns the attempts printed in the logs of a probe,
+// and whether there were any.
+func ParseAttempts(logs string) (Attempts, bool) {
+	var a Attempts
+	if !parseLine(logs, AttemptsPrefix, &a) {
+		return Attempts{}, false
+	}
+	return a, true
+}
+
+func printLine(prefix string, v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return prefix + "{}"
+	}
+	return prefix + string(b)
+}
+
+// parseLine decodes into v the first line of logs starting with
+// prefix, returning whether there was any.
+func parseLine(logs, prefix string, v any) bool {
+	for line := range strings.Lines(logs) {
+		s, ok := strings.CutPrefix(strings.TrimSpace(line), prefix)
+		if !ok {
+			continue
+		}
+
+		return json.Unmarshal([]byte(s), v) == nil
+	}
+
+	return false
+}
@@ -3,7 +3,9 @@ package probeflags
 import (
 	"flag"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestFlagify(t *testing.T) {
@@ -19,3 +21,67 @@ func TestFlagify(t *testing.T) {
 	}
 
 }
+
+func TestTimings(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	exp := Timings{
+		Start: start,
+		End:   start.Add(time.Second),
+		Phases: []Phase{
+			{Name: "dns", Addr: "grafana.com", Start: start, End: start.Add(time.Millisecond)},
+		},
+	}
+
+	logs := "Probe failed unexpectedly: connection failed\n" + exp.String() + "\n"
+
+	got, ok := ParseTimings(logs)
+	if !ok {
+		t.Fatalf("expecting timings in %q", logs)
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("expecting %+v, got %+v", exp, got)
+	}
+
+	for _, logs := range []string{"", "Probe succeeded\n", TimingsPrefix + "nope\n"} {
+		if _, ok := ParseTimings(logs); ok {
+			t.Errorf("expecting no timings in %q", logs)
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	exp := Attempts{Repetitions: 3, Passed: 3, Attempts: 5}
+
+	logs := "Probe succeeded\n" + exp.String() + "\n"
+
+	got, ok := ParseAttempts(logs)
+	if !ok {
+		t.Fatalf("expecting attempts in %q", logs)
+	}
+	if exp != got {
+		t.Errorf("expecting %+v, got %+v", exp, got)
+	}
+
+	if _, ok := ParseAttempts(Timings{}.String()); ok {
+		t.Error("expecting no attempts in timings")
+	}
+
+	tests := map[string]struct {
+		attempts Attempts
+		flaky    bool
+	}{
+		"passed":           {Attempts{Repetitions: 3, Passed: 3, Attempts: 3}, false},
+		"retried":          {Attempts{Repetitions: 1, Passed: 1, Attempts: 2}, true},
+		"some failed":      {Attempts{Repetitions: 4, Passed: 3, Attempts: 4}, true},
+		"failed":           {Attempts{Repetitions: 1, Passed: 0, Attempts: 3}, false},
+		"no attempts made": {Attempts{}, false},
+	}
+
+	for n, tt := range tests {
+		t.Run(n, func(t *testing.T) {
+			if g := tt.attempts.Flaky(); tt.flaky != g {
+				t.Errorf("expecting flaky %v, got %v", tt.flaky, g)
+			}
+		})
+	}
+}
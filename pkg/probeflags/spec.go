@@ -0,0 +1,124 @@
+package probeflags
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SpecVersion is the version of the specs the runner sends and the
+// probe accepts. It must be increased whenever a change to Spec would
+// make a probe of the previous version run a test differently than
+// specified, e.g. a new field changing the outcome of the probe.
+const SpecVersion = 1
+
+// ArgSpec is the probe argument holding its spec, in JSON, and EnvSpec
+// the environment variable holding it if the argument is not given.
+const (
+	ArgSpec = "spec"
+	EnvSpec = "NETHAX_PROBE_SPEC"
+)
+
+// DefaultTimeout is the timeout of each attempt, and
+// DefaultRetryInterval the time to wait before retrying a failed one,
+// if the spec doesn't set them.
+const (
+	DefaultTimeout       = 5 * time.Second
+	DefaultRetryInterval = time.Second
+)
+
+// Spec is what the runner asks the probe to test.
+type Spec struct {
+	// Version is SpecVersion of the runner.
+	Version int `json:"version"`
+	// Type is TestTypeHTTP, TestTypeTCP or TestTypeDNS.
+	Type string `json:"type"`
+	// Endpoint is the URL, host:port or host name to connect to, and
+	// Timeout the timeout of each attempt, or DefaultTimeout.
+	Endpoint string        `json:"endpoint"`
+	Timeout  time.Duration `json:"timeout,omitempty"`
+	// ExpectedStatus is the HTTP status code HTTP probes expect, 0 for
+	// a connection failure.
+	ExpectedStatus int `json:"expectedStatus,omitempty"`
+	// ExpectFail makes TCP and DNS probes pass if they fail to connect
+	// or resolve.
+	ExpectFail bool `json:"expectFail,omitempty"`
+	// Retries is how many times to retry a failed attempt, waiting
+	// RetryInterval, or DefaultRetryInterval, in between.
+	Retries       int           `json:"retries,omitempty"`
+	RetryInterval time.Duration `json:"retryInterval,omitempty"`
+	// Repeat is the number of times to repeat the probe, at least
+	// once, and MinSuccessRate the ratio of repetitions that must pass
+	// for the probe to pass, all of them if 0.
+	Repeat         int     `json:"repeat,omitempty"`
+	MinSuccessRate float64 `json:"minSuccessRate,omitempty"`
+}
+
+var (
+	ErrSpecVersion = errors.New("unsupported probe spec version")
+	ErrInvalidSpec = errors.New("invalid probe spec")
+)
+
+// Encode returns the spec in JSON, setting its version.
+func (s Spec) Encode() string {
+	s.Version = SpecVersion
+	b, err := json.Marshal(s)
+	if err != nil {
+		// only plain values are marshaled
+		panic(err)
+	}
+	return string(b)
+}
+
+// DecodeSpec returns the spec encoded in JSON in data, after checking
+// its version matches SpecVersion, so that runners and probes of
+// different versions don't silently run tests differently.
+func DecodeSpec(data string) (Spec, error) {
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return Spec{}, fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+	}
+	if v.Version != SpecVersion {
+		return Spec{}, fmt.Errorf("%w %d, this probe supports version %d: use a probe image of the same release as the runner",
+			ErrSpecVersion, v.Version, SpecVersion)
+	}
+
+	var s Spec
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return Spec{}, fmt.Errorf("%w: %w", ErrInvalidSpec, err)
+	}
+
+	return s, s.Validate()
+}
+
+// Validate returns an error if the spec can't be run.
+func (s Spec) Validate() error {
+	switch {
+	case !slices.Contains([]string{TestTypeHTTP, TestTypeTCP, TestTypeDNS}, s.Type):
+		return fmt.Errorf("%w: type %q", ErrInvalidSpec, s.Type)
+	case s.Endpoint == "":
+		return fmt.Errorf("%w: no endpoint", ErrInvalidSpec)
+	case s.Timeout < 0 || s.Retries < 0 || s.RetryInterval < 0 || s.Repeat < 0:
+		return fmt.Errorf("%w: negative timeout or attempts", ErrInvalidSpec)
+	case s.MinSuccessRate < 0 || s.MinSuccessRate > 1:
+		return fmt.Errorf("%w: success rate %v", ErrInvalidSpec, s.MinSuccessRate)
+	}
+	return nil
+}
+
+// MaxDuration returns how long the probe can take to run the spec when
+// all of its attempts time out.
+func (s Spec) MaxDuration() time.Duration {
+	timeout := cmp.Or(s.Timeout, DefaultTimeout)
+	interval := cmp.Or(s.RetryInterval, DefaultRetryInterval)
+	repetition := time.Duration(s.Retries+1)*timeout + time.Duration(s.Retries)*interval
+	return time.Duration(max(s.Repeat, 1)) * repetition
+}
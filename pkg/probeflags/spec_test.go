@@ -0,0 +1,73 @@
+package probeflags
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpec(t *testing.T) {
+	specs := []Spec{
+		{Type: TestTypeHTTP, Endpoint: "http://cart", Timeout: time.Second, ExpectedStatus: 200},
+		{Type: TestTypeHTTP, Endpoint: "http://cart", ExpectedStatus: 0},
+		{Type: TestTypeTCP, Endpoint: "cart:80", Timeout: time.Second, ExpectFail: true},
+		{Type: TestTypeDNS, Endpoint: "cart.shop", Timeout: time.Second, ExpectFail: true},
+		{
+			Type: TestTypeTCP, Endpoint: "cart:80", Timeout: 2 * time.Second,
+			Retries: 3, RetryInterval: 500 * time.Millisecond, Repeat: 10, MinSuccessRate: 0.9,
+		},
+	}
+
+	for _, s := range specs {
+		got, err := DecodeSpec(s.Encode())
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", s, err)
+			continue
+		}
+
+		s.Version = SpecVersion
+		if got != s {
+			t.Errorf("expecting spec\n%+v\ngot\n%+v", s, got)
+		}
+	}
+
+	for n, tt := range map[string]struct {
+		data string
+		err  error
+	}{
+		"older version":  {`{"version":0,"type":"tcp","endpoint":"cart:80"}`, ErrSpecVersion},
+		"newer version":  {`{"version":2,"type":"tcp","endpoint":"cart:80","proxy":"squid:3128"}`, ErrSpecVersion},
+		"unknown field":  {`{"version":1,"type":"tcp","endpoint":"cart:80","proxy":"squid:3128"}`, ErrInvalidSpec},
+		"not JSON":       {`--url cart:80`, ErrInvalidSpec},
+		"invalid type":   {`{"version":1,"type":"udp","endpoint":"cart:80"}`, ErrInvalidSpec},
+		"no endpoint":    {`{"version":1,"type":"tcp"}`, ErrInvalidSpec},
+		"negative":       {`{"version":1,"type":"tcp","endpoint":"cart:80","retries":-1}`, ErrInvalidSpec},
+		"success rate":   {`{"version":1,"type":"tcp","endpoint":"cart:80","minSuccessRate":1.5}`, ErrInvalidSpec},
+		"invalid timing": {`{"version":1,"type":"tcp","endpoint":"cart:80","timeout":"5s"}`, ErrInvalidSpec},
+	} {
+		t.Run(n, func(t *testing.T) {
+			if _, err := DecodeSpec(tt.data); !errors.Is(err, tt.err) {
+				t.Errorf("expecting error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	_, err := DecodeSpec(`{"version":2}`)
+	if !strings.Contains(err.Error(), "use a probe image of the same release as the runner") {
+		t.Errorf("expecting the error to explain the mismatch, got %v", err)
+	}
+}
+
+func TestSpecMaxDuration(t *testing.T) {
+	for exp, s := range map[time.Duration]Spec{
+		DefaultTimeout:   {Type: TestTypeTCP, Endpoint: "cart:80"},
+		time.Second:      {Type: TestTypeTCP, Endpoint: "cart:80", Timeout: time.Second},
+		8 * time.Second:  {Type: TestTypeTCP, Endpoint: "cart:80", Timeout: 2 * time.Second, Retries: 2, RetryInterval: time.Second},
+		25 * time.Second: {Type: TestTypeTCP, Endpoint: "cart:80", Timeout: 2 * time.Second, Retries: 1, Repeat: 5},
+	} {
+		if g := s.MaxDuration(); g != exp {
+			t.Errorf("%+v: expecting %s, got %s", s, exp, g)
+		}
+	}
+}